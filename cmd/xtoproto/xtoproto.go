@@ -13,7 +13,30 @@
 // limitations under the License.
 
 // Program xtoproto infers .proto definitions from record-oriented files (CSV,
-// XML, etc.).
+// XML, etc.), generates code from them, and converts records to protocol
+// buffers.
+//
+// Usage:
+//
+//	xtoproto <command> [flags] [args]
+//
+// The commands are:
+//
+//	infer      infer a RecordProtoMapping from example input files
+//	generate   generate .proto and converter code from a mapping
+//	convert    convert input files to protocol buffers using a mapping
+//	validate   check that mappings produce valid code
+//	rowstruct  generate a csvcoder row struct from a mapping, without protocol buffers
+//
+// Run "xtoproto <command> -help" for the flags of a command.
+//
+// The generate command can write code into the enclosing Go module, which is
+// intended for use with go generate:
+//
+//	//go:generate go run github.com/google/xtoproto/cmd/xtoproto generate -go_module -mapping=my_message.pbtxt
+//
+// When invoked without a command, xtoproto accepts the --codegen_request_json
+// flag used by the go_xtoproto_converter Bazel rule.
 package main
 
 import (
@@ -24,6 +47,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/google/xtoproto/service"
 	"google.golang.org/protobuf/encoding/prototext"
@@ -37,8 +61,6 @@ const (
 )
 
 var (
-	cfg = registerFlags(flag.CommandLine)
-
 	readFile service.FileReaderFunc = func(_ context.Context, path string) ([]byte, error) {
 		return ioutil.ReadFile(path)
 	}
@@ -51,81 +73,101 @@ var (
 	}
 )
 
-type config struct {
-	defaultWorkspaceDir         string
-	csvPath                     string
-	codegenRequestPath          string
-	overrideConverterOutputPath string
-	codegenRequestJSON          string
+// command is a subcommand of xtoproto.
+type command struct {
+	// usage is the synopsis of the command's arguments, not including the
+	// command name.
+	usage string
+	// description is a one line summary of the command.
+	description string
+	// run executes the command. The flags of the command should be registered
+	// on fs; run is responsible for parsing args with fs.
+	run func(ctx context.Context, fs *flag.FlagSet, args []string) error
 }
 
-func registerFlags(fs *flag.FlagSet) *config {
-	cfg := &config{}
-	fs.StringVar(&cfg.defaultWorkspaceDir, "default_workspace", "/tmp/example-workspace", "default workspace directory")
-	fs.StringVar(&cfg.csvPath, "csv", "", "path to input csv file")
-	fs.StringVar(&cfg.codegenRequestPath, "codegen_request", "", "if specified, a prototext-encoded GenerateCodeRequest to be issued")
-	fs.StringVar(&cfg.overrideConverterOutputPath, "codegen_convert_go_out", "", "path to output Go file - overrides value in codegen_request")
-	fs.StringVar(&cfg.codegenRequestJSON, "codegen_request_json", "", "JSON request from bazel")
-	return cfg
+var commands = map[string]*command{
+	"infer":     inferCommand,
+	"generate":  generateCommand,
+	"convert":   convertCommand,
+	"validate":  validateCommand,
+	"rowstruct": rowStructCommand,
 }
 
-func main() {
-	flag.Parse()
-	if err := run(context.Background()); err != nil {
-		fmt.Fprintf(os.Stderr, "fatal xtoproto error: %v", err)
-		os.Exit(1)
-	}
+// errUsage is returned by commands when the command line is invalid. The
+// usage message of the command is printed in addition to the error.
+type errUsage struct {
+	msg string
 }
 
-func run(ctx context.Context) error {
-	s := service.New(cfg.defaultWorkspaceDir, readFile, writeFile)
+func (e *errUsage) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &errUsage{fmt.Sprintf(format, a...)}
+}
 
-	if cfg.codegenRequestJSON != "" {
-		return runConverterCodeGen(ctx, s)
+func main() {
+	ctx := context.Background()
+	if len(os.Args) < 2 || len(os.Args[1]) == 0 || os.Args[1][0] == '-' {
+		flag.Usage = printUsage
+		flag.Parse()
+		if err := runLegacy(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal xtoproto error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	resp1, err := s.Infer(ctx, &spb.InferRequest{
-		GoPackageName: "example",
-		GoProtoImport: "not/sure",
-		InputFormat:   spb.Format_CSV,
-		MessageName:   "MyMessage",
-		PackageName:   "mypackage",
-		ExampleInputs: []*spb.InputFile{
-			{
-				Spec: &spb.InputFile_InputPath{
-					InputPath: cfg.csvPath,
-				},
-			},
-		},
-	})
-	if err != nil {
-		return err
+	name := os.Args[1]
+	if name == "help" {
+		printUsage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "xtoproto: unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
 	}
-	fmt.Printf("InferResponse:\n%s\n", prototext.Format(resp1))
-	req2 := &spb.GenerateCodeRequest{
-		Mapping: resp1.GetBestMappingCandidate().GetTopLevelMapping(),
-		ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{
-			Directory:        "generated",
-			ProtoFileName:    "example.proto",
-			UpdateBuildRules: true,
-		},
-		Converter: &spb.GenerateCodeRequest_Converter{
-			Directory:        "generated",
-			GoFileName:       "exampleconv.go",
-			UpdateBuildRules: true,
-		},
+	fs := flag.NewFlagSet("xtoproto "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xtoproto %s %s\n\n%s.\n\nFlags:\n", name, cmd.usage, cmd.description)
+		fs.PrintDefaults()
 	}
-	fmt.Printf("GenerateCodeRequest:\n%s\n", prototext.Format(req2))
+	if err := cmd.run(ctx, fs, os.Args[2:]); err != nil {
+		if _, ok := err.(*errUsage); ok {
+			fmt.Fprintf(os.Stderr, "xtoproto %s: %v\n\n", name, err)
+			fs.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "xtoproto %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
 
-	resp2, err := s.GenerateCode(ctx, req2)
-	if err != nil {
-		return err
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: xtoproto <command> [flags] [args]\n\nCommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
 	}
-	fmt.Printf("GenerateCodeResponse:\n%s\n", prototext.Format(resp2))
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nRun \"xtoproto <command> -help\" for the flags of a command.\n")
+}
 
-	return nil
+// newService returns the XToProtoService implementation used by the
+// commands. Files are read and written using the given functions.
+func newService(workspaceDir string, writeFile service.FileWriterFunc) spb.XToProtoServiceServer {
+	return service.New(workspaceDir, readFile, writeFile)
 }
 
+var codegenRequestJSON = flag.String("codegen_request_json", "", "JSON request from bazel")
+
 type bazelRequest struct {
 	PartialGenerateCodeRequestPath string        `json:"partial_request_path"`
 	ConverterGoOut                 bazelFilePath `json:"converter_go_output"`
@@ -137,9 +179,15 @@ type bazelFilePath struct {
 	Root      string `json:"root"`
 }
 
-func runConverterCodeGen(ctx context.Context, s spb.XToProtoServiceServer) error {
+// runLegacy handles invocations without a command, which are made by the
+// go_xtoproto_converter Bazel rule.
+func runLegacy(ctx context.Context) error {
+	if *codegenRequestJSON == "" {
+		printUsage()
+		return fmt.Errorf("missing command")
+	}
 	br := &bazelRequest{}
-	if err := json.Unmarshal([]byte(cfg.codegenRequestJSON), br); err != nil {
+	if err := json.Unmarshal([]byte(*codegenRequestJSON), br); err != nil {
 		return fmt.Errorf("bad request JSON: %w", err)
 	}
 	req := &spb.GenerateCodeRequest{}
@@ -156,10 +204,10 @@ func runConverterCodeGen(ctx context.Context, s spb.XToProtoServiceServer) error
 		UpdateBuildRules: false,
 	}
 	req.WorkspacePath = br.ConverterGoOut.Root
-	resp2, err := s.GenerateCode(ctx, req)
+	resp, err := newService(br.ConverterGoOut.Root, writeFile).GenerateCode(ctx, req)
 	if err != nil {
 		return fmt.Errorf("GenerateCode failed: %w", err)
 	}
-	fmt.Printf("GenerateCodeResponse:\n%s\n", prototext.Format(resp2))
+	fmt.Printf("GenerateCodeResponse:\n%s\n", prototext.Format(resp))
 	return nil
 }
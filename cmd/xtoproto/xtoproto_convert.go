@@ -0,0 +1,290 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/google/xtoproto/csvcoder"
+	"github.com/google/xtoproto/gomodgen"
+	"github.com/google/xtoproto/protocp"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	rpb "github.com/google/xtoproto/proto/recordtoproto"
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+var convertCommand = &command{
+	usage:       "-mapping=<file> [flags] <input file, directory or glob>...",
+	description: "convert input files to protocol buffers using a mapping",
+	run:         runConvert,
+}
+
+// outputFormats maps the values of the convert command's -output_format flag
+// to functions that create a protocp.MessageWriter.
+var outputFormats = map[string]func(w io.Writer) protocp.MessageWriter{
+	"textproto": func(w io.Writer) protocp.MessageWriter {
+		return protocp.NewTextprotoWriter(w, prototext.MarshalOptions{Multiline: true, Indent: "  "})
+	},
+	"jsonl": func(w io.Writer) protocp.MessageWriter {
+		return protocp.NewJSONLinesWriter(w, protojson.MarshalOptions{})
+	},
+	"delimited": protocp.NewDelimitedWriter,
+	"tfrecord":  protocp.NewTFRecordWriter,
+}
+
+func runConvert(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	mappingPath := fs.String("mapping", "", "path to a text-format RecordProtoMapping; required")
+	outputFormat := fs.String("output_format", "textproto", "format of the output: textproto, jsonl (one JSON message per line), delimited (varint length-prefixed binary messages), or tfrecord")
+	outPath := fs.String("out", "-", "path of the output file; \"-\" for stdout")
+	skipInvalidRows := fs.Bool("skip_invalid_rows", false, "skip rows that fail to convert instead of stopping; the same as -max_errors=-1")
+	maxErrors := fs.Int("max_errors", 0, "skip rows that fail to convert, stopping once more than this many rows are skipped in total; -1 for no limit")
+	deadLetterPath := fs.String("dead_letter", "", "path of a JSON Lines file that receives the rows that fail to convert; implies -skip_invalid_rows unless -max_errors is set")
+	fs.Parse(args)
+
+	if *mappingPath == "" {
+		return usageErrorf("-mapping is required")
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("at least one input file is required")
+	}
+	newWriter, ok := outputFormats[*outputFormat]
+	if !ok {
+		return usageErrorf("unknown -output_format %q", *outputFormat)
+	}
+	maxErrorsSet := false
+	fs.Visit(func(f *flag.Flag) {
+		maxErrorsSet = maxErrorsSet || f.Name == "max_errors"
+	})
+	if *skipInvalidRows || (*deadLetterPath != "" && !maxErrorsSet) {
+		*maxErrors = -1
+	}
+	mapping, err := gomodgen.ReadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var opts []protocp.CopierOption
+	switch {
+	case *deadLetterPath != "":
+		f, err := os.Create(*deadLetterPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts = append(opts, protocp.DeadLetters(protocp.NewDeadLetterWriter(f), *maxErrors))
+	case *maxErrors != 0:
+		opts = append(opts, protocp.SkipErrors(*maxErrors))
+	}
+	if field := mapping.GetSourceFileField(); field != "" {
+		// The service only sees the contents of each file, so the copier
+		// sets the field instead.
+		opts = append(opts, protocp.SourceFileField(field))
+	}
+
+	// Cancelling the context stops conversions that are left unread when the
+	// copy fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	svc := newService("", writeFile)
+	cp := protocp.NewFileCopier(func(r io.Reader, fileName string) (protocp.MessageReader, error) {
+		return convertWithService(ctx, svc, mapping, r, fileName)
+	}, opts...)
+	err = cp.CopyFiles(ctx, protocp.LocalFileSystem{}, fs.Args(), newWriter(out))
+	fmt.Fprintln(os.Stderr, cp.Stats().Summary())
+	if err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertWithService converts the contents of a single input file, which have
+// already been decompressed, with the ConvertStream method of svc. It returns a
+// reader of the converted messages and of the errors of the rows that failed
+// to convert, in the order of the rows. The conversion runs as the reader is
+// read, so only one batch of converted records is held in memory at a time.
+func convertWithService(ctx context.Context, svc spb.XToProtoServiceServer, mapping *rpb.RecordProtoMapping, r io.Reader, fileName string) (protocp.MessageReader, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	stream := &convertStream{ctx: ctx, responses: make(chan *spb.ConvertResponse)}
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.ConvertStream(&spb.ConvertRequest{
+			Mapping:        mapping,
+			Inputs:         []*spb.InputFile{{Spec: &spb.InputFile_InputContent{InputContent: contents}}},
+			InputFormat:    spb.Format_CSV,
+			OutputEncoding: spb.ConvertRequest_SERIALIZED,
+		}, stream)
+		close(stream.responses)
+	}()
+	reader := &convertedRecordReader{fileName: fileName, responses: stream.responses, done: done}
+	first, err := reader.next()
+	if err != nil {
+		return nil, err
+	}
+	if reader.msgType, err = convertedMessageType(first); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// convertStream is an in-process XToProtoService_ConvertStreamServer that
+// passes each response to a channel. Send blocks until the response is
+// received or the context is done.
+type convertStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan *spb.ConvertResponse
+}
+
+func (s *convertStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *convertStream) Send(resp *spb.ConvertResponse) error {
+	select {
+	case s.responses <- resp:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// convertedMessageType returns a dynamic type for the messages of a
+// ConvertResponse.
+func convertedMessageType(resp *spb.ConvertResponse) (protoreflect.MessageType, error) {
+	fd, err := protodesc.NewFile(resp.GetProtoFile(), protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proto file in Convert response: %w", err)
+	}
+	name := protoreflect.FullName(resp.GetMessageType())
+	msgs := fd.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		if msgs.Get(i).FullName() == name {
+			return dynamicpb.NewMessageType(msgs.Get(i)), nil
+		}
+	}
+	return nil, fmt.Errorf("Convert response has no definition of message %s", name)
+}
+
+// convertedRecordReader is a protocp.MessageReader of the records and errors
+// of the ConvertStream responses for a single input.
+type convertedRecordReader struct {
+	msgType   protoreflect.MessageType
+	fileName  string
+	responses <-chan *spb.ConvertResponse
+	// done receives the result of ConvertStream after responses is closed.
+	done <-chan error
+
+	// records and errors are the unread entries of the current response.
+	records []*spb.ConvertedRecord
+	errors  []*spb.RecordError
+}
+
+// next makes the next response current and returns it. It returns io.EOF once
+// the conversion has finished.
+func (r *convertedRecordReader) next() (*spb.ConvertResponse, error) {
+	resp, ok := <-r.responses
+	if !ok {
+		if err := <-r.done; err != nil {
+			return nil, fmt.Errorf("error converting %s: %w", r.fileName, err)
+		}
+		return nil, io.EOF
+	}
+	r.records, r.errors = resp.GetRecords(), resp.GetErrors()
+	return resp, nil
+}
+
+// ReadMessage returns the message of the next row, or a *convertError if the
+// row failed to convert.
+func (r *convertedRecordReader) ReadMessage() (proto.Message, error) {
+	for len(r.records) == 0 && len(r.errors) == 0 {
+		if _, err := r.next(); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.records) == 0 || (len(r.errors) != 0 && r.errors[0].GetPosition().GetRowNumber() < r.records[0].GetPosition().GetRowNumber()) {
+		recErr := r.errors[0]
+		r.errors = r.errors[1:]
+		row := csvcoder.RowNumber(recErr.GetPosition().GetRowNumber() - 1)
+		return nil, &convertError{
+			position: csvcoder.NewRow(nil, nil, row, r.fileName).PositionString(),
+			raw:      recErr.GetRawRecord(),
+			problems: recErr.GetProblems(),
+		}
+	}
+	rec := r.records[0]
+	r.records = r.records[1:]
+	msg := r.msgType.New().Interface()
+	if err := proto.Unmarshal(rec.GetSerialized(), msg); err != nil {
+		return nil, fmt.Errorf("error decoding row %d of %s: %w", rec.GetPosition().GetRowNumber(), r.fileName, err)
+	}
+	return msg, nil
+}
+
+// convertError is a row that failed to convert. It implements
+// protocp.RecordError.
+type convertError struct {
+	position string
+	raw      string
+	problems []string
+}
+
+func (e *convertError) Error() string {
+	return fmt.Sprintf("%s: %s", e.position, strings.Join(e.problems, "; "))
+}
+
+func (e *convertError) RecordPosition() string { return e.position }
+
+func (e *convertError) RawRecord() string { return e.raw }
+
+func (e *convertError) RecordErrors() []error {
+	var errs []error
+	for _, p := range e.problems {
+		errs = append(errs, errors.New(p))
+	}
+	return errs
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/xtoproto/gomodgen"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+var generateCommand = &command{
+	usage:       "-mapping=<file> [flags]",
+	description: "generate .proto and converter code from a mapping",
+	run:         runGenerate,
+}
+
+func runGenerate(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	mappingPath := fs.String("mapping", "", "path to a text-format RecordProtoMapping; required")
+	workspace := fs.String("workspace", ".", "workspace root directory; other paths are relative to it")
+	protoDir := fs.String("proto_dir", "", "directory of the generated .proto file; with -go_module, defaults to a subdirectory of -converter_dir named after the message")
+	protoFileName := fs.String("proto_file", "", "name of the generated .proto file; defaults to the snake_case message name")
+	converterDir := fs.String("converter_dir", "", "directory of the generated converter package")
+	goFileName := fs.String("go_file", "", "name of the generated converter .go file; defaults to the snake_case message name")
+	goImportPath := fs.String("go_import_path", "", "Go import path of the converter package, used for the importpath of its Bazel rule")
+	updateBuildRules := fs.Bool("update_build_rules", false, "create or update Bazel rules for the generated files")
+	xtoprotoRepository := fs.String("xtoproto_repository", "", "with -update_build_rules, the name of the Bazel repository of xtoproto in the workspace; defaults to \"xtoproto\"")
+	goModule := fs.Bool("go_module", false, "generate code into the Go module enclosing -converter_dir, including the .pb.go file; -workspace and Bazel flags are ignored")
+	protocCommand := fs.String("protoc", "", "with -go_module, a command run in -proto_dir with the .proto file name appended to generate the .pb.go file, e.g. \"protoc --go_out=paths=source_relative:.\"; if empty, the .pb.go file is generated without protoc")
+	overwrite := fs.String("overwrite", "always", "what to do when an output file exists: always overwrite it, never overwrite it, or overwrite it only if it is unchanged since it was generated (if_unchanged)")
+	dryRun := fs.Bool("dry_run", false, "print a unified diff of the changes instead of writing files")
+	fs.Parse(args)
+
+	if *mappingPath == "" {
+		return usageErrorf("-mapping is required")
+	}
+	if fs.NArg() != 0 {
+		return usageErrorf("unexpected arguments %q", fs.Args())
+	}
+	if *protocCommand != "" && strings.TrimSpace(*protocCommand) == "" {
+		return usageErrorf("-protoc must name a command")
+	}
+	if *dryRun && *protocCommand != "" {
+		return usageErrorf("-dry_run may not be used with -protoc")
+	}
+	policy, ok := spb.GenerateCodeRequest_OverwritePolicy_value["OVERWRITE_"+strings.ToUpper(*overwrite)]
+	if !ok {
+		return usageErrorf("unknown -overwrite value %q", *overwrite)
+	}
+	mapping, err := gomodgen.ReadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+
+	if *goModule {
+		dir := *converterDir
+		if dir == "" {
+			dir = "."
+		}
+		result, err := gomodgen.Generate(ctx, newService("", writeFile), readFile, writeFile, mapping, &gomodgen.Options{
+			ConverterDir:    dir,
+			ProtoDir:        *protoDir,
+			ProtocCommand:   *protocCommand,
+			OverwritePolicy: spb.GenerateCodeRequest_OverwritePolicy(policy),
+			DryRun:          *dryRun,
+		})
+		if err != nil {
+			return err
+		}
+		printFiles(result.Module.Dir, *dryRun, result.Response, result.PBGoFile)
+		return nil
+	}
+
+	if mapping.GetGoOptions().GetGoPackageName() == "" {
+		return fmt.Errorf("%s: go_options.go_package_name must be specified unless -go_module is set", *mappingPath)
+	}
+	workspaceDir, err := filepath.Abs(*workspace)
+	if err != nil {
+		return err
+	}
+	resp, err := newService(workspaceDir, writeFile).GenerateCode(ctx, &spb.GenerateCodeRequest{
+		Mapping:       mapping,
+		WorkspacePath: workspaceDir,
+		ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{
+			Directory:        filepath.ToSlash(*protoDir),
+			ProtoFileName:    *protoFileName,
+			UpdateBuildRules: *updateBuildRules,
+		},
+		Converter: &spb.GenerateCodeRequest_Converter{
+			Directory:          filepath.ToSlash(*converterDir),
+			GoFileName:         *goFileName,
+			UpdateBuildRules:   *updateBuildRules,
+			GoImportPath:       *goImportPath,
+			XtoprotoRepository: *xtoprotoRepository,
+		},
+		OverwritePolicy: spb.GenerateCodeRequest_OverwritePolicy(policy),
+		DryRun:          *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	printFiles(workspaceDir, *dryRun, resp)
+	return nil
+}
+
+// printFiles prints the paths of the files in a GenerateCodeResponse, or their
+// diffs for a dry run.
+func printFiles(workspaceDir string, dryRun bool, resp *spb.GenerateCodeResponse, extra ...*spb.GenerateCodeResponse_File) {
+	seen := make(map[string]bool)
+	files := append([]*spb.GenerateCodeResponse_File{
+		resp.GetProtoFile(),
+		resp.GetProtoBuildFile(),
+		resp.GetConverterGoFile(),
+		resp.GetConverterBuildFile(),
+	}, extra...)
+	for _, f := range files {
+		if f == nil || seen[f.GetWorkspaceRelativePath()] {
+			continue
+		}
+		seen[f.GetWorkspaceRelativePath()] = true
+		if dryRun {
+			fmt.Print(f.GetUnifiedDiff())
+			continue
+		}
+		fmt.Printf("wrote %s\n", filepath.Join(workspaceDir, filepath.FromSlash(f.GetWorkspaceRelativePath())))
+	}
+}
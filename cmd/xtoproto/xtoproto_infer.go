@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+// mappingFileHeader is written at the top of mapping files so that editors and
+// tools know how to interpret the text proto.
+const mappingFileHeader = `# proto-file: github.com/google/xtoproto/proto/recordtoproto/recordtoproto.proto
+# proto-message: xtoproto.RecordProtoMapping
+
+`
+
+var inferCommand = &command{
+	usage:       "[flags] <example input file>...",
+	description: "infer a RecordProtoMapping from example input files",
+	run:         runInfer,
+}
+
+func runInfer(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "csv", "format of the input files; only csv is supported")
+	messageName := fs.String("message", "", "name of the output message; required")
+	packageName := fs.String("package", "", "proto package of the output message")
+	goPackageName := fs.String("go_package", "", "Go package name of the generated converter")
+	goProtoImport := fs.String("go_proto_import", "", "Go import path of the package generated for the .proto file")
+	timezone := fs.String("timezone", "", "IANA time zone used for timestamps without an explicit time zone; defaults to UTC")
+	unionHeaders := fs.Bool("union_headers", false, "combine the columns of all input files instead of requiring their headers to match")
+	outPath := fs.String("out", "-", "path of the output mapping text proto; \"-\" for stdout")
+	fs.Parse(args)
+
+	if *messageName == "" {
+		return usageErrorf("-message is required")
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("at least one example input file is required")
+	}
+	inputFormat, ok := spb.Format_value[strings.ToUpper(*format)]
+	if !ok || inputFormat == int32(spb.Format_UNSPECIFIED_FORMAT) {
+		return usageErrorf("unknown -format %q", *format)
+	}
+	if spb.Format(inputFormat) != spb.Format_CSV {
+		return usageErrorf("-format %s is not supported yet; only csv input can be inferred", *format)
+	}
+
+	req := &spb.InferRequest{
+		InputFormat:       spb.Format(inputFormat),
+		MessageName:       *messageName,
+		PackageName:       *packageName,
+		GoPackageName:     *goPackageName,
+		GoProtoImport:     *goProtoImport,
+		TimestampLocation: *timezone,
+	}
+	if *unionHeaders {
+		req.HeaderMerge = spb.InferRequest_UNION_HEADERS
+	}
+	for _, path := range fs.Args() {
+		req.ExampleInputs = append(req.ExampleInputs, &spb.InputFile{
+			Spec: &spb.InputFile_InputPath{InputPath: path},
+		})
+	}
+	resp, err := newService("", writeFile).Infer(ctx, req)
+	if err != nil {
+		return err
+	}
+	for _, c := range resp.GetConflicts() {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", c.GetInputName(), c.GetDescription())
+	}
+	text, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(resp.GetBestMappingCandidate().GetTopLevelMapping())
+	if err != nil {
+		return err
+	}
+	out := append([]byte(mappingFileHeader), text...)
+	if *outPath == "-" {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	if err := writeFile(ctx, *outPath, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", *outPath)
+	return nil
+}
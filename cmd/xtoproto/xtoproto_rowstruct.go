@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/xtoproto/csvtoproto"
+	"github.com/google/xtoproto/gomodgen"
+)
+
+var rowStructCommand = &command{
+	usage:       "-mapping=<file> [-package=<name>] [-out=<file>]",
+	description: "generate a csvcoder row struct from a mapping, without protocol buffers",
+	run:         runRowStruct,
+}
+
+func runRowStruct(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	mappingPath := fs.String("mapping", "", "path to a text-format RecordProtoMapping; required")
+	packageName := fs.String("package", "", "package name of the generated file; defaults to go_options.go_package_name of the mapping")
+	out := fs.String("out", "", "path of the generated .go file; if empty, the code is printed to standard output")
+	fs.Parse(args)
+
+	if *mappingPath == "" {
+		return usageErrorf("-mapping is required")
+	}
+	if fs.NArg() != 0 {
+		return usageErrorf("unexpected arguments %q", fs.Args())
+	}
+	mapping, err := gomodgen.ReadMapping(*mappingPath)
+	if err != nil {
+		return err
+	}
+	if err := csvtoproto.Validate(mapping); err != nil {
+		return err
+	}
+	code, err := csvtoproto.GenerateRowStructCode(mapping, *packageName)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Print(code)
+		return nil
+	}
+	if err := writeFile(ctx, *out, []byte(code)); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", *out)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/xtoproto/csvtoproto"
+	"github.com/google/xtoproto/gomodgen"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+var validateCommand = &command{
+	usage:       "<mapping file>...",
+	description: "check that mappings produce valid code",
+	run:         runValidate,
+}
+
+func runValidate(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return usageErrorf("at least one mapping file is required")
+	}
+	failed := 0
+	for _, path := range fs.Args() {
+		if err := validateMapping(ctx, path); err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d mappings are invalid", failed, fs.NArg())
+	}
+	return nil
+}
+
+// validateMapping checks a mapping file by generating code for it without
+// writing any files and compiling the generated .proto file.
+func validateMapping(ctx context.Context, path string) error {
+	mapping, err := gomodgen.ReadMapping(path)
+	if err != nil {
+		return err
+	}
+	if err := csvtoproto.Validate(mapping); err != nil {
+		return err
+	}
+	if mapping.GoOptions == nil {
+		mapping.GoOptions = &pb.GoOptions{}
+	}
+	if mapping.GoOptions.GetGoPackageName() == "" {
+		// generate -go_module derives the package name from the converter
+		// directory, so mappings written by infer usually leave it empty.
+		mapping.GoOptions.GoPackageName = "validate"
+	}
+	if mapping.GoOptions.GetProtoImport() == "" {
+		// Compiling a .proto file without a go_package option logs a warning
+		// that is not relevant to validation.
+		mapping.GoOptions.ProtoImport = "example.com/xtoproto/validate"
+	}
+	discard := func(context.Context, string, []byte) error { return nil }
+	resp, err := newService("/", discard).GenerateCode(ctx, &spb.GenerateCodeRequest{
+		Mapping:         mapping,
+		ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{},
+		Converter:       &spb.GenerateCodeRequest_Converter{},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := gomodgen.CompileProto(resp.GetProtoFile().GetWorkspaceRelativePath(), resp.GetProtoFile().GetNewContents()); err != nil {
+		return err
+	}
+	return nil
+}
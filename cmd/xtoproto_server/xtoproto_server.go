@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Program xtoproto_server serves the XToProtoService gRPC API.
+//
+// All file access by the service is confined to the directory given by the
+// --workspace_root flag. Relative paths in requests are resolved against that
+// directory, and it is the default workspace for GenerateCode requests.
+//
+// The server also exposes the standard gRPC health service and server
+// reflection, so it can be used with tools like grpcurl:
+//
+//	bazel run //cmd/xtoproto_server -- --workspace_root=$PWD --addr=localhost:8082
+//	grpcurl -plaintext -d '{"mapping": {...}}' localhost:8082 xtoproto.XToProtoService/GenerateCode
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/google/xtoproto/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/reflection"
+
+	spb "github.com/google/xtoproto/proto/service"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	addr          = flag.String("addr", "localhost:8082", "address to use for gRPC serving")
+	workspaceRoot = flag.String("workspace_root", "", "directory that the service may read and write files in; required")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *workspaceRoot == "" {
+		return fmt.Errorf("--workspace_root must be specified")
+	}
+	s, err := newServer(*workspaceRoot)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("serving XToProtoService at %s with workspace root %s", lis.Addr(), *workspaceRoot)
+	return s.Serve(lis)
+}
+
+// newServer returns a gRPC server with the XToProtoService, health, and
+// reflection services registered. The XToProtoService may only access files
+// within workspaceRoot.
+func newServer(workspaceRoot string) (*grpc.Server, error) {
+	sb, err := newSandbox(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	s := grpc.NewServer()
+	spb.RegisterXToProtoServiceServer(s, service.New(sb.root, sb.readFile, sb.writeFile))
+
+	hs := health.NewServer()
+	hs.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	reflection.Register(s)
+	return s, nil
+}
+
+// serviceName is the fully qualified name of the XToProtoService, which is
+// also its name in the health service.
+const serviceName = "xtoproto.XToProtoService"
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	outDirMode  os.FileMode = 0770
+	outFileMode os.FileMode = 0660
+)
+
+// sandbox confines file access to a root directory. Its readFile and
+// writeFile methods are used as the service's FileReaderFunc and
+// FileWriterFunc.
+type sandbox struct {
+	// root is the absolute path of the root directory with symbolic links
+	// resolved.
+	root string
+}
+
+func newSandbox(root string) (*sandbox, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace root: %w", err)
+	}
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace root: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("invalid workspace root: %s is not a directory", root)
+	}
+	return &sandbox{resolved}, nil
+}
+
+func (sb *sandbox) readFile(_ context.Context, path string) ([]byte, error) {
+	p, err := sb.resolve("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+func (sb *sandbox) writeFile(_ context.Context, path string, data []byte) error {
+	p, err := sb.resolve("write", path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), outDirMode); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, outFileMode)
+}
+
+// resolve returns the path to use for accessing the given file, or an error
+// wrapping os.ErrPermission if the path is outside of the sandbox. Relative
+// paths are relative to the root directory.
+//
+// Symbolic links are followed when checking whether a path is inside the
+// sandbox, so a link inside the root that points outside of it cannot be used
+// to escape.
+func (sb *sandbox) resolve(op, path string) (string, error) {
+	p := path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(sb.root, p)
+	}
+	p = filepath.Clean(p)
+
+	// Resolve symbolic links in the longest existing prefix of the path; the
+	// remaining elements do not exist yet and cannot be links.
+	existing, rest := p, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			p = filepath.Join(resolved, rest)
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", &os.PathError{Op: op, Path: path, Err: err}
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	rel, err := filepath.Rel(sb.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	}
+	return p, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+	spb "github.com/google/xtoproto/proto/service"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// startServer starts a server with the given workspace root and returns a
+// client connection to it.
+func startServer(t *testing.T, workspaceRoot string) *grpc.ClientConn {
+	t.Helper()
+	s, err := newServer(workspaceRoot)
+	if err != nil {
+		t.Fatalf("newServer() failed: %v", err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "xtoproto_server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	root := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(root, "input.csv"), []byte("a,b\n1,thing\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	conn := startServer(t, root)
+	client := spb.NewXToProtoServiceClient(conn)
+
+	t.Run("health", func(t *testing.T) {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: serviceName})
+		if err != nil {
+			t.Fatalf("Check() failed: %v", err)
+		}
+		if got, want := resp.GetStatus(), healthpb.HealthCheckResponse_SERVING; got != want {
+			t.Errorf("Check() got status %v, want %v", got, want)
+		}
+	})
+
+	t.Run("reflection", func(t *testing.T) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatalf("ServerReflectionInfo() failed: %v", err)
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, s := range resp.GetListServicesResponse().GetService() {
+			if s.GetName() == serviceName {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("reflection does not list %s: %v", serviceName, resp)
+		}
+	})
+
+	var mapping *pb.RecordProtoMapping
+	t.Run("infer from workspace file", func(t *testing.T) {
+		resp, err := client.Infer(ctx, &spb.InferRequest{
+			ExampleInputs: []*spb.InputFile{
+				{Spec: &spb.InputFile_InputPath{InputPath: "input.csv"}},
+			},
+			InputFormat:   spb.Format_CSV,
+			MessageName:   "MyMessage",
+			PackageName:   "my_package",
+			GoPackageName: "my_message_converter",
+		})
+		if err != nil {
+			t.Fatalf("Infer() failed: %v", err)
+		}
+		mapping = resp.GetBestMappingCandidate().GetTopLevelMapping()
+		var gotCols []string
+		for _, c2f := range mapping.GetColumnToFieldMappings() {
+			gotCols = append(gotCols, c2f.GetColName())
+		}
+		if diff := cmp.Diff([]string{"a", "b"}, gotCols); diff != "" {
+			t.Errorf("unexpected columns in inferred mapping (-want,+got): %s", diff)
+		}
+	})
+
+	t.Run("infer stream", func(t *testing.T) {
+		stream, err := client.InferStream(ctx)
+		if err != nil {
+			t.Fatalf("InferStream() failed: %v", err)
+		}
+		reqs := []*spb.InferStreamRequest{
+			{Request: &spb.InferStreamRequest_Options{Options: &spb.InferRequest{
+				InputFormat: spb.Format_CSV,
+				MessageName: "MyMessage",
+			}}},
+			{Request: &spb.InferStreamRequest_Chunk{Chunk: &spb.InputChunk{FileName: "upload.csv", Content: []byte("a,b\n1,")}}},
+			{Request: &spb.InferStreamRequest_Chunk{Chunk: &spb.InputChunk{FileName: "upload.csv", Content: []byte("thing\n")}}},
+		}
+		for _, req := range reqs {
+			if err := stream.Send(req); err != nil {
+				t.Fatalf("Send() failed: %v", err)
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		var result *spb.InferResponse
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv() failed: %v", err)
+			}
+			if resp.GetResult() != nil {
+				result = resp.GetResult()
+			}
+		}
+		if got := len(result.GetBestMappingCandidate().GetTopLevelMapping().GetColumnToFieldMappings()); got != 2 {
+			t.Errorf("InferStream() result has %d columns, want 2: %v", got, result)
+		}
+	})
+
+	t.Run("generate code in workspace", func(t *testing.T) {
+		resp, err := client.GenerateCode(ctx, &spb.GenerateCodeRequest{
+			Mapping:         mapping,
+			ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{Directory: "protos"},
+			Converter:       &spb.GenerateCodeRequest_Converter{Directory: "conv"},
+		})
+		if err != nil {
+			t.Fatalf("GenerateCode() failed: %v", err)
+		}
+		for _, f := range []*spb.GenerateCodeResponse_File{resp.GetProtoFile(), resp.GetConverterGoFile()} {
+			got, err := ioutil.ReadFile(filepath.Join(root, f.GetWorkspaceRelativePath()))
+			if err != nil {
+				t.Fatalf("generated file was not written: %v", err)
+			}
+			if diff := cmp.Diff(string(f.GetNewContents()), string(got)); diff != "" {
+				t.Errorf("unexpected diff in %s (-want,+got): %s", f.GetWorkspaceRelativePath(), diff)
+			}
+		}
+	})
+
+	t.Run("convert stream", func(t *testing.T) {
+		stream, err := client.ConvertStream(ctx, &spb.ConvertRequest{
+			Mapping: mapping,
+			Inputs: []*spb.InputFile{
+				{Spec: &spb.InputFile_InputPath{InputPath: "input.csv"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("ConvertStream() failed: %v", err)
+		}
+		var records []*spb.ConvertedRecord
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv() failed: %v", err)
+			}
+			records = append(records, resp.GetRecords()...)
+		}
+		if len(records) != 1 || records[0].GetAny().GetTypeUrl() != "type.googleapis.com/my_package.MyMessage" {
+			t.Errorf("ConvertStream() got unexpected records %v", records)
+		}
+	})
+
+	outside := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret.csv"), []byte("a,b\n1,2\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "infer from absolute path outside workspace",
+			call: func() error {
+				_, err := client.Infer(ctx, &spb.InferRequest{
+					ExampleInputs: []*spb.InputFile{
+						{Spec: &spb.InputFile_InputPath{InputPath: filepath.Join(outside, "secret.csv")}},
+					},
+					InputFormat: spb.Format_CSV,
+					MessageName: "MyMessage",
+				})
+				return err
+			},
+		},
+		{
+			name: "infer from relative path outside workspace",
+			call: func() error {
+				_, err := client.Infer(ctx, &spb.InferRequest{
+					ExampleInputs: []*spb.InputFile{
+						{Spec: &spb.InputFile_InputPath{InputPath: "../" + filepath.Base(outside) + "/secret.csv"}},
+					},
+					InputFormat: spb.Format_CSV,
+					MessageName: "MyMessage",
+				})
+				return err
+			},
+		},
+		{
+			name: "infer through symbolic link",
+			call: func() error {
+				_, err := client.Infer(ctx, &spb.InferRequest{
+					ExampleInputs: []*spb.InputFile{
+						{Spec: &spb.InputFile_InputPath{InputPath: "link/secret.csv"}},
+					},
+					InputFormat: spb.Format_CSV,
+					MessageName: "MyMessage",
+				})
+				return err
+			},
+		},
+		{
+			name: "generate code outside workspace",
+			call: func() error {
+				_, err := client.GenerateCode(ctx, &spb.GenerateCodeRequest{
+					Mapping:         mapping,
+					WorkspacePath:   outside,
+					ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{},
+				})
+				return err
+			},
+		},
+		{
+			name: "generate code through symbolic link",
+			call: func() error {
+				_, err := client.GenerateCode(ctx, &spb.GenerateCodeRequest{
+					Mapping:         mapping,
+					ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{Directory: "link/new"},
+				})
+				return err
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != codes.PermissionDenied {
+				t.Errorf("got code %v, want %v", got, codes.PermissionDenied)
+			}
+		})
+	}
+	if files, err := ioutil.ReadDir(outside); err != nil || len(files) != 1 {
+		t.Errorf("files were written outside of the workspace root: %v, %v", files, err)
+	}
+}
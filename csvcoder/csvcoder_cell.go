@@ -18,7 +18,7 @@ import (
 	"encoding"
 	"fmt"
 	"reflect"
-	"sync"
+	"sync/atomic"
 
 	"github.com/google/xtoproto/textcoder"
 )
@@ -29,7 +29,6 @@ var (
 		ptr := &i
 		return reflect.TypeOf(ptr).Elem()
 	}()
-	defaultRegistryMapMutex = sync.RWMutex{}
 )
 
 // CellContext is passed to the function parsing a single CSV cell.
@@ -48,11 +47,25 @@ func (c *CellContext) Row() *Row {
 	return c.r
 }
 
+// textContext returns the base context for decoding the cell, which is a new
+// context of r unless the file has one.
+func (c *CellContext) textContext(r *textcoder.Registry) *textcoder.Context {
+	if c != nil && c.r != nil && c.r.textContext != nil {
+		return c.r.textContext
+	}
+	return r.NewContext()
+}
+
 // ParseCell parses a single CSV cell's textual value into dst.
 func ParseCell(ctx *CellContext, value string, dst interface{}) error {
+	return defaultRegistry.ParseCell(ctx, value, dst)
+}
+
+// ParseCell is like the ParseCell function, but uses the cell parsers of r.
+func (r *Registry) ParseCell(ctx *CellContext, value string, dst interface{}) error {
 	outV := reflect.ValueOf(dst)
 	outType := outV.Type()
-	cp, err := getOrCreateCellParserForType(outType)
+	cp, err := r.getOrCreateCellParserForType(outType)
 	if err != nil {
 		return fmt.Errorf("could not parce value %q: %w", value, err)
 	}
@@ -61,52 +74,37 @@ func ParseCell(ctx *CellContext, value string, dst interface{}) error {
 
 // registeredCellParser is used ins
 type registeredCellParser struct {
+	// current holds a cellParserImpl. It is replaced rather than modified so
+	// that cell parsers may be registered while other goroutines parse cells.
+	current      atomic.Value
+	textRegistry *textcoder.Registry
+}
+
+// cellParserImpl is the implementation of a registeredCellParser: a registered
+// cell parser, or else a textcoder decoder.
+type cellParserImpl struct {
 	impl    cellParser
 	decoder textcoder.Decoder
 }
 
+func (cp *registeredCellParser) load() cellParserImpl {
+	impl, _ := cp.current.Load().(cellParserImpl)
+	return impl
+}
+
 // ParseCSVCell dispatches to the underlying implementation. This indirection
 // allows newly registered cell parsers to override the older registered value.
 func (cp *registeredCellParser) ParseCSVCell(ctx *CellContext, value string, field reflect.Value) error {
-	if cp.impl != nil {
-		return cp.impl.ParseCSVCell(ctx, value, field)
+	current := cp.load()
+	if current.impl != nil {
+		return current.impl.ParseCSVCell(ctx, value, field)
 	}
-	if cp.decoder != nil {
-		return cp.decoder.DecodeText(textcoder.NewContext().WithValue("csvcoder.CellContext", ctx), value, field.Interface())
+	if current.decoder != nil {
+		return current.decoder.DecodeText(ctx.textContext(cp.textRegistry).WithValue("csvcoder.CellContext", ctx), value, field.Interface())
 	}
 	panic("internal error in csvcoder: registeredCellParser has no implementation")
 }
 
-// getOrCreateCellParserForType returns an object for parsing the contents of a
-// textual CSV cell into an object of that type.
-//
-// The argument is typically a pointer type.
-func getOrCreateCellParserForType(t reflect.Type) (cellParser, error) {
-	if t.Kind() != reflect.Ptr {
-		return nil, fmt.Errorf("internal error: must only pass pointers to getOrCreateCellParserForType, got %v", t)
-	}
-	
-	defaultRegistryMapMutex.RLock()
-	parser := defaultRegistry.cellParsers[t]
-	defaultRegistryMapMutex.RUnlock()
-	if parser == nil {
-		parser = &registeredCellParser{}
-		defaultRegistryMapMutex.Lock()
-		defaultRegistry.cellParsers[t] = parser
-		defaultRegistryMapMutex.Unlock()
-	}
-	if parser.impl != nil {
-		return parser, nil
-	}
-	if dec := textcoder.DefaultRegistry().GetDecoder(t.Elem()); dec != nil {
-		parser.decoder = dec
-		return parser, nil
-	}
-
-	return nil, fmt.Errorf("no cell parser registered for type %v", t)
-
-}
-
 type cellParser interface {
 	// ParseCSVField parses a CSV cell value. V is the reflected value of the field.
 	ParseCSVCell(ctx *CellContext, value string, field reflect.Value) error
@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// columnMatcher matches the columns of a header to the columns of a row type
+// as configured by the RegisterOptions of the type.
+type columnMatcher struct {
+	t   reflect.Type
+	cfg *rowConfig
+	// names maps the normalized names and aliases of the non-repeated columns
+	// of the row type to the column names.
+	names map[string]string
+	// repeated are the names and aliases of repeated columns.
+	repeated []repeatedName
+
+	lock    sync.Mutex
+	lastIn  *Header
+	lastOut *Header
+	lastErr error
+}
+
+// repeatedName is a name or alias of a repeated column.
+type repeatedName struct {
+	name, column string
+}
+
+// newColumnMatcher returns a matcher for the columns of row type t, or nil if
+// the options of the type do not affect how columns are matched.
+func newColumnMatcher(t reflect.Type, cfg *rowConfig, columns []*structColumn) (*columnMatcher, error) {
+	if len(cfg.normalizers) == 0 && len(cfg.aliases) == 0 && cfg.extraPolicy == IgnoreExtraColumns {
+		return nil, nil
+	}
+	m := &columnMatcher{t: t, cfg: cfg, names: make(map[string]string)}
+	known := make(map[string]bool)
+	for _, col := range columns {
+		if known[col.name] {
+			continue
+		}
+		known[col.name] = true
+		for _, name := range append([]string{col.name}, cfg.aliases[col.name]...) {
+			if col.opts.repeated {
+				m.repeated = append(m.repeated, repeatedName{name, col.name})
+				continue
+			}
+			key := cfg.normalize(name)
+			if other, ok := m.names[key]; ok && other != col.name {
+				return nil, fmt.Errorf("columns %q and %q of %v cannot be distinguished after normalization", other, col.name, t)
+			}
+			m.names[key] = col.name
+		}
+	}
+	for column := range cfg.aliases {
+		if !known[column] {
+			return nil, fmt.Errorf("aliases given for column %q, which is not a column of %v", column, t)
+		}
+	}
+	return m, nil
+}
+
+// match returns the column of the row type matched by a header column.
+func (m *columnMatcher) match(headerColumn string) (string, bool) {
+	key := m.cfg.normalize(headerColumn)
+	if column, ok := m.names[key]; ok {
+		return column, true
+	}
+	digits := len(headerColumn) - len(strings.TrimRight(headerColumn, "0123456789"))
+	if digits == 0 {
+		return "", false
+	}
+	n, err := strconv.Atoi(headerColumn[len(headerColumn)-digits:])
+	if err != nil || n < 1 {
+		return "", false
+	}
+	for _, r := range m.repeated {
+		if m.cfg.normalize(repeatedColumnName(r.name, n)) == key {
+			return repeatedColumnName(r.column, n), true
+		}
+	}
+	return "", false
+}
+
+// resolveHeader returns a header with the same columns as h in which the
+// columns of the row type may be looked up by name. It returns h if m is nil.
+func (m *columnMatcher) resolveHeader(h *Header) (*Header, error) {
+	if m == nil {
+		return h, nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h != m.lastIn {
+		m.lastOut, m.lastErr = m.resolve(h)
+		m.lastIn = h
+	}
+	return m.lastOut, m.lastErr
+}
+
+func (m *columnMatcher) resolve(h *Header) (*Header, error) {
+	fieldColumns := make(map[string]ColumnNumber)
+	var extra []string
+	for i, name := range h.ColumnNames() {
+		column, ok := m.match(name)
+		if !ok {
+			extra = append(extra, fmt.Sprintf("%q", name))
+			continue
+		}
+		if other, ok := fieldColumns[column]; ok {
+			return nil, fmt.Errorf("header columns %q and %q both match column %q", h.ColumnNames()[other], name, column)
+		}
+		fieldColumns[column] = ColumnNumber(i)
+	}
+	if m.cfg.extraPolicy == RejectExtraColumns && len(extra) != 0 {
+		sort.Strings(extra)
+		return nil, fmt.Errorf("header row has %d columns not used by %v: %s", len(extra), m.t, strings.Join(extra, ", "))
+	}
+	return &Header{h.m, h.values, fieldColumns}, nil
+}
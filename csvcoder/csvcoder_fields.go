@@ -0,0 +1,204 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// structColumn is a field of a row struct and the column it is parsed from.
+type structColumn struct {
+	name string
+	// field is the struct field. Its Index is relative to the row struct, and
+	// its Name is the dotted path of the field within the row struct.
+	field      reflect.StructField
+	opts       *fieldOptions
+	cellParser cellParser
+}
+
+// structColumns returns the columns of the fields of struct type t, which is
+// found at the given index within the row struct. Column names are prefixed
+// with prefix, and field names with fieldPrefix. If optional is true, all the
+// columns are optional.
+func (r *Registry) structColumns(t reflect.Type, index []int, prefix, fieldPrefix string, optional bool) ([]*structColumn, error) {
+	var columns []*structColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if _, ok := f.Tag.Lookup("csv-skip"); ok {
+			continue
+		}
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		f.Index = append(append([]int(nil), index...), f.Index...)
+		f.Name = fieldPrefix + f.Name
+		opts, err := parseFieldOptions(f, f.Name, f.Anonymous && r.hasCellParserForType(reflect.PtrTo(valueType(f.Type))))
+		if err != nil {
+			return nil, err
+		}
+		if opts.flatten {
+			nested, err := r.structColumns(f.Type, f.Index, prefix+opts.prefix, f.Name+".", optional || opts.optional)
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, nested...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		opts.column = prefix + opts.column
+		opts.optional = opts.optional || optional
+		cellParser, err := r.getOrCreateCellParserForType(reflect.PtrTo(opts.valueType(f.Type)))
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, &structColumn{opts.column, f, opts, cellParser})
+	}
+	return columns, nil
+}
+
+// requiredColumn returns the name of the column that must be in the header,
+// or "" if the column is optional.
+func (c *structColumn) requiredColumn() string {
+	switch {
+	case c.opts.optional:
+		return ""
+	case c.opts.repeated:
+		return repeatedColumnName(c.name, 1)
+	}
+	return c.name
+}
+
+// repeatedColumnName returns the name of the column of the nth element of a
+// repeated field. The first element is numbered 1.
+func repeatedColumnName(name string, n int) string {
+	return fmt.Sprintf("%s_%d", name, n)
+}
+
+// parse parses the field of the column from a row into the row struct that
+// dstRow points to. Errors are returned as *CellError values.
+func (c *structColumn) parse(row *Row, dstRow reflect.Value) error {
+	dst := dstRow.Elem().FieldByIndex(c.field.Index)
+	if c.opts.repeated {
+		return c.parseRepeated(row, dst)
+	}
+	idx := row.Header().ColumnIndex(c.name)
+	cellErr := &CellError{Row: row, Column: idx, ColumnName: c.name, Field: c.field.Name}
+	strValue, present := "", false
+	switch {
+	case !idx.IsValid():
+		if !c.opts.optional {
+			cellErr.Err = fmt.Errorf("csv file missing required column %q", c.name)
+			return cellErr
+		}
+	case idx.Offset() >= len(row.Strings()):
+		if !c.opts.optional {
+			cellErr.Err = fmt.Errorf("csv row does not have a value for column %q", c.name)
+			return cellErr
+		}
+	default:
+		strValue, present = row.Strings()[idx.Offset()], true
+	}
+	cellErr.Value = strValue
+	if present && strValue == "" && c.opts.nonempty {
+		cellErr.Err = fmt.Errorf("value may not be empty")
+		return cellErr
+	}
+	if strValue == "" && c.opts.hasDefault {
+		strValue = c.opts.defaultValue
+	}
+	if c.opts.split != "" {
+		if strValue == "" {
+			return nil
+		}
+		parts := strings.Split(strValue, c.opts.split)
+		elems := reflect.MakeSlice(c.field.Type, len(parts), len(parts))
+		for i, part := range parts {
+			if err := c.decode(row, part, elems.Index(i)); err != nil {
+				cellErr.Err = fmt.Errorf("element %d: %w", i, err)
+				return cellErr
+			}
+		}
+		dst.Set(elems)
+		return nil
+	}
+	if strValue == "" && (!present || c.opts.nullable) {
+		return nil
+	}
+	if c.opts.nullable {
+		dst.Set(reflect.New(c.field.Type.Elem()))
+		dst = dst.Elem()
+	}
+	if err := c.decode(row, strValue, dst); err != nil {
+		cellErr.Err = err
+		return cellErr
+	}
+	return nil
+}
+
+// parseRepeated parses the elements of a repeated field from the columns
+// name_1, name_2, and so on, up to the first column missing from the header
+// or row. Empty cells are skipped.
+func (c *structColumn) parseRepeated(row *Row, dst reflect.Value) error {
+	elems := reflect.MakeSlice(c.field.Type, 0, 0)
+	for n := 1; ; n++ {
+		name := repeatedColumnName(c.name, n)
+		idx := row.Header().ColumnIndex(name)
+		cellErr := &CellError{Row: row, Column: idx, ColumnName: name, Field: c.field.Name}
+		if !idx.IsValid() {
+			if n == 1 && !c.opts.optional {
+				cellErr.Err = fmt.Errorf("csv file missing required column %q", name)
+				return cellErr
+			}
+			break
+		}
+		if idx.Offset() >= len(row.Strings()) {
+			break
+		}
+		strValue := row.Strings()[idx.Offset()]
+		if strValue == "" {
+			continue
+		}
+		cellErr.Value = strValue
+		elem := reflect.New(c.field.Type.Elem()).Elem()
+		if err := c.decode(row, strValue, elem); err != nil {
+			cellErr.Err = err
+			return cellErr
+		}
+		elems = reflect.Append(elems, elem)
+	}
+	if elems.Len() == 0 {
+		if c.opts.nonempty {
+			return &CellError{Row: row, Column: InvalidColumn, ColumnName: repeatedColumnName(c.name, 1), Field: c.field.Name, Err: fmt.Errorf("field must have at least one value")}
+		}
+		return nil
+	}
+	dst.Set(elems)
+	return nil
+}
+
+// decode validates the text of a single value and parses it into dst.
+func (c *structColumn) decode(row *Row, text string, dst reflect.Value) error {
+	if err := c.opts.checkText(text); err != nil {
+		return err
+	}
+	if err := c.cellParser.ParseCSVCell(NewCellContext(row), text, dst.Addr()); err != nil {
+		return err
+	}
+	return c.opts.checkRange(dst)
+}
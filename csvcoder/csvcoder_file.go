@@ -15,6 +15,8 @@
 package csvcoder
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -27,12 +29,12 @@ type FileParser struct {
 	r        RowReader
 	filePath string
 	rt       *registeredType
-
-	hdrOpt headerOption
+	cfg      *fileConfig
 
 	hdr      *Header
 	rowNum   RowNumber
 	fatalErr error
+	lastRow  *Row
 }
 
 // RowReader is an interface of a reader that reads raw records from the data
@@ -47,18 +49,26 @@ type RowReader interface {
 // for error reporting purposes.
 //
 // The type of the recordPrototype should have been registered with a call to
-// RegisterRowStruct.
-func NewFileParser(r RowReader, path string, recordPrototype interface{}) (*FileParser, error) {
-	rt, err := getOrRegisterType(reflect.ValueOf(recordPrototype).Type())
+// RegisterRowStruct, or is registered if not. Types are registered in the
+// default Registry unless the WithRegistry option is given.
+//
+// The header row is read immediately. By default, the first row of the file is
+// the header; the NoHeader and ReplaceHeader options supply the column names
+// instead. NewFileParser returns an error if the header is missing a required
+// column of the row type or a column given with ExpectedColumns.
+func NewFileParser(r RowReader, path string, recordPrototype interface{}, opts ...FileParserOption) (*FileParser, error) {
+	cfg := newFileConfig(opts, nil)
+	rt, err := cfg.registry.getOrRegisterType(reflect.ValueOf(recordPrototype).Type())
 	if err != nil {
 		return nil, fmt.Errorf("could not find or infer coder for type %v: %w", reflect.ValueOf(recordPrototype).Type(), err)
 	}
 	fp := &FileParser{
 		r, path, rt,
-		headerOption{expectedColumns: rt.requiredColumnNames},
+		cfg,
 		nil,
 		0,
 		nil,
+		nil,
 	}
 
 	if err := fp.parseHeader(); err != nil {
@@ -69,21 +79,33 @@ func NewFileParser(r RowReader, path string, recordPrototype interface{}) (*File
 }
 
 func (fp *FileParser) parseHeader() error {
-	if fp.hdrOpt.noHeader {
-		fp.hdr = fp.hdrOpt.predeterminedHeader
+	headerValues := fp.cfg.header
+	if !fp.cfg.noHeader {
+		gotHeaderValues, err := fp.r.Read()
+		if err != nil {
+			return fmt.Errorf("error reading header row: %w", err)
+		}
+		fp.rowNum = 1
+		if headerValues == nil {
+			headerValues = gotHeaderValues
+		}
 	}
-	gotHeaderValues, err := fp.r.Read()
+	hdr, err := fp.rt.matcher.resolveHeader(NewHeader(headerValues))
 	if err != nil {
-		return fmt.Errorf("error reading header row: %w", err)
+		return err
 	}
-	fp.rowNum = 1
-	fp.hdr = NewHeader(gotHeaderValues)
+	fp.hdr = hdr
 	missing := []string{}
-	for wantCol := range fp.hdrOpt.expectedColumns {
+	for wantCol := range fp.rt.requiredColumnNames {
 		if !fp.hdr.ColumnIndex(wantCol).IsValid() {
 			missing = append(missing, fmt.Sprintf("%q", wantCol))
 		}
 	}
+	for _, wantCol := range fp.cfg.expectedColumns {
+		if _, ok := fp.rt.requiredColumnNames[wantCol]; !ok && !fp.hdr.ColumnIndex(wantCol).IsValid() {
+			missing = append(missing, fmt.Sprintf("%q", wantCol))
+		}
+	}
 	if len(missing) != 0 {
 		sort.Strings(missing)
 		return fmt.Errorf("header row is missing %d columns: %s", len(missing), strings.Join(missing, ", "))
@@ -100,19 +122,35 @@ func (fp *FileParser) Read() (interface{}, error) {
 		}
 	}
 
+	fp.lastRow = nil
 	rowVals, err := fp.r.Read()
 	if err == io.EOF {
 		return nil, err
 	}
 	row := NewRow(rowVals, fp.hdr, fp.rowNum, fp.filePath)
+	row.textContext = fp.cfg.textContext
 	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// The reader skips the malformed row, so it still counts as a row.
+			fp.lastRow = row
+			fp.rowNum++
+		}
 		return nil, row.errorf("csv.Reader error: %w", err)
 	}
+	fp.lastRow = row
 	fp.rowNum++
 
 	return fp.rt.parseRow(row)
 }
 
+// LastRow returns the row most recently read by Read, even if it failed to
+// parse. It returns nil if Read has not been called, or if the last call did
+// not read a row, for example because of io.EOF or an I/O error.
+func (fp *FileParser) LastRow() *Row {
+	return fp.lastRow
+}
+
 // ReadAll calls Read() until the end of the file and calls cb for each value.
 func (fp *FileParser) ReadAll(callback func(interface{}) error) error {
 	for {
@@ -128,30 +166,3 @@ func (fp *FileParser) ReadAll(callback func(interface{}) error) error {
 		}
 	}
 }
-
-// headerOption is an argument passed to ParseRecords
-//
-// TODO(reddaly): Export this?
-type headerOption struct {
-	expectedColumns     map[string]struct{}
-	noHeader            bool
-	predeterminedHeader *Header
-}
-
-// expectedHeaderUnordered returns a HeaderOption to use that requires the
-// provided set of column names in any order.
-func expectedHeaderUnordered(columns []string) headerOption {
-	m := make(map[string]struct{})
-	for _, c := range columns {
-		m[c] = struct{}{}
-	}
-	return headerOption{expectedColumns: m}
-}
-
-// noHeader returns a HeaderOption that asserts that no header
-func noHeader(predeterminedHeader *Header) headerOption {
-	if predeterminedHeader == nil {
-		panic("nil predeterminedHeader")
-	}
-	return headerOption{noHeader: true, predeterminedHeader: predeterminedHeader}
-}
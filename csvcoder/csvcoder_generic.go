@@ -0,0 +1,152 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package csvcoder
+
+import (
+	"io"
+	"iter"
+	"reflect"
+)
+
+// TypedFileParser parses the records of a CSV file into values of type *T,
+// where T is a struct type. It is a type-safe wrapper around FileParser.
+type TypedFileParser[T any] struct {
+	fp *FileParser
+}
+
+// NewTypedFileParser returns an object for parsing records of type *T from a
+// file. The type is registered with RegisterRowStruct if needed.
+//
+// The input RowReader will be used to read all rows. The path argument is only
+// for error reporting purposes. The options are those of NewFileParser.
+func NewTypedFileParser[T any](r RowReader, path string, opts ...FileParserOption) (*TypedFileParser[T], error) {
+	fp, err := NewFileParser(r, path, new(T), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedFileParser[T]{fp}, nil
+}
+
+// Read parses the next record in the CSV. At the end of the file, Read returns
+// io.EOF.
+func (p *TypedFileParser[T]) Read() (*T, error) {
+	v, err := p.fp.Read()
+	if err != nil {
+		return nil, err
+	}
+	return v.(*T), nil
+}
+
+// LastRow returns the row most recently read by Read; see FileParser.LastRow.
+func (p *TypedFileParser[T]) LastRow() *Row {
+	return p.fp.LastRow()
+}
+
+// All returns an iterator over the remaining records of the file.
+//
+// A row that fails to parse yields a nil record and the error, and iteration
+// continues with the next row. An error that does not belong to a row, such as
+// an I/O error, is yielded once and ends the iteration.
+func (p *TypedFileParser[T]) All() iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		for {
+			v, err := p.fp.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if !yield(nil, err) || p.fp.LastRow() == nil {
+					return
+				}
+				continue
+			}
+			if !yield(v.(*T), nil) {
+				return
+			}
+		}
+	}
+}
+
+// ParseTypedRow parses a row into a new value of type *T. It is the generic
+// form of ParseRow.
+func ParseTypedRow[T any](row *Row) (*T, error) {
+	v := new(T)
+	if err := ParseRow(row, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// RegisterRow registers the struct type T so that values of type *T can be
+// parsed from CSV rows. It is the generic form of RegisterRowStruct and
+// panics for the same reasons.
+func RegisterRow[T any](opt ...RegisterOption) {
+	RegisterRowIn[T](defaultRegistry, opt...)
+}
+
+// RegisterRowIn is like RegisterRow, but registers T in r.
+func RegisterRowIn[T any](r *Registry, opt ...RegisterOption) {
+	r.RegisterRowStruct(reflect.TypeOf((*T)(nil)), opt...)
+}
+
+// RegisterCellDecoder registers a function that parses the text of a cell
+// into a value of type V. Row struct fields of type V are parsed with the
+// function instead of with the textcoder decoder for V, if any. Unlike
+// textcoder.Register, the signature of the function is checked at compile
+// time.
+//
+// The decoder replaces any decoder previously registered for V, including in
+// row types that were registered before it.
+func RegisterCellDecoder[V any](decode func(ctx *CellContext, value string, dst *V) error) {
+	RegisterCellDecoderIn(defaultRegistry, decode)
+}
+
+// RegisterCellDecoderIn is like RegisterCellDecoder, but registers the decoder
+// in r.
+func RegisterCellDecoderIn[V any](r *Registry, decode func(ctx *CellContext, value string, dst *V) error) {
+	r.setCellParser(reflect.TypeOf((*V)(nil)), simpleCellParser(func(ctx *CellContext, value string, field reflect.Value) error {
+		return decode(ctx, value, field.Interface().(*V))
+	}))
+}
+
+// TypedFileWriter writes values of type *T, where T is a struct type, as the
+// rows of a CSV file. It is a type-safe wrapper around FileWriter.
+type TypedFileWriter[T any] struct {
+	fw *FileWriter
+}
+
+// NewTypedFileWriter returns an object for writing records of type *T to a
+// file, and writes the header row; see NewFileWriter. The record prototype is
+// a zero T, so the number of columns of each repeated field must be given with
+// a RepeatCount option.
+func NewTypedFileWriter[T any](w RowWriter, path string, opts ...FileWriterOption) (*TypedFileWriter[T], error) {
+	fw, err := NewFileWriter(w, path, new(T), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedFileWriter[T]{fw}, nil
+}
+
+// Header returns the header row written by the writer.
+func (w *TypedFileWriter[T]) Header() *Header {
+	return w.fw.Header()
+}
+
+// Write encodes a record and writes it as the next row.
+func (w *TypedFileWriter[T]) Write(record *T) error {
+	return w.fw.Write(record)
+}
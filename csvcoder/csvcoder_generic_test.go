@@ -0,0 +1,261 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package csvcoder
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/xtoproto/textcoder"
+)
+
+type temperature struct {
+	kelvin float64
+}
+
+type reading struct {
+	Station string      `csv:"station"`
+	Temp    temperature `csv:"temp"`
+}
+
+func init() {
+	RegisterCellDecoder(func(ctx *CellContext, value string, dst *temperature) error {
+		var c float64
+		if _, err := fmt.Sscanf(value, "%gC", &c); err != nil {
+			return fmt.Errorf("bad temperature %q: %w", value, err)
+		}
+		dst.kelvin = c + 273.15
+		return nil
+	})
+	RegisterRow[reading]()
+}
+
+func TestTypedFileParser_All(t *testing.T) {
+	cr := csv.NewReader(strings.NewReader(joinWithNewlines(`station,temp`, `a,20C`, `b,hot`, `c,-5C`)))
+	fp, err := NewTypedFileParser[reading](cr, "test.csv")
+	if err != nil {
+		t.Fatalf("NewTypedFileParser() failed: %v", err)
+	}
+	var got []string
+	for r, err := range fp.All() {
+		if err != nil {
+			var cellErr *CellError
+			if !errors.As(err, &cellErr) {
+				t.Fatalf("All() yielded error %v, want a *CellError", err)
+			}
+			got = append(got, "error at "+cellErr.PositionString())
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s %.2fK", r.Station, r.Temp.kelvin))
+	}
+	want := []string{"a 293.15K", "error at test.csv:3:2", "c 268.15K"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTypedFileParser_All_stopsAtReadError(t *testing.T) {
+	fp, err := NewTypedFileParser[abee](&failingReader{[][]string{{"A", "Bee"}, {"x", "1"}}}, "test.csv")
+	if err != nil {
+		t.Fatalf("NewTypedFileParser() failed: %v", err)
+	}
+	var got []string
+	for r, err := range fp.All() {
+		if err != nil {
+			got = append(got, "error")
+			continue
+		}
+		got = append(got, r.A)
+	}
+	if diff := cmp.Diff([]string{"x", "error"}, got); diff != "" {
+		t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+	}
+}
+
+// failingReader returns its rows and then an I/O error.
+type failingReader struct {
+	rows [][]string
+}
+
+func (r *failingReader) Read() ([]string, error) {
+	if len(r.rows) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func TestParseTypedRow(t *testing.T) {
+	row := NewRow([]string{"7", "x"}, NewHeader([]string{"Bee", "A"}), 1, "")
+	got, err := ParseTypedRow[abee](row)
+	if err != nil {
+		t.Fatalf("ParseTypedRow() failed: %v", err)
+	}
+	if diff := cmp.Diff(&abee{A: "x", B: 7}, got); diff != "" {
+		t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRegisterCellDecoderIn(t *testing.T) {
+	r := NewRegistry(textcoder.DefaultRegistry())
+	RegisterCellDecoderIn(r, func(ctx *CellContext, value string, dst *temperature) error {
+		var f float64
+		if _, err := fmt.Sscanf(value, "%gF", &f); err != nil {
+			return fmt.Errorf("bad temperature %q: %w", value, err)
+		}
+		dst.kelvin = (f-32)*5/9 + 273.15
+		return nil
+	})
+	RegisterRowIn[reading](r)
+
+	p, err := NewTypedFileParser[reading](csv.NewReader(strings.NewReader("station,temp\nx,212F\n")), "readings.csv", WithRegistry(r))
+	if err != nil {
+		t.Fatalf("NewTypedFileParser() failed: %v", err)
+	}
+	got, err := p.Read()
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if diff := cmp.Diff(&reading{"x", temperature{373.15}}, got, cmp.AllowUnexported(temperature{})); diff != "" {
+		t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+	}
+
+	// The default registry still uses the decoder registered in init.
+	if _, err := ParseTypedRow[reading](NewRow([]string{"x", "212F"}, NewHeader([]string{"station", "temp"}), 1, "")); err == nil {
+		t.Errorf("ParseTypedRow() got nil error for a temperature only the other registry can parse")
+	}
+}
+
+// TestRegisterCellDecoderIn_concurrent checks that decoders may be registered
+// while rows are parsed; it is most useful with the race detector.
+func TestRegisterCellDecoderIn_concurrent(t *testing.T) {
+	decode := func(ctx *CellContext, value string, dst *temperature) error {
+		var f float64
+		if _, err := fmt.Sscanf(value, "%gF", &f); err != nil {
+			return err
+		}
+		dst.kelvin = (f-32)*5/9 + 273.15
+		return nil
+	}
+	r := NewRegistry(textcoder.DefaultRegistry())
+	RegisterCellDecoderIn(r, decode)
+	RegisterRowIn[reading](r)
+	row := NewRow([]string{"x", "212F"}, NewHeader([]string{"station", "temp"}), 1, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := r.ParseRow(row, &reading{}); err != nil {
+					t.Errorf("ParseRow() failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		RegisterCellDecoderIn(r, decode)
+	}
+	wg.Wait()
+}
+
+func TestTypedFileWriter(t *testing.T) {
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	w, err := NewTypedFileWriter[abee](cw, "test.csv")
+	if err != nil {
+		t.Fatalf("NewTypedFileWriter() failed: %v", err)
+	}
+	want := []*abee{{A: "x", B: 1}, {A: "y, z", B: -2}}
+	for _, r := range want {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	cw.Flush()
+
+	fp, err := NewTypedFileParser[abee](csv.NewReader(strings.NewReader(out.String())), "test.csv")
+	if err != nil {
+		t.Fatalf("NewTypedFileParser() failed: %v", err)
+	}
+	var got []*abee
+	for r, err := range fp.All() {
+		if err != nil {
+			t.Fatalf("All() yielded error: %v", err)
+		}
+		got = append(got, r)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTypedFileWriter_repeatedField(t *testing.T) {
+	want := []*shipment{
+		{
+			audit:  audit{CreatedBy: "ann"},
+			ID:     "s1",
+			From:   address{"1 Main St", "Springfield"},
+			Scores: []int{3, 7, 1},
+		},
+		{
+			audit:  audit{CreatedBy: "bo"},
+			ID:     "s2",
+			From:   address{Street: "2 Elm St"},
+			Scores: []int{5},
+		},
+	}
+	if _, err := NewTypedFileWriter[shipment](csv.NewWriter(&strings.Builder{}), "test.csv"); err == nil {
+		t.Errorf("NewTypedFileWriter() got nil error for a repeated field without a RepeatCount option")
+	}
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	w, err := NewTypedFileWriter[shipment](cw, "test.csv", RepeatCount("score", 3))
+	if err != nil {
+		t.Fatalf("NewTypedFileWriter() failed: %v", err)
+	}
+	for _, r := range want {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	cw.Flush()
+
+	fp, err := NewTypedFileParser[shipment](csv.NewReader(strings.NewReader(out.String())), "test.csv")
+	if err != nil {
+		t.Fatalf("NewTypedFileParser() failed: %v", err)
+	}
+	var got []*shipment
+	for r, err := range fp.All() {
+		if err != nil {
+			t.Fatalf("All() yielded error: %v", err)
+		}
+		got = append(got, r)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(shipment{})); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+}
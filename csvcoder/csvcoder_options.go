@@ -0,0 +1,244 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/google/xtoproto/textcoder"
+)
+
+// RegisterOption objects may be passed to RegisterRowStruct to configure how a
+// type should be parsed as a CSV row. The zero value has no effect.
+//
+// The options only take effect when the type is first registered, including
+// when it is registered implicitly by NewFileParser or NewFileWriter.
+type RegisterOption struct {
+	apply func(cfg *rowConfig)
+}
+
+// rowConfig is the configuration of a row type built from its RegisterOptions.
+type rowConfig struct {
+	normalizers []ColumnNormalizer
+	extraPolicy ExtraColumnPolicy
+	// aliases maps column names of the row type to their aliases.
+	aliases map[string][]string
+}
+
+func newRowConfig(opts []RegisterOption) *rowConfig {
+	cfg := &rowConfig{aliases: make(map[string][]string)}
+	for _, opt := range opts {
+		if opt.apply != nil {
+			opt.apply(cfg)
+		}
+	}
+	return cfg
+}
+
+// normalize applies the normalizers to a column name.
+func (cfg *rowConfig) normalize(name string) string {
+	for _, n := range cfg.normalizers {
+		name = n(name)
+	}
+	return name
+}
+
+// ColumnNormalizer returns a normalized form of a column name. Header columns
+// match the columns of a row type if their normalized names are equal.
+type ColumnNormalizer func(name string) string
+
+// FoldCase is a ColumnNormalizer that makes column names case insensitive.
+func FoldCase(name string) string {
+	return strings.ToLower(name)
+}
+
+// TrimSpace is a ColumnNormalizer that ignores leading and trailing space.
+func TrimSpace(name string) string {
+	return strings.TrimSpace(name)
+}
+
+// IgnoreWordSeparators is a ColumnNormalizer that ignores case, spaces,
+// underscores, hyphens and periods, so that "first_name", "FirstName",
+// "first-name" and "First Name" all match.
+func IgnoreWordSeparators(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r), r == '_', r == '-', r == '.':
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// NormalizeColumnNames returns an option that matches header columns to the
+// columns of the row type after applying the normalizers in order. Columns
+// whose names are equal match even if they are different after
+// normalization.
+func NormalizeColumnNames(normalizers ...ColumnNormalizer) RegisterOption {
+	return RegisterOption{func(cfg *rowConfig) {
+		cfg.normalizers = append(cfg.normalizers, normalizers...)
+	}}
+}
+
+// ColumnAliases returns an option that allows the column of a row type to
+// appear in the header under any of the given aliases. For a repeated field,
+// the aliases are alternate names of the column prefix.
+func ColumnAliases(column string, aliases ...string) RegisterOption {
+	return RegisterOption{func(cfg *rowConfig) {
+		cfg.aliases[column] = append(cfg.aliases[column], aliases...)
+	}}
+}
+
+// ExtraColumnPolicy determines how header columns that do not match a column
+// of the row type are treated.
+type ExtraColumnPolicy int
+
+const (
+	// IgnoreExtraColumns ignores columns that do not match. This is the
+	// default.
+	IgnoreExtraColumns ExtraColumnPolicy = iota
+	// RejectExtraColumns makes it an error for a header to have columns that
+	// do not match.
+	RejectExtraColumns
+)
+
+// WithExtraColumnPolicy returns an option that sets the ExtraColumnPolicy of
+// the row type.
+func WithExtraColumnPolicy(policy ExtraColumnPolicy) RegisterOption {
+	return RegisterOption{func(cfg *rowConfig) {
+		cfg.extraPolicy = policy
+	}}
+}
+
+// FileParserOption is an option that may be passed to NewFileParser.
+type FileParserOption interface {
+	applyToFileParser(cfg *fileConfig)
+}
+
+// FileWriterOption is an option that may be passed to NewFileWriter.
+type FileWriterOption interface {
+	applyToFileWriter(cfg *fileConfig)
+}
+
+// FileOption is an option that may be passed to both NewFileParser and
+// NewFileWriter.
+type FileOption interface {
+	FileParserOption
+	FileWriterOption
+}
+
+// fileConfig is the configuration of a FileParser or FileWriter built from
+// its options.
+type fileConfig struct {
+	registry *Registry
+
+	// header, if not nil, is used in place of the header row of the file.
+	header []string
+	// noHeader is true if the file has no header row.
+	noHeader bool
+	// expectedColumns are required in addition to those of the row type.
+	expectedColumns []string
+	// textContext, if not nil, is the base context of every cell.
+	textContext *textcoder.Context
+	// repeatCounts maps the column names of repeated fields to the number of
+	// columns written for them.
+	repeatCounts map[string]int
+}
+
+func newFileConfig(parserOpts []FileParserOption, writerOpts []FileWriterOption) *fileConfig {
+	cfg := &fileConfig{registry: defaultRegistry}
+	for _, opt := range parserOpts {
+		opt.applyToFileParser(cfg)
+	}
+	for _, opt := range writerOpts {
+		opt.applyToFileWriter(cfg)
+	}
+	return cfg
+}
+
+type fileOption func(cfg *fileConfig)
+
+func (o fileOption) applyToFileParser(cfg *fileConfig) { o(cfg) }
+func (o fileOption) applyToFileWriter(cfg *fileConfig) { o(cfg) }
+
+// WithRegistry returns an option that makes a FileParser or FileWriter use the
+// row types and coders of r instead of those of the default registry.
+func WithRegistry(r *Registry) FileOption {
+	return fileOption(func(cfg *fileConfig) {
+		cfg.registry = r
+	})
+}
+
+// WithTextContext returns an option that makes a FileParser or FileWriter
+// decode and encode every cell with a context derived from ctx, so that its
+// options, such as the time layout or locale, apply to all the cells of the
+// file. The coders are still those of the registry's textcoder registry, from
+// which ctx should normally be created.
+//
+// A FileWriter formats floating point values with the 'g' verb and a precision
+// of -1 unless ctx sets a float format.
+func WithTextContext(ctx *textcoder.Context) FileOption {
+	return fileOption(func(cfg *fileConfig) {
+		cfg.textContext = ctx
+	})
+}
+
+type fileParserOption func(cfg *fileConfig)
+
+func (o fileParserOption) applyToFileParser(cfg *fileConfig) { o(cfg) }
+
+// NoHeader returns an option for parsing a file without a header row. The
+// columns of the file are named by the arguments.
+func NoHeader(columns ...string) FileParserOption {
+	return fileParserOption(func(cfg *fileConfig) {
+		cfg.header = columns
+		cfg.noHeader = true
+	})
+}
+
+// ReplaceHeader returns an option that reads the header row of a file but uses
+// the given column names in its place.
+func ReplaceHeader(columns ...string) FileParserOption {
+	return fileParserOption(func(cfg *fileConfig) {
+		cfg.header = columns
+		cfg.noHeader = false
+	})
+}
+
+// ExpectedColumns returns an option that requires the header to contain the
+// given columns, in any order, in addition to the columns required by the row
+// type.
+func ExpectedColumns(columns ...string) FileParserOption {
+	return fileParserOption(func(cfg *fileConfig) {
+		cfg.expectedColumns = append(cfg.expectedColumns, columns...)
+	})
+}
+
+type fileWriterOption func(cfg *fileConfig)
+
+func (o fileWriterOption) applyToFileWriter(cfg *fileConfig) { o(cfg) }
+
+// RepeatCount returns an option that makes a FileWriter write n columns,
+// column_1 through column_n, for the repeated field with the given column
+// name, instead of taking the number of columns from the record prototype.
+func RepeatCount(column string, n int) FileWriterOption {
+	return fileWriterOption(func(cfg *fileConfig) {
+		if cfg.repeatCounts == nil {
+			cfg.repeatCounts = make(map[string]int)
+		}
+		cfg.repeatCounts[column] = n
+	})
+}
@@ -32,6 +32,12 @@ func (n ColumnNumber) Offset() int {
 	return int(n)
 }
 
+// Ordinal returns the 1-based offset of the column. The first column has
+// ordinal value 1.
+func (n ColumnNumber) Ordinal() int {
+	return n.Offset() + 1
+}
+
 // RowNumber is used instead of an int for representing the position of a row in a CSV file.
 type RowNumber int
 
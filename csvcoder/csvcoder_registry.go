@@ -0,0 +1,115 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/xtoproto/textcoder"
+)
+
+var defaultRegistry = NewRegistry(textcoder.DefaultRegistry())
+
+// Registry is a set of registered row types and cell parsers.
+//
+// The package-level functions such as RegisterRowStruct, ParseRow and
+// NewFileParser use the default registry. Creating a separate Registry allows
+// a library to register cell parsers and row types without affecting other
+// users of csvcoder.
+type Registry struct {
+	textRegistry *textcoder.Registry
+
+	cellParsers     map[reflect.Type]*registeredCellParser
+	cellParsersLock sync.RWMutex
+
+	registeredRowTypes     map[reflect.Type]*registeredType
+	registeredRowTypesLock sync.RWMutex
+}
+
+// NewRegistry returns a new Registry with no registered row types or cell
+// parsers. Cells without a registered cell parser are decoded with the coders
+// of textRegistry, and FileWriter encodes cells with them.
+func NewRegistry(textRegistry *textcoder.Registry) *Registry {
+	return &Registry{
+		textRegistry:       textRegistry,
+		cellParsers:        make(map[reflect.Type]*registeredCellParser),
+		registeredRowTypes: make(map[reflect.Type]*registeredType),
+	}
+}
+
+// DefaultRegistry returns the registry used by the package-level functions. It
+// is backed by textcoder.DefaultRegistry().
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// TextRegistry returns the textcoder registry backing r.
+func (r *Registry) TextRegistry() *textcoder.Registry {
+	return r.textRegistry
+}
+
+// getOrCreateCellParserForType returns an object for parsing the contents of a
+// textual CSV cell into an object of that type.
+//
+// The argument is typically a pointer type.
+func (r *Registry) getOrCreateCellParserForType(t reflect.Type) (cellParser, error) {
+	if t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("internal error: must only pass pointers to getOrCreateCellParserForType, got %v", t)
+	}
+
+	r.cellParsersLock.Lock()
+	defer r.cellParsersLock.Unlock()
+	parser := r.cellParsers[t]
+	if parser == nil {
+		parser = &registeredCellParser{textRegistry: r.textRegistry}
+		r.cellParsers[t] = parser
+	}
+	if parser.load().impl != nil {
+		return parser, nil
+	}
+	if dec := r.textRegistry.GetDecoder(t.Elem()); dec != nil {
+		parser.current.Store(cellParserImpl{decoder: dec})
+		return parser, nil
+	}
+
+	return nil, fmt.Errorf("no cell parser registered for type %v", t)
+}
+
+// hasCellParserForType reports whether values of type t, which is a pointer
+// type, can be parsed from a cell, without creating a cell parser for it.
+func (r *Registry) hasCellParserForType(t reflect.Type) bool {
+	r.cellParsersLock.RLock()
+	parser := r.cellParsers[t]
+	r.cellParsersLock.RUnlock()
+	if parser != nil && parser.load().impl != nil {
+		return true
+	}
+	return r.textRegistry.GetDecoder(t.Elem()) != nil
+}
+
+// setCellParser registers a cell parser for values of type t, which is a
+// pointer type, replacing any previously registered parser.
+func (r *Registry) setCellParser(t reflect.Type, impl cellParser) {
+	r.cellParsersLock.Lock()
+	defer r.cellParsersLock.Unlock()
+	parser := r.cellParsers[t]
+	if parser == nil {
+		parser = &registeredCellParser{textRegistry: r.textRegistry}
+		r.cellParsers[t] = parser
+	}
+	parser.current.Store(cellParserImpl{impl: impl})
+}
@@ -31,19 +31,32 @@
 //
 // Unlike the standard library packages, this package uses textcoder for
 // decoding textual values, allowing any package to provide a decoder for a
-// given type rather than using methods of the type.
+// given type rather than using methods of the type. Records are written with
+// FileWriter, which uses the textcoder encoders of the field types.
+//
+// Row types and cell parsers are registered in a Registry. The package-level
+// functions use a default Registry backed by textcoder.DefaultRegistry(); a
+// library that needs its own parsers can create a Registry with NewRegistry and
+// pass it to NewFileParser and NewFileWriter with the WithRegistry option.
+//
+// With Go 1.23 or later, NewTypedFileParser, ParseTypedRow, RegisterRow and
+// RegisterCellDecoder provide a type-safe form of the API:
+//
+//	csvcoder.RegisterRow[species]()
+//	p, err := csvcoder.NewTypedFileParser[species](csv.NewReader(r), "species.csv")
+//	...
+//	for s, err := range p.All() {
+//		...
+//	}
 package csvcoder
 
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
-	"sync"
-)
 
-// Registered row types.
-var (
-	defaultRegistry = newRegistry()
+	"github.com/google/xtoproto/textcoder"
 )
 
 // ParseRow returns an error if the row fails to parse.
@@ -53,6 +66,12 @@ var (
 //
 //
 func ParseRow(row *Row, destination interface{}) error {
+	return defaultRegistry.ParseRow(row, destination)
+}
+
+// ParseRow is like the ParseRow function, but parses row types registered in
+// r.
+func (r *Registry) ParseRow(row *Row, destination interface{}) error {
 	type parsable interface {
 		ParseCSVRow(row *Row) error
 	}
@@ -65,10 +84,19 @@ func ParseRow(row *Row, destination interface{}) error {
 		return nil
 	}
 	t := reflect.ValueOf(destination).Type()
-	p, err := getRegisteredTypeOrErr(t)
+	p, err := r.getRegisteredTypeOrErr(t)
 	if err != nil {
 		return row.errorf("failed to parse CSV row into destination %v: %w", destination, err)
 	}
+	hdr, err := p.matcher.resolveHeader(row.Header())
+	if err != nil {
+		return row.errorf("%w", err)
+	}
+	if hdr != row.Header() {
+		resolved := NewRow(row.Strings(), hdr, row.Number(), row.Path())
+		resolved.textContext = row.textContext
+		row = resolved
+	}
 	if err := p.parser.ParseCSVRow(row, destination); err != nil {
 		return row.errorf("%w", err)
 	}
@@ -81,11 +109,14 @@ type Row struct {
 	h        *Header
 	num      RowNumber
 	fileName string
+	// textContext, if not nil, is the base context for decoding the cells of
+	// the row.
+	textContext *textcoder.Context
 }
 
 // NewRow returns a new parsing context.
 func NewRow(values []string, h *Header, num RowNumber, fileName string) *Row {
-	return &Row{values, h, num, fileName}
+	return &Row{values, h, num, fileName, nil}
 }
 
 // Strings returns the string values of the row.
@@ -141,15 +172,55 @@ func (r *Row) errorf(format string, a ...interface{}) error {
 	return fmt.Errorf("%s: %w", r.PositionString(), fmt.Errorf(format, a...))
 }
 
+// CellError is returned, usually wrapped in other errors, when a field of a
+// row struct cannot be parsed from its cell. Use errors.As to retrieve it.
+type CellError struct {
+	// Row is the row that contains the cell.
+	Row *Row
+	// Column is the index of the cell's column, or InvalidColumn if the header
+	// has no such column.
+	Column ColumnNumber
+	// ColumnName is the name of the cell's column.
+	ColumnName string
+	// Value is the text of the cell. It is empty if the row has no value for
+	// the column.
+	Value string
+	// Field is the name of the struct field the cell is parsed into.
+	Field string
+	// Err is the reason the cell could not be parsed.
+	Err error
+}
+
+func (e *CellError) Error() string {
+	return fmt.Sprintf("column %q: %v", e.ColumnName, e.Err)
+}
+
+// Unwrap returns Err.
+func (e *CellError) Unwrap() error {
+	return e.Err
+}
+
+// PositionString returns a human readable representation of the cell position,
+// such as "input.csv:3:2" for the second column of the third row.
+func (e *CellError) PositionString() string {
+	if !e.Column.IsValid() {
+		return e.Row.PositionString()
+	}
+	return fmt.Sprintf("%s:%d", e.Row.PositionString(), e.Column.Ordinal())
+}
+
 // Header contains the values of the first row of the CSV file.
 type Header struct {
 	m      map[string]ColumnNumber
 	values []string
+	// fieldColumns, if not nil, maps the column names of a row type to the
+	// columns that match them; see resolveHeader.
+	fieldColumns map[string]ColumnNumber
 }
 
 // NewHeader returns a Header based on the given values.
 func NewHeader(values []string) *Header {
-	h := &Header{make(map[string]ColumnNumber), values}
+	h := &Header{make(map[string]ColumnNumber), values, nil}
 	for i, s := range values {
 		h.m[s] = ColumnNumber(i)
 	}
@@ -157,7 +228,14 @@ func NewHeader(values []string) *Header {
 }
 
 // ColumnIndex returns the index of the column with the given name.
+//
+// For the header of a file parsed by a FileParser, the name may also be the
+// column name of a field of the row type that matches a differently named
+// column because of the options the type was registered with.
 func (h *Header) ColumnIndex(col string) ColumnNumber {
+	if cn, ok := h.fieldColumns[col]; ok {
+		return cn
+	}
 	cn, ok := h.m[col]
 	if !ok {
 		return InvalidColumn
@@ -170,11 +248,6 @@ func (h *Header) ColumnNames() []string {
 	return h.values
 }
 
-// RegisterOption objects may be passed to RegisterRowStruct to configure how a
-// type should be parsed as a CSV row.
-type RegisterOption struct {
-}
-
 // RegisterRowStruct registers a struct type T that can be encoded as a CSV row.
 //
 // Each public field of the struct definition will be examined and treated as
@@ -182,25 +255,95 @@ type RegisterOption struct {
 //
 // 1. If the field has a `csv-skip` tag, it will not be parsed.
 //
-// 2. If the field has a `csv` tag, that tag will be treated as the name of the
-// CSV column for the field. If the tag is absent, the column name used will be
-// the name of the field.
+// 2. If the field is an embedded struct without a column name in its `csv`
+// tag and there is no registered decoder for a pointer to it, or its `csv` tag
+// has a prefix=PREFIX option, the fields of the struct are examined in the
+// same way, and the names of their columns are prefixed with PREFIX. Only the
+// optional option may be combined with prefix, and it makes all of the nested
+// columns optional.
+//
+// 3. If the field has a `csv` tag, the part of the tag before the first comma
+// will be treated as the name of the CSV column for the field. If the tag or
+// name is absent, the column name used will be the name of the field.
 //
-// 3. Let FT be the Go type of the field. If there is a registered decoder for
+// 4. Let FT be the Go type of the field. If there is a registered decoder for
 // *FT, that decoder will be used to decode the string value of the field
-// with the name from step 2 into the field of a row being parsed. If there is
+// with the name from step 3 into the field of a row being parsed. If there is
 // no registered decoder for *FT, RegisterRowStruct will panic and
 // SafeRegisterRowStruct returns an error.
+//
+// 5. If FT is a pointer type *V, the field is nullable: it is left nil for an
+// empty cell and otherwise set to a new V decoded with the decoder for *V.
+//
+// 6. If FT is a slice type []E with the split or repeated option, each element
+// is decoded with the decoder for *E. With split=SEP, the elements are
+// separated by SEP within a single cell. With repeated, the elements are in
+// the columns NAME_1, NAME_2, and so on up to the last consecutively numbered
+// column, and empty cells are skipped. Only NAME_1 is required in the header.
+//
+// The comma-separated options following the column name in the `csv` tag are:
+//
+//	optional       The column may be missing from the header, and rows may be
+//	               too short to contain it. The field is left unset if so.
+//	default=VALUE  VALUE is decoded in place of a missing or empty cell.
+//	               Implies optional.
+//	required       The column must be present. This is the default, and may
+//	               not be combined with optional or default.
+//	nonempty       The cell may not be empty. A repeated field must have at
+//	               least one value.
+//	prefix=PREFIX  See step 2.
+//	split=SEP      See step 6. SEP may not contain a comma.
+//	repeated       See step 6.
+//
+// Cells may also be validated with the following tags:
+//
+//	csv-pattern:"REGEXP"  Non-empty cells must match the regular expression.
+//	csv-min:"NUMBER"      The decoded value of a numeric field must be at
+//	csv-max:"NUMBER"      least csv-min and at most csv-max.
+//
+// For slice fields, the tags apply to each element.
+//
+// For example:
+//
+//	type order struct {
+//		Email    string   `csv:"email,required,nonempty" csv-pattern:"@"`
+//		Quantity int      `csv:"qty,default=1" csv-min:"1" csv-max:"100"`
+//		Note     *string  `csv:"note,optional"`
+//		Tags     []string `csv:"tags,optional,split=;"`
+//		Scores   []int    `csv:"score,repeated"`
+//		Shipping address  `csv:",prefix=ship_"`
+//	}
+//
+// Malformed tags cause registration to fail. Validation failures are reported
+// as *CellError values wrapped in the row parsing error.
+//
+// By default, header columns match the columns of the type only if their names
+// are equal, and other header columns are ignored. The options
+// NormalizeColumnNames, ColumnAliases and WithExtraColumnPolicy change this.
+//
+// The type is registered in the default Registry.
 func RegisterRowStruct(t reflect.Type, opt ...RegisterOption) {
-	if err := SafeRegisterRowStruct(t, opt...); err != nil {
-		panic(fmt.Errorf("RegisterStruct failed: %w", err))
-	}
+	defaultRegistry.RegisterRowStruct(t, opt...)
 }
 
 // SafeRegisterRowStruct calls RegisterRowStruct but returns an error instead of
 // panicking if there are any issues.
 func SafeRegisterRowStruct(t reflect.Type, opt ...RegisterOption) error {
-	_, err := getOrRegisterType(t, opt...)
+	return defaultRegistry.SafeRegisterRowStruct(t, opt...)
+}
+
+// RegisterRowStruct registers a struct type in r; see the RegisterRowStruct
+// function.
+func (r *Registry) RegisterRowStruct(t reflect.Type, opt ...RegisterOption) {
+	if err := r.SafeRegisterRowStruct(t, opt...); err != nil {
+		panic(fmt.Errorf("RegisterStruct failed: %w", err))
+	}
+}
+
+// SafeRegisterRowStruct calls r.RegisterRowStruct but returns an error instead
+// of panicking if there are any issues.
+func (r *Registry) SafeRegisterRowStruct(t reflect.Type, opt ...RegisterOption) error {
+	_, err := r.getOrRegisterType(t, opt...)
 	return err
 }
 
@@ -213,6 +356,10 @@ type registeredType struct {
 	requiredColumnNames map[string]struct{}
 	parser              *structParser
 	makeZero            func() interface{}
+	// columns are the parsed fields of the struct in declaration order.
+	columns []*structColumn
+	// matcher, if not nil, matches header columns to the columns.
+	matcher *columnMatcher
 }
 
 func (rt *registeredType) parseRow(row *Row) (interface{}, error) {
@@ -221,77 +368,61 @@ func (rt *registeredType) parseRow(row *Row) (interface{}, error) {
 	return v, err
 }
 
-func getRegisteredType(t reflect.Type) *registeredType {
-	var rt *registeredType
-	func() {
-		defaultRegistry.registeredRowTypesLock.RLock()
-		defer defaultRegistry.registeredRowTypesLock.RUnlock()
-		rt = defaultRegistry.registeredRowTypes[t]
-	}()
-	return rt
+func (r *Registry) getRegisteredType(t reflect.Type) *registeredType {
+	r.registeredRowTypesLock.RLock()
+	defer r.registeredRowTypesLock.RUnlock()
+	return r.registeredRowTypes[t]
 }
 
-func getRegisteredTypeOrErr(t reflect.Type) (*registeredType, error) {
-	rt := getRegisteredType(t)
+func (r *Registry) getRegisteredTypeOrErr(t reflect.Type) (*registeredType, error) {
+	rt := r.getRegisteredType(t)
 	if rt == nil {
+		r.registeredRowTypesLock.RLock()
+		defer r.registeredRowTypesLock.RUnlock()
 		var typeStrings []string
-		for _, rt := range defaultRegistry.registeredRowTypes {
+		for rt := range r.registeredRowTypes {
 			typeStrings = append(typeStrings, fmt.Sprintf("%v", rt))
 		}
+		sort.Strings(typeStrings)
 		return nil, fmt.Errorf("no CSV row parser registered for type %v; registered types: [%s]", t, strings.Join(typeStrings, ", "))
 	}
 	return rt, nil
 }
 
-func getOrRegisterType(t reflect.Type, opt ...RegisterOption) (*registeredType, error) {
-	if rt := getRegisteredType(t); rt != nil {
+func (r *Registry) getOrRegisterType(t reflect.Type, opt ...RegisterOption) (*registeredType, error) {
+	if rt := r.getRegisteredType(t); rt != nil {
 		return rt, nil
 	}
-	rt, err := inferRegisteredType(t, opt...)
+	rt, err := r.inferRegisteredType(t, opt...)
 	if err != nil {
 		return nil, err
 	}
-	defaultRegistry.registeredRowTypesLock.Lock()
-	defer defaultRegistry.registeredRowTypesLock.Unlock()
-	defaultRegistry.registeredRowTypes[t] = rt
+	r.registeredRowTypesLock.Lock()
+	defer r.registeredRowTypesLock.Unlock()
+	r.registeredRowTypes[t] = rt
 	return rt, nil
 }
 
-func inferRegisteredType(t reflect.Type, opt ...RegisterOption) (*registeredType, error) {
+func (r *Registry) inferRegisteredType(t reflect.Type, opt ...RegisterOption) (*registeredType, error) {
 	if !(t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct) {
 		return nil, fmt.Errorf("type %v is not a pointer to a struct, so could not infer a CSV row parser", t)
 	}
 
+	columns, err := r.structColumns(t.Elem(), nil, "", "", false)
+	if err != nil {
+		return nil, err
+	}
 	requiredColumns := make(map[string]struct{})
 	var valueExtractors []func(row *Row, dst reflect.Value) error
-	for i := 0; i < t.Elem().NumField(); i++ {
-		f := t.Elem().FieldByIndex([]int{i})
-		if _, ok := f.Tag.Lookup("csv-skip"); ok {
-			continue
-		}
-		colName, ok := f.Tag.Lookup("csv")
-		if !ok {
-			colName = f.Name
-		}
-		requiredColumns[colName] = struct{}{}
-
-		cellParser, err := getOrCreateCellParserForType(reflect.PtrTo(f.Type))
-		if err != nil {
-			return nil, err
+	for _, col := range columns {
+		if name := col.requiredColumn(); name != "" {
+			requiredColumns[name] = struct{}{}
 		}
-
-		valueExtractors = append(valueExtractors, func(row *Row, dstRow reflect.Value) error {
-			idx := row.Header().ColumnIndex(colName)
-			if !idx.IsValid() {
-				return fmt.Errorf("csv file missing required column %q", colName)
-			}
-			if idx.Offset() >= len(row.Strings()) {
-				return fmt.Errorf("csv row does not have a value for column %q", colName)
-			}
-			strValue := row.Strings()[idx.Offset()]
-			return cellParser.ParseCSVCell(NewCellContext(row), strValue, dstRow.Elem().FieldByIndex(f.Index).Addr())
-		})
-
+		valueExtractors = append(valueExtractors, col.parse)
+	}
+	matcher, err := newColumnMatcher(t, newRowConfig(opt), columns)
+	if err != nil {
+		return nil, err
 	}
 	return &registeredType{
 		t,
@@ -300,6 +431,8 @@ func inferRegisteredType(t reflect.Type, opt ...RegisterOption) (*registeredType
 		func() interface{} {
 			return reflect.New(t.Elem()).Interface()
 		},
+		columns,
+		matcher,
 	}, nil
 }
 
@@ -316,17 +449,3 @@ func (p *structParser) ParseCSVRow(row *Row, dst interface{}) error {
 	}
 	return nil
 }
-
-type registry struct {
-	cellParsers            map[reflect.Type]*registeredCellParser
-	registeredRowTypes     map[reflect.Type]*registeredType
-	registeredRowTypesLock sync.RWMutex
-}
-
-func newRegistry() *registry {
-	return &registry{
-		make(map[reflect.Type]*registeredCellParser),
-		make(map[reflect.Type]*registeredType),
-		sync.RWMutex{},
-	}
-}
@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fieldOptions are the options of a row struct field given by its tags.
+type fieldOptions struct {
+	// column is the name of the field's column.
+	column string
+	// optional is true if the column may be missing from the header or row.
+	optional bool
+	// defaultValue, if hasDefault is true, is parsed in place of an empty or
+	// missing cell.
+	defaultValue string
+	hasDefault   bool
+	// nonempty is true if the cell may not be empty.
+	nonempty bool
+	// nullable is true for pointer fields, which are nil for empty cells.
+	nullable bool
+	// flatten is true for struct fields whose own fields are columns. The
+	// columns of those fields are named with prefix prepended.
+	flatten bool
+	prefix  string
+	// split, if not empty, is the separator of the elements of a slice field
+	// in a single cell.
+	split string
+	// repeated is true for slice fields whose elements are in the columns
+	// named column_1, column_2, and so on.
+	repeated bool
+	// pattern, if not nil, must match the text of non-empty cells.
+	pattern *regexp.Regexp
+	// min and max, if not nil, bound the parsed values of numeric fields.
+	min, max *float64
+}
+
+// parseFieldOptions returns the options of a struct field. The `csv` tag has
+// the form "column,option,...", and the options are described in the
+// documentation of RegisterRowStruct. The name is the field's name in error
+// messages. If decodable is true, the field's type can be decoded from a
+// single cell, so an embedded struct is only flattened with a prefix= option.
+func parseFieldOptions(f reflect.StructField, name string, decodable bool) (*fieldOptions, error) {
+	opts := &fieldOptions{column: f.Name, nullable: f.Type.Kind() == reflect.Ptr}
+	tag, _ := f.Tag.Lookup("csv")
+	parts := strings.Split(tag, ",")
+	if parts[0] != "" {
+		opts.column = parts[0]
+	}
+	required, hasPrefix := false, false
+	var flattenConflicts []string
+	for _, part := range parts[1:] {
+		switch {
+		case part == "optional":
+			opts.optional = true
+		case part == "required":
+			required = true
+		case part == "nonempty":
+			opts.nonempty = true
+		case part == "repeated":
+			opts.repeated = true
+		case strings.HasPrefix(part, "default="):
+			opts.defaultValue = strings.TrimPrefix(part, "default=")
+			opts.hasDefault = true
+			opts.optional = true
+		case strings.HasPrefix(part, "prefix="):
+			opts.prefix = strings.TrimPrefix(part, "prefix=")
+			hasPrefix = true
+			continue
+		case strings.HasPrefix(part, "split="):
+			opts.split = strings.TrimPrefix(part, "split=")
+			if opts.split == "" {
+				return nil, fmt.Errorf("field %s has an empty split separator", name)
+			}
+		default:
+			return nil, fmt.Errorf("field %s has unknown csv tag option %q", name, part)
+		}
+		if part != "optional" {
+			flattenConflicts = append(flattenConflicts, part)
+		}
+	}
+	if required && opts.optional {
+		return nil, fmt.Errorf("field %s is both required and optional", name)
+	}
+
+	opts.flatten = hasPrefix || (f.Anonymous && parts[0] == "" && valueType(f.Type).Kind() == reflect.Struct && !decodable)
+	if opts.flatten {
+		if f.Type.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("field %s has type %v, but only struct fields may be flattened into columns", name, f.Type)
+		}
+		if len(flattenConflicts) != 0 {
+			return nil, fmt.Errorf("field %s is flattened into columns, so it may not have csv tag option %q", name, flattenConflicts[0])
+		}
+		return opts, nil
+	}
+	if opts.split != "" || opts.repeated {
+		if opts.split != "" && opts.repeated {
+			return nil, fmt.Errorf("field %s may not have both split and repeated csv tag options", name)
+		}
+		if f.Type.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("field %s has type %v, but split and repeated csv tag options require a slice", name, f.Type)
+		}
+		if opts.repeated && opts.hasDefault {
+			return nil, fmt.Errorf("field %s may not have both repeated and default csv tag options", name)
+		}
+		opts.nullable = false
+	}
+
+	if pattern, ok := f.Tag.Lookup("csv-pattern"); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("field %s has invalid csv-pattern: %w", name, err)
+		}
+		opts.pattern = re
+	}
+	for _, bound := range []struct {
+		tag string
+		dst **float64
+	}{{"csv-min", &opts.min}, {"csv-max", &opts.max}} {
+		s, ok := f.Tag.Lookup(bound.tag)
+		if !ok {
+			continue
+		}
+		if vt := opts.valueType(f.Type); numericKind(vt) == 0 {
+			return nil, fmt.Errorf("field %s has a %s tag but type %v is not numeric", name, bound.tag, vt)
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s has invalid %s tag: %w", name, bound.tag, err)
+		}
+		*bound.dst = &v
+	}
+	return opts, nil
+}
+
+// valueType returns the type of the values parsed for a field of type t: the
+// element type of slices gathered from several values, and of nullable
+// pointers.
+func (o *fieldOptions) valueType(t reflect.Type) reflect.Type {
+	if o.split != "" || o.repeated {
+		return t.Elem()
+	}
+	return valueType(t)
+}
+
+// valueType returns the type of the values parsed for a field of type t,
+// which is the element type of nullable pointer fields.
+func valueType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// numericKind returns reflect.Int, reflect.Uint or reflect.Float64 for the
+// corresponding kinds of numbers, or 0 for other types.
+func numericKind(t reflect.Type) reflect.Kind {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return 0
+}
+
+// checkText returns an error if the text of a cell is not allowed.
+func (o *fieldOptions) checkText(text string) error {
+	if text == "" {
+		if o.nonempty {
+			return fmt.Errorf("value may not be empty")
+		}
+		return nil
+	}
+	if o.pattern != nil && !o.pattern.MatchString(text) {
+		return fmt.Errorf("value %q does not match pattern %q", text, o.pattern)
+	}
+	return nil
+}
+
+// checkRange returns an error if a parsed numeric value is out of range.
+func (o *fieldOptions) checkRange(v reflect.Value) error {
+	if o.min == nil && o.max == nil {
+		return nil
+	}
+	var f float64
+	switch numericKind(v.Type()) {
+	case reflect.Int:
+		f = float64(v.Int())
+	case reflect.Uint:
+		f = float64(v.Uint())
+	default:
+		f = v.Float()
+	}
+	if o.min != nil && f < *o.min {
+		return fmt.Errorf("value %v is less than the minimum %v", v, *o.min)
+	}
+	if o.max != nil && f > *o.max {
+		return fmt.Errorf("value %v is greater than the maximum %v", v, *o.max)
+	}
+	return nil
+}
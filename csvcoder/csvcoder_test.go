@@ -16,12 +16,16 @@ package csvcoder
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/xtoproto/textcoder"
@@ -31,6 +35,9 @@ func init() {
 	RegisterRowStruct(reflect.TypeOf(&abee{}))
 	RegisterRowStruct(reflect.TypeOf(&implicitFields{}))
 	RegisterRowStruct(reflect.TypeOf(&measurements{}))
+	RegisterRowStruct(reflect.TypeOf(&order{}))
+	RegisterRowStruct(reflect.TypeOf(&shipment{}))
+	RegisterRowStruct(reflect.TypeOf(&timestamped{}))
 }
 
 type abee struct {
@@ -45,7 +52,39 @@ type implicitFields struct {
 type measurements struct {
 	// Both fields are backed by the same column.
 	Dist  distance
-	Dist2 *distance `csv-skip:"true"`
+	Dist2 *distance `csv:"Dist"`
+}
+
+type order struct {
+	Email    string    `csv:"email,required,nonempty" csv-pattern:"^[^@]+@[^@]+$"`
+	Quantity int       `csv:"qty,default=1" csv-min:"1" csv-max:"100"`
+	Note     string    `csv:"note,optional"`
+	Weight   *distance `csv:"weight,optional" csv-max:"1000"`
+}
+
+type audit struct {
+	CreatedBy string `csv:"created_by"`
+}
+
+type address struct {
+	Street string `csv:"street"`
+	City   string `csv:"city,optional"`
+}
+
+type shipment struct {
+	audit
+	ID     string   `csv:"id"`
+	From   address  `csv:",prefix=from_"`
+	To     address  `csv:",prefix=to_,optional"`
+	Tags   []string `csv:"tags,optional,split=;"`
+	Scores []int    `csv:"score,repeated" csv-max:"10"`
+}
+
+// timestamped embeds a struct with a decoder, so it is decoded from the Time
+// column rather than flattened.
+type timestamped struct {
+	time.Time
+	Name string `csv:"name"`
 }
 
 type distance float64 // in meters
@@ -144,6 +183,137 @@ func TestParseCSVRow(t *testing.T) {
 			&implicitFields{"y"},
 			nil,
 		},
+		{
+			"order - all columns",
+			[]string{"email", "qty", "note", "weight"},
+			[]string{"a@example.com", "5", "fragile", "250"},
+			&order{},
+			&order{"a@example.com", 5, "fragile", distancePtr(250)},
+			nil,
+		},
+		{
+			"order - defaults for empty cells",
+			[]string{"email", "qty", "note", "weight"},
+			[]string{"a@example.com", "", "", ""},
+			&order{},
+			&order{"a@example.com", 1, "", nil},
+			nil,
+		},
+		{
+			"order - missing optional columns",
+			[]string{"email"},
+			[]string{"a@example.com"},
+			&order{},
+			&order{"a@example.com", 1, "", nil},
+			nil,
+		},
+		{
+			"order - short row",
+			[]string{"email", "note", "qty"},
+			[]string{"a@example.com", "x"},
+			&order{},
+			&order{"a@example.com", 1, "x", nil},
+			nil,
+		},
+		{
+			"order - missing required column",
+			[]string{"qty"},
+			[]string{"3"},
+			&order{},
+			nil,
+			regexp.MustCompile(`^test\.csv:124: .*missing required column "email"`),
+		},
+		{
+			"order - empty required cell",
+			[]string{"email"},
+			[]string{""},
+			&order{},
+			nil,
+			regexp.MustCompile(`^test\.csv:124: .*column "email": value may not be empty`),
+		},
+		{
+			"order - pattern mismatch",
+			[]string{"email"},
+			[]string{"nobody"},
+			&order{},
+			nil,
+			regexp.MustCompile(`column "email": value "nobody" does not match pattern`),
+		},
+		{
+			"order - below minimum",
+			[]string{"email", "qty"},
+			[]string{"a@example.com", "0"},
+			&order{},
+			nil,
+			regexp.MustCompile(`column "qty": value 0 is less than the minimum 1`),
+		},
+		{
+			"order - pointer above maximum",
+			[]string{"email", "weight"},
+			[]string{"a@example.com", "2km"},
+			&order{},
+			nil,
+			regexp.MustCompile(`column "weight": value 2000 is greater than the maximum 1000`),
+		},
+		{
+			"shipment - nested, embedded and slice fields",
+			[]string{"id", "created_by", "from_street", "from_city", "to_street", "tags", "score_1", "score_2", "score_3"},
+			[]string{"s1", "ann", "1 Main St", "Springfield", "2 Elm St", "fragile;urgent", "3", "", "7"},
+			&shipment{},
+			&shipment{
+				audit:  audit{CreatedBy: "ann"},
+				ID:     "s1",
+				From:   address{"1 Main St", "Springfield"},
+				To:     address{Street: "2 Elm St"},
+				Tags:   []string{"fragile", "urgent"},
+				Scores: []int{3, 7},
+			},
+			nil,
+		},
+		{
+			"shipment - optional prefixed struct and slices missing",
+			[]string{"id", "created_by", "from_street", "score_1", "score_3"},
+			[]string{"s2", "bo", "1 Main St", "", "4"},
+			&shipment{},
+			&shipment{
+				audit: audit{CreatedBy: "bo"},
+				ID:    "s2",
+				From:  address{Street: "1 Main St"},
+			},
+			nil,
+		},
+		{
+			"shipment - missing nested column",
+			[]string{"id", "created_by", "score_1"},
+			[]string{"s3", "cy", "1"},
+			&shipment{},
+			nil,
+			regexp.MustCompile(`missing required column "from_street"`),
+		},
+		{
+			"timestamped - embedded struct with a decoder",
+			[]string{"Time", "name"},
+			[]string{"2020-01-01T00:00:00Z", "new year"},
+			&timestamped{},
+			&timestamped{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "new year"},
+			nil,
+		},
+		{
+			"shipment - missing first repeated column",
+			[]string{"id", "created_by", "from_street", "score_2"},
+			[]string{"s4", "cy", "x", "1"},
+			&shipment{},
+			nil,
+			regexp.MustCompile(`missing required column "score_1"`),
+		},
+		{
+			"shipment - invalid repeated element",
+			[]string{"id", "created_by", "from_street", "score_1", "score_2"},
+			[]string{"s5", "cy", "x", "1", "11"},
+			&shipment{},
+			nil,
+			regexp.MustCompile(`column "score_2": value 11 is greater than the maximum 10`),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			err := ParseRow(NewRow(tt.values, NewHeader(tt.header), 123, "test.csv"), tt.dst)
@@ -151,7 +321,7 @@ func TestParseCSVRow(t *testing.T) {
 			if err != nil {
 				return
 			}
-			if diff := cmp.Diff(tt.want, tt.dst); diff != "" {
+			if diff := cmp.Diff(tt.want, tt.dst, cmp.AllowUnexported(shipment{})); diff != "" {
 				t.Errorf("unexpected diff (-want, +got):\n%s", diff)
 			}
 		})
@@ -191,9 +361,8 @@ func TestFileParser(t *testing.T) {
 			joinWithNewlines(`Dist,extra`, `50  ,x`, ` 50 km,`),
 			&measurements{},
 			[]interface{}{
-				// TODO(reddaly): Restore support for pointer fields.
-				&measurements{Dist: 50, Dist2: nil /* distancePtr(50) */},
-				&measurements{Dist: 50000, Dist2: nil /* distancePtr(50000) */},
+				&measurements{Dist: 50, Dist2: distancePtr(50)},
+				&measurements{Dist: 50000, Dist2: distancePtr(50000)},
 			},
 			nil,
 			nil,
@@ -223,6 +392,150 @@ func TestFileParser(t *testing.T) {
 	}
 }
 
+func TestFileParser_LastRow(t *testing.T) {
+	cr := csv.NewReader(strings.NewReader(joinWithNewlines(`A,Bee`, `xy,42`, `zz,nope`, `"x,1`)))
+	fp, err := NewFileParser(cr, "test.csv", &abee{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	if got := fp.LastRow(); got != nil {
+		t.Errorf("LastRow() before Read() = %v, want nil", got)
+	}
+	for _, want := range []struct {
+		position string
+		values   []string
+		wantErr  bool
+	}{
+		{"test.csv:2", []string{"xy", "42"}, false},
+		{"test.csv:3", []string{"zz", "nope"}, true},
+		{"test.csv:4", nil, true},
+	} {
+		_, err := fp.Read()
+		if (err != nil) != want.wantErr {
+			t.Errorf("Read() got error %v, wantErr %v", err, want.wantErr)
+		}
+		row := fp.LastRow()
+		if got := row.PositionString(); got != want.position {
+			t.Errorf("LastRow().PositionString() = %q, want %q", got, want.position)
+		}
+		if diff := cmp.Diff(want.values, row.Strings()); diff != "" {
+			t.Errorf("unexpected diff in LastRow().Strings() (-want, +got):\n%s", diff)
+		}
+	}
+	if _, err := fp.Read(); err != io.EOF {
+		t.Errorf("Read() at end of input got error %v, want io.EOF", err)
+	}
+	if got := fp.LastRow(); got != nil {
+		t.Errorf("LastRow() after io.EOF = %v, want nil", got)
+	}
+}
+
+func TestFileParser_CellError(t *testing.T) {
+	cr := csv.NewReader(strings.NewReader(joinWithNewlines(`A,Bee`, `zz,nope`)))
+	fp, err := NewFileParser(cr, "test.csv", &abee{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	_, err = fp.Read()
+	var cellErr *CellError
+	if !errors.As(err, &cellErr) {
+		t.Fatalf("Read() got error %v, want a *CellError", err)
+	}
+	got := struct {
+		Position, ColumnName, Value, Field string
+		Column                             ColumnNumber
+	}{cellErr.PositionString(), cellErr.ColumnName, cellErr.Value, cellErr.Field, cellErr.Column}
+	want := struct {
+		Position, ColumnName, Value, Field string
+		Column                             ColumnNumber
+	}{"test.csv:2:2", "Bee", "nope", "B", 1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected diff in CellError (-want, +got):\n%s", diff)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Read() got error %v, want an error wrapping strconv.ErrSyntax", err)
+	}
+}
+
+func TestRegisterRowStruct_badTags(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		t       reflect.Type
+		wantErr *regexp.Regexp
+	}{
+		{
+			"unknown option",
+			reflect.TypeOf(&struct {
+				A string `csv:"a,sometimes"`
+			}{}),
+			regexp.MustCompile(`field A has unknown csv tag option "sometimes"`),
+		},
+		{
+			"required and optional",
+			reflect.TypeOf(&struct {
+				A string `csv:"a,required,default=x"`
+			}{}),
+			regexp.MustCompile(`field A is both required and optional`),
+		},
+		{
+			"bad pattern",
+			reflect.TypeOf(&struct {
+				A string `csv:"a" csv-pattern:"("`
+			}{}),
+			regexp.MustCompile(`field A has invalid csv-pattern`),
+		},
+		{
+			"range on string",
+			reflect.TypeOf(&struct {
+				A string `csv:"a" csv-min:"1"`
+			}{}),
+			regexp.MustCompile(`field A has a csv-min tag but type string is not numeric`),
+		},
+		{
+			"prefix on a non-struct",
+			reflect.TypeOf(&struct {
+				A string `csv:"a,prefix=x_"`
+			}{}),
+			regexp.MustCompile(`field A has type string, but only struct fields may be flattened`),
+		},
+		{
+			"prefix with other options",
+			reflect.TypeOf(&struct {
+				A address `csv:",prefix=x_,nonempty"`
+			}{}),
+			regexp.MustCompile(`field A is flattened into columns, so it may not have csv tag option "nonempty"`),
+		},
+		{
+			"repeated on a non-slice",
+			reflect.TypeOf(&struct {
+				A int `csv:"a,repeated"`
+			}{}),
+			regexp.MustCompile(`field A has type int, but split and repeated csv tag options require a slice`),
+		},
+		{
+			"nested field error",
+			reflect.TypeOf(&struct {
+				A address `csv:",prefix=x_"`
+				B struct {
+					C int `csv:"c,sometimes"`
+				} `csv:",prefix=b_"`
+			}{}),
+			regexp.MustCompile(`field B.C has unknown csv tag option "sometimes"`),
+		},
+		{
+			"bad range",
+			reflect.TypeOf(&struct {
+				A int `csv:"a" csv-max:"lots"`
+			}{}),
+			regexp.MustCompile(`field A has invalid csv-max tag`),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, SafeRegisterRowStruct(tt.t), tt.wantErr, "SafeRegisterRowStruct")
+		})
+	}
+}
+
 func checkErr(t *testing.T, err error, wantErr *regexp.Regexp, prefix string) {
 	if gotErr, wantErr := err != nil, wantErr != nil; gotErr != wantErr {
 		t.Fatalf("%s: got err %v, wantErr = %v", prefix, err, wantErr)
@@ -263,3 +576,547 @@ func Test_ParseCell(t *testing.T) {
 		t.Errorf("got %v, want %v", myX, 55)
 	}
 }
+
+type coordinate struct {
+	lat, lng float64
+}
+
+type place struct {
+	Name     string     `csv:"name"`
+	Visits   int        `csv:"visits"`
+	Open     bool       `csv:"open"`
+	Rating   float64    `csv:"rating"`
+	Location coordinate `csv:"location"`
+	Notes    string     `csv-skip:"true"`
+}
+
+// secret can be decoded but not encoded.
+type secret struct {
+	value string
+}
+
+func (s *secret) UnmarshalText(text []byte) error {
+	s.value = string(text)
+	return nil
+}
+
+type login struct {
+	User     string `csv:"user"`
+	Password secret `csv:"password"`
+}
+
+type duplicateColumns struct {
+	A string `csv:"x"`
+	B string `csv:"x"`
+}
+
+func init() {
+	textcoder.MustRegister(
+		reflect.TypeOf(coordinate{}),
+		func(c coordinate) (string, error) {
+			return fmt.Sprintf("%g %g", c.lat, c.lng), nil
+		},
+		func(s string, dst *coordinate) error {
+			_, err := fmt.Sscanf(s, "%g %g", &dst.lat, &dst.lng)
+			return err
+		})
+}
+
+func TestFileWriter(t *testing.T) {
+	places := []interface{}{
+		&place{Name: "Lighthouse, north", Visits: 3, Open: true, Rating: 4.5, Location: coordinate{44.5, -68.25}},
+		&place{Name: "Quarry \"B\"", Visits: 0, Rating: -1, Location: coordinate{0, 0.125}},
+	}
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	fw, err := NewFileWriter(cw, "places.csv", &place{})
+	if err != nil {
+		t.Fatalf("NewFileWriter() failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"name", "visits", "open", "rating", "location"}, fw.Header().ColumnNames()); diff != "" {
+		t.Errorf("unexpected diff in Header().ColumnNames() (-want, +got):\n%s", diff)
+	}
+	for _, p := range places {
+		if err := fw.Write(p); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		t.Fatal(err)
+	}
+	want := joinWithNewlines(
+		`name,visits,open,rating,location`,
+		`"Lighthouse, north",3,true,4.5,44.5 -68.25`,
+		`"Quarry ""B""",0,false,-1,0 0.125`,
+		``)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected diff in output (-want, +got):\n%s", diff)
+	}
+
+	fp, err := NewFileParser(csv.NewReader(strings.NewReader(out.String())), "places.csv", &place{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	var got []interface{}
+	if err := fp.ReadAll(func(v interface{}) error {
+		got = append(got, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(places, got, cmp.AllowUnexported(coordinate{})); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+
+	if err := fw.Write(&abee{}); err == nil {
+		t.Errorf("Write() got nil error for a record of the wrong type")
+	}
+}
+
+func TestFileWriter_floatRoundTrip(t *testing.T) {
+	sum := 0.1
+	sum += 0.2
+	places := []interface{}{
+		&place{Name: "tiny", Rating: 1e-7},
+		&place{Name: "sum", Rating: sum},
+		&place{Name: "huge", Rating: 6.02214076e23},
+	}
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	fw, err := NewFileWriter(cw, "places.csv", &place{})
+	if err != nil {
+		t.Fatalf("NewFileWriter() failed: %v", err)
+	}
+	for _, p := range places {
+		if err := fw.Write(p); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	cw.Flush()
+	want := joinWithNewlines(
+		`name,visits,open,rating,location`,
+		`tiny,0,false,1e-07,0 0`,
+		`sum,0,false,0.30000000000000004,0 0`,
+		`huge,0,false,6.02214076e+23,0 0`,
+		``)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected diff in output (-want, +got):\n%s", diff)
+	}
+
+	fp, err := NewFileParser(csv.NewReader(strings.NewReader(out.String())), "places.csv", &place{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	var got []interface{}
+	if err := fp.ReadAll(func(v interface{}) error {
+		got = append(got, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(places, got, cmp.AllowUnexported(coordinate{})); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+}
+
+type observation struct {
+	When time.Time `csv:"when"`
+	Temp float64   `csv:"temp"`
+}
+
+func TestWithTextContext(t *testing.T) {
+	ctx := textcoder.DefaultRegistry().NewContext().WithTimeLayout("2006-01-02").WithFloatFormat('f', 1)
+	observations := []interface{}{
+		&observation{When: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Temp: -3.5},
+		&observation{When: time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC), Temp: 30},
+	}
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	fw, err := NewFileWriter(cw, "observations.csv", &observation{}, WithTextContext(ctx))
+	if err != nil {
+		t.Fatalf("NewFileWriter() failed: %v", err)
+	}
+	for _, r := range observations {
+		if err := fw.Write(r); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	cw.Flush()
+	want := joinWithNewlines(
+		`when,temp`,
+		`2020-01-01,-3.5`,
+		`2020-07-04,30.0`,
+		``)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected diff in output (-want, +got):\n%s", diff)
+	}
+
+	fp, err := NewFileParser(csv.NewReader(strings.NewReader(out.String())), "observations.csv", &observation{}, WithTextContext(ctx))
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	var got []interface{}
+	if err := fp.ReadAll(func(v interface{}) error {
+		got = append(got, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(observations, got); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+
+	fp, err = NewFileParser(csv.NewReader(strings.NewReader(out.String())), "observations.csv", &observation{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	if _, err := fp.Read(); err == nil {
+		t.Errorf("Read() without WithTextContext option got nil error for a date in a custom layout")
+	}
+}
+
+func TestFileWriter_nestedAndSliceFields(t *testing.T) {
+	shipments := []interface{}{
+		&shipment{
+			audit:  audit{CreatedBy: "ann"},
+			ID:     "s1",
+			From:   address{"1 Main St", "Springfield"},
+			Tags:   []string{"fragile", "urgent"},
+			Scores: []int{3, 7, 1},
+		},
+		&shipment{
+			audit:  audit{CreatedBy: "bo"},
+			ID:     "s2",
+			From:   address{Street: "2 Elm St"},
+			Scores: []int{5},
+		},
+	}
+	out := &strings.Builder{}
+	cw := csv.NewWriter(out)
+	fw, err := NewFileWriter(cw, "shipments.csv", &shipment{Scores: make([]int, 3)})
+	if err != nil {
+		t.Fatalf("NewFileWriter() failed: %v", err)
+	}
+	for _, s := range shipments {
+		if err := fw.Write(s); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+	if err := fw.Write(&shipment{Scores: make([]int, 4)}); err == nil {
+		t.Errorf("Write() got nil error for a record with too many repeated values")
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		t.Fatal(err)
+	}
+	want := joinWithNewlines(
+		`created_by,id,from_street,from_city,to_street,to_city,tags,score_1,score_2,score_3`,
+		`ann,s1,1 Main St,Springfield,,,fragile;urgent,3,7,1`,
+		`bo,s2,2 Elm St,,,,,5,,`,
+		``)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected diff in output (-want, +got):\n%s", diff)
+	}
+
+	fp, err := NewFileParser(csv.NewReader(strings.NewReader(out.String())), "shipments.csv", &shipment{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	var got []interface{}
+	if err := fp.ReadAll(func(v interface{}) error {
+		got = append(got, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadAll() failed: %v", err)
+	}
+	if diff := cmp.Diff(shipments, got, cmp.AllowUnexported(shipment{})); diff != "" {
+		t.Errorf("records changed in round trip (-want, +got):\n%s", diff)
+	}
+
+	out.Reset()
+	cw = csv.NewWriter(out)
+	fw, err = NewFileWriter(cw, "shipments.csv", &shipment{}, RepeatCount("score", 2))
+	if err != nil {
+		t.Fatalf("NewFileWriter() with RepeatCount option failed: %v", err)
+	}
+	if err := fw.Write(shipments[1]); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	cw.Flush()
+	want = joinWithNewlines(
+		`created_by,id,from_street,from_city,to_street,to_city,tags,score_1,score_2`,
+		`bo,s2,2 Elm St,,,,,5,`,
+		``)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected diff in output with RepeatCount option (-want, +got):\n%s", diff)
+	}
+}
+
+// level is parsed differently by the registries in TestRegistry.
+type level int
+
+type alarm struct {
+	Name  string `csv:"name"`
+	Level level  `csv:"level"`
+}
+
+func TestRegistry(t *testing.T) {
+	newRegistry := func(names ...string) *Registry {
+		tr := textcoder.NewRegistry()
+		if err := textcoder.RegisterBasicTypes(tr); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Register(
+			reflect.TypeOf(level(0)),
+			func(l level) (string, error) {
+				return names[l], nil
+			},
+			func(s string, dst *level) error {
+				for i, name := range names {
+					if name == s {
+						*dst = level(i)
+						return nil
+					}
+				}
+				return fmt.Errorf("unknown level %q", s)
+			}); err != nil {
+			t.Fatal(err)
+		}
+		return NewRegistry(tr)
+	}
+	words := newRegistry("low", "high")
+	symbols := newRegistry("-", "+")
+
+	for _, tt := range []struct {
+		name     string
+		registry *Registry
+		csvIn    string
+		want     []interface{}
+	}{
+		{"words", words, joinWithNewlines(`name,level`, `a,high`, `b,low`), []interface{}{&alarm{"a", 1}, &alarm{"b", 0}}},
+		{"symbols", symbols, joinWithNewlines(`name,level`, `a,+`, `b,-`), []interface{}{&alarm{"a", 1}, &alarm{"b", 0}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := NewFileParser(csv.NewReader(strings.NewReader(tt.csvIn)), "alarms.csv", &alarm{}, WithRegistry(tt.registry))
+			if err != nil {
+				t.Fatalf("NewFileParser() failed: %v", err)
+			}
+			var got []interface{}
+			if err := fp.ReadAll(func(v interface{}) error {
+				got = append(got, v)
+				return nil
+			}); err != nil {
+				t.Fatalf("ReadAll() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+			}
+
+			out := &strings.Builder{}
+			cw := csv.NewWriter(out)
+			fw, err := NewFileWriter(cw, "alarms.csv", &alarm{}, WithRegistry(tt.registry))
+			if err != nil {
+				t.Fatalf("NewFileWriter() failed: %v", err)
+			}
+			for _, a := range got {
+				if err := fw.Write(a); err != nil {
+					t.Fatalf("Write() failed: %v", err)
+				}
+			}
+			cw.Flush()
+			if diff := cmp.Diff(tt.csvIn+"\n", out.String()); diff != "" {
+				t.Errorf("unexpected diff in written file (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if rt := DefaultRegistry().getRegisteredType(reflect.TypeOf(&alarm{})); rt != nil {
+		t.Errorf("alarm was registered in the default registry by parsers using other registries")
+	}
+	if err := words.ParseRow(NewRow([]string{"a", "+"}, NewHeader([]string{"name", "level"}), 1, "alarms.csv"), &alarm{}); err == nil {
+		t.Errorf("ParseRow() got nil error for a level from another registry")
+	}
+}
+
+type contact struct {
+	FirstName string   `csv:"first_name"`
+	Email     string   `csv:"email"`
+	Phones    []string `csv:"phone,repeated,optional"`
+}
+
+func TestRegisterOption(t *testing.T) {
+	for _, tt := range []struct {
+		name                    string
+		opts                    []RegisterOption
+		csvIn                   string
+		want                    []interface{}
+		wantNewErr, wantReadErr *regexp.Regexp
+	}{
+		{
+			name:  "no options",
+			opts:  []RegisterOption{{}},
+			csvIn: joinWithNewlines(`first_name,email,phone_1,age`, `Ann,a@x,555,40`),
+			want:  []interface{}{&contact{"Ann", "a@x", []string{"555"}}},
+		},
+		{
+			name:  "ignore word separators",
+			opts:  []RegisterOption{NormalizeColumnNames(IgnoreWordSeparators)},
+			csvIn: joinWithNewlines(`First Name,EMAIL,Phone 1,phone_2`, `Ann,a@x,555,556`),
+			want:  []interface{}{&contact{"Ann", "a@x", []string{"555", "556"}}},
+		},
+		{
+			name:  "fold case and trim space",
+			opts:  []RegisterOption{NormalizeColumnNames(TrimSpace, FoldCase)},
+			csvIn: joinWithNewlines(` FIRST_NAME ,Email`, `Ann,a@x`),
+			want:  []interface{}{&contact{FirstName: "Ann", Email: "a@x"}},
+		},
+		{
+			name:  "aliases",
+			opts:  []RegisterOption{ColumnAliases("email", "e-mail", "mail"), ColumnAliases("phone", "tel")},
+			csvIn: joinWithNewlines(`first_name,mail,tel_1`, `Ann,a@x,555`),
+			want:  []interface{}{&contact{"Ann", "a@x", []string{"555"}}},
+		},
+		{
+			name:       "reject extra columns",
+			opts:       []RegisterOption{WithExtraColumnPolicy(RejectExtraColumns)},
+			csvIn:      joinWithNewlines(`first_name,email,phone_1,age`, `Ann,a@x,555,40`),
+			wantNewErr: regexp.MustCompile(`header row has 1 columns not used by \*csvcoder.contact: "age"`),
+		},
+		{
+			name:       "columns matching the same field",
+			opts:       []RegisterOption{NormalizeColumnNames(FoldCase)},
+			csvIn:      joinWithNewlines(`first_name,email,EMAIL`, `Ann,a@x,b@x`),
+			wantNewErr: regexp.MustCompile(`header columns "email" and "EMAIL" both match column "email"`),
+		},
+		{
+			name:       "alias of unknown column",
+			opts:       []RegisterOption{ColumnAliases("mobile", "cell")},
+			csvIn:      joinWithNewlines(`first_name,email`),
+			wantNewErr: regexp.MustCompile(`aliases given for column "mobile", which is not a column of \*csvcoder.contact`),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(textcoder.DefaultRegistry())
+			if err := r.SafeRegisterRowStruct(reflect.TypeOf(&contact{}), tt.opts...); err != nil {
+				checkErr(t, err, tt.wantNewErr, "SafeRegisterRowStruct")
+			}
+			fp, err := NewFileParser(csv.NewReader(strings.NewReader(tt.csvIn)), "contacts.csv", &contact{}, WithRegistry(r))
+			checkErr(t, err, tt.wantNewErr, "NewFileParser")
+			var got []interface{}
+			err = fp.ReadAll(func(v interface{}) error {
+				got = append(got, v)
+				return nil
+			})
+			checkErr(t, err, tt.wantReadErr, "ReadAll")
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+			}
+
+			// ParseRow matches columns in the same way.
+			lines := strings.Split(tt.csvIn, "\n")
+			row := NewRow(strings.Split(lines[1], ","), NewHeader(strings.Split(lines[0], ",")), 1, "contacts.csv")
+			dst := &contact{}
+			if err := r.ParseRow(row, dst); err != nil {
+				t.Fatalf("ParseRow() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want[0], dst); diff != "" {
+				t.Errorf("unexpected diff from ParseRow (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegisterOption_indistinguishableColumns(t *testing.T) {
+	type row struct {
+		A string `csv:"a_b"`
+		B string `csv:"aB"`
+	}
+	err := NewRegistry(textcoder.DefaultRegistry()).SafeRegisterRowStruct(reflect.TypeOf(&row{}), NormalizeColumnNames(IgnoreWordSeparators))
+	checkErr(t, err, regexp.MustCompile(`columns "a_b" and "aB" of .* cannot be distinguished after normalization`), "SafeRegisterRowStruct")
+}
+
+func TestFileParser_headerOptions(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		csvIn      string
+		opts       []FileParserOption
+		want       []interface{}
+		wantRows   []RowNumber
+		wantNewErr *regexp.Regexp
+	}{
+		{
+			name:     "no header",
+			csvIn:    joinWithNewlines(`xy,42`, `66,45`),
+			opts:     []FileParserOption{NoHeader("A", "Bee")},
+			want:     []interface{}{&abee{"xy", 42}, &abee{"66", 45}},
+			wantRows: []RowNumber{0, 1},
+		},
+		{
+			name:     "replace header",
+			csvIn:    joinWithNewlines(`col1,col2`, `xy,42`),
+			opts:     []FileParserOption{ReplaceHeader("A", "Bee")},
+			want:     []interface{}{&abee{"xy", 42}},
+			wantRows: []RowNumber{1},
+		},
+		{
+			name:     "expected columns present",
+			csvIn:    joinWithNewlines(`A,Bee,C`, `xy,42,z`),
+			opts:     []FileParserOption{ExpectedColumns("C")},
+			want:     []interface{}{&abee{"xy", 42}},
+			wantRows: []RowNumber{1},
+		},
+		{
+			name:       "expected columns missing",
+			csvIn:      joinWithNewlines(`A,Bee`, `xy,42`),
+			opts:       []FileParserOption{ExpectedColumns("C", "D", "A")},
+			wantNewErr: regexp.MustCompile(`header row is missing 2 columns: "C", "D"`),
+		},
+		{
+			name:       "no header missing a column",
+			csvIn:      joinWithNewlines(`xy,42`),
+			opts:       []FileParserOption{NoHeader("A")},
+			wantNewErr: regexp.MustCompile(`header row is missing 1 columns: "Bee"`),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := NewFileParser(csv.NewReader(strings.NewReader(tt.csvIn)), "test.csv", &abee{}, tt.opts...)
+			checkErr(t, err, tt.wantNewErr, "NewFileParser")
+			var got []interface{}
+			var gotRows []RowNumber
+			if err := fp.ReadAll(func(v interface{}) error {
+				got = append(got, v)
+				gotRows = append(gotRows, fp.LastRow().Number())
+				return nil
+			}); err != nil {
+				t.Fatalf("ReadAll() failed: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantRows, gotRows); diff != "" {
+				t.Errorf("unexpected diff in row numbers (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewFileWriter_errors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		prototype interface{}
+		opts      []FileWriterOption
+		wantErr   *regexp.Regexp
+	}{
+		{"field without encoder", &login{}, nil, regexp.MustCompile(`no text encoder registered for type csvcoder.secret of field Password`)},
+		{"duplicate columns", &duplicateColumns{}, nil, regexp.MustCompile(`fields A and B .* both have column "x"`)},
+		{"repeated field without count", &shipment{}, nil, regexp.MustCompile(`repeated field Scores has no elements`)},
+		{"invalid repeat count", &shipment{}, []FileWriterOption{RepeatCount("score", 0)}, regexp.MustCompile(`repeated field Scores has invalid repeat count 0`)},
+		{"repeat count of unknown column", &shipment{}, []FileWriterOption{RepeatCount("score", 2), RepeatCount("tags", 2)}, regexp.MustCompile(`RepeatCount option for column "tags", but .* has no repeated field`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFileWriter(csv.NewWriter(ioutil.Discard), "out.csv", tt.prototype, tt.opts...)
+			checkErr(t, err, tt.wantErr, "NewFileWriter")
+		})
+	}
+}
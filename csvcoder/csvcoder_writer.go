@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvcoder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/xtoproto/textcoder"
+)
+
+// RowWriter is an interface of a writer that writes raw records to a data
+// sink. For example, csv.Writer is a RowWriter.
+type RowWriter interface {
+	Write(record []string) error
+}
+
+// FileWriter is an object used to write an entire CSV file. It is the inverse
+// of FileParser.
+type FileWriter struct {
+	w        RowWriter
+	filePath string
+	rt       *registeredType
+	columns  []*writerColumn
+	// ctx is the base context of the cells of each row.
+	ctx *textcoder.Context
+
+	hdr    *Header
+	rowNum RowNumber
+}
+
+// writerColumn is a field written by a FileWriter.
+type writerColumn struct {
+	*structColumn
+	enc textcoder.Encoder
+	// repeatCount is the number of columns of a repeated field.
+	repeatCount int
+}
+
+// NewFileWriter returns an object for writing records of the same type as
+// recordPrototype to a file, and writes the header row. The header names the
+// column of each field of the struct, in the order of the fields, as described
+// by RegisterRowStruct.
+//
+// Each field is encoded with the textcoder encoder for the field's type in the
+// textcoder registry of the csvcoder Registry, which is the default registry
+// unless the WithRegistry option is given; nil pointer fields are written as
+// empty cells. Floating point values are written with the fewest digits that
+// represent them exactly, so that they are parsed back to the same values.
+// The elements of split fields are encoded with the encoder of the element
+// type and joined with the separator. A repeated field is written to the
+// number of columns given by a RepeatCount option or, without one, to as many
+// columns as it has elements in recordPrototype; records with fewer elements
+// have empty cells in the remaining columns. NewFileWriter returns an error if
+// a field has no encoder, or if two fields have the same column.
+//
+// The output RowWriter will be used to write all rows. The path argument is
+// only for error reporting purposes. Note that csv.Writer buffers its output,
+// so its Flush method must be called after the last record is written.
+func NewFileWriter(w RowWriter, path string, recordPrototype interface{}, opts ...FileWriterOption) (*FileWriter, error) {
+	cfg := newFileConfig(nil, opts)
+	prototype := reflect.ValueOf(recordPrototype)
+	rt, err := cfg.registry.getOrRegisterType(prototype.Type())
+	if err != nil {
+		return nil, fmt.Errorf("could not find or infer coder for type %v: %w", prototype.Type(), err)
+	}
+	var columns []*writerColumn
+	var header []string
+	fields := make(map[string]string)
+	repeatCounts := make(map[string]int)
+	for column, n := range cfg.repeatCounts {
+		repeatCounts[column] = n
+	}
+	for _, col := range rt.columns {
+		wc := &writerColumn{structColumn: col}
+		names := []string{col.name}
+		if col.opts.repeated {
+			if n, ok := cfg.repeatCounts[col.name]; ok {
+				if n <= 0 {
+					return nil, fmt.Errorf("repeated field %s has invalid repeat count %d", col.field.Name, n)
+				}
+				wc.repeatCount = n
+				delete(repeatCounts, col.name)
+			} else if !prototype.IsNil() {
+				wc.repeatCount = prototype.Elem().FieldByIndex(col.field.Index).Len()
+			}
+			if wc.repeatCount == 0 {
+				return nil, fmt.Errorf("repeated field %s has no elements in the record prototype and no RepeatCount option, so its number of columns is unknown", col.field.Name)
+			}
+			names = nil
+			for n := 1; n <= wc.repeatCount; n++ {
+				names = append(names, repeatedColumnName(col.name, n))
+			}
+		}
+		for _, name := range names {
+			if other, ok := fields[name]; ok {
+				return nil, fmt.Errorf("fields %s and %s of %v both have column %q", other, col.field.Name, rt.t, name)
+			}
+			fields[name] = col.field.Name
+		}
+		vt := col.opts.valueType(col.field.Type)
+		wc.enc = cfg.registry.textRegistry.GetEncoder(vt)
+		if wc.enc == nil {
+			return nil, fmt.Errorf("no text encoder registered for type %v of field %s", vt, col.field.Name)
+		}
+		columns = append(columns, wc)
+		header = append(header, names...)
+	}
+	for column := range repeatCounts {
+		return nil, fmt.Errorf("RepeatCount option for column %q, but %v has no repeated field with that column", column, rt.t)
+	}
+	ctx := cfg.textContext
+	if ctx == nil {
+		ctx = cfg.registry.textRegistry.NewContext()
+	}
+	if !ctx.HasFloatFormat() {
+		ctx = ctx.WithFloatFormat('g', -1)
+	}
+	fw := &FileWriter{w, path, rt, columns, ctx, NewHeader(header), 0}
+	if err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("error writing header row: %w", err)
+	}
+	fw.rowNum = 1
+	return fw, nil
+}
+
+// Header returns the header row written by the FileWriter.
+func (fw *FileWriter) Header() *Header {
+	return fw.hdr
+}
+
+// Write encodes a record, which must have the same type as the record
+// prototype, and writes it as the next row.
+func (fw *FileWriter) Write(record interface{}) error {
+	v := reflect.ValueOf(record)
+	if v.Type() != fw.rt.t {
+		return fmt.Errorf("got record of type %v, want %v", v.Type(), fw.rt.t)
+	}
+	if v.IsNil() {
+		return fmt.Errorf("got nil %v record", fw.rt.t)
+	}
+	values := make([]string, 0, len(fw.hdr.ColumnNames()))
+	row := NewRow(values, fw.hdr, fw.rowNum, fw.filePath)
+	ctx := fw.ctx.WithValue("csvcoder.CellContext", NewCellContext(row))
+	for _, col := range fw.columns {
+		cells, err := col.encode(ctx, v.Elem().FieldByIndex(col.field.Index))
+		if err != nil {
+			return row.errorf("error encoding column %q: %w", col.name, err)
+		}
+		values = append(values, cells...)
+	}
+	if err := fw.w.Write(values); err != nil {
+		return row.errorf("error writing row: %w", err)
+	}
+	fw.rowNum++
+	return nil
+}
+
+// encode returns the cells of a field with value fv.
+func (col *writerColumn) encode(ctx *textcoder.Context, fv reflect.Value) ([]string, error) {
+	switch {
+	case col.opts.nullable:
+		if fv.IsNil() {
+			return []string{""}, nil
+		}
+		fv = fv.Elem()
+	case col.opts.split != "":
+		elems := make([]string, fv.Len())
+		for i := range elems {
+			s, err := col.enc.EncodeText(ctx, fv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elems[i] = s
+		}
+		return []string{strings.Join(elems, col.opts.split)}, nil
+	case col.opts.repeated:
+		if fv.Len() > col.repeatCount {
+			return nil, fmt.Errorf("field has %d elements, but only %d columns", fv.Len(), col.repeatCount)
+		}
+		cells := make([]string, col.repeatCount)
+		for i := 0; i < fv.Len(); i++ {
+			s, err := col.enc.EncodeText(ctx, fv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			cells[i] = s
+		}
+		return cells, nil
+	}
+	s, err := col.enc.EncodeText(ctx, fv.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
@@ -80,14 +80,30 @@ func (cg *codeGenerator) protoCode() string {
 
 	return fmt.Sprintf(`syntax = "proto3";
 
-package %s;
-
-%s
+%s%s%s
 
 message %s {
 %s
 }
-`, cg.mapping.PackageName, importStatements(imports), cg.mapping.MessageName, strings.Join(fieldCodeSections, "\n\n"))
+`, packageStatement(cg.mapping.GetPackageName()), goPackageOption(cg.mapping.GetGoOptions()), importStatements(imports), cg.mapping.MessageName, strings.Join(fieldCodeSections, "\n\n"))
+}
+
+// packageStatement returns a package statement followed by a blank line, or
+// the empty string if the mapping does not set a proto package.
+func packageStatement(pkg string) string {
+	if pkg == "" {
+		return ""
+	}
+	return fmt.Sprintf("package %s;\n\n", pkg)
+}
+
+// goPackageOption returns a go_package option statement followed by a blank
+// line, or the empty string if the Go import path of the proto is unknown.
+func goPackageOption(opts *pb.GoOptions) string {
+	if opts.GetProtoImport() == "" {
+		return ""
+	}
+	return fmt.Sprintf("option go_package = %q;\n\n", opts.GetProtoImport())
 }
 
 const protoWrapColumn = 80
@@ -29,6 +29,7 @@ var goFileTemplate = template.Must(template.New("classdef").Parse(
 	`package {{.package}}
 
 import (
+	"context"
 	"encoding/csv"
 	"io"
 	"reflect"
@@ -78,10 +79,12 @@ type Reader struct {
 }
 
 // NewReader returns a {{.message_type}} reader based on the given generic CSV reader.
+//
+// Pass csvtoprotoparse.FileName to set the file name reported in errors.
 func NewReader(r io.Reader, options... csvtoprotoparse.ReaderOption) (*Reader, error) {
 	reader := csv.NewReader(r)
 
-	fileParser, err := csvcoder.NewFileParser(reader, "input.csv", newRecord())
+	fileParser, err := csvcoder.NewFileParser(reader, csvtoprotoparse.ReaderFileName(options, "input.csv"), newRecord())
 	if err != nil {
 		return nil, err
 	}
@@ -93,24 +96,72 @@ func (r *Reader) Options() []csvtoprotoparse.ReaderOption {
 }
 
 // Read returns the next {{.message_type}} from the file.
+//
+// If the row fails to parse, the error is a *csvtoprotoparse.RowError that
+// holds every error found, including those of the reader parse row hooks. A
+// cell that fails to parse is described by a *csvtoprotoparse.CellError, which
+// may be retrieved with errors.As.
 func (r *Reader) Read() (*{{.message_type}}, error) {
+	rec, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	return rec.convert()
+}
+
+// ReadRecord parses the next row without converting it to a
+// {{.message_type}}. It implements protocp.RecordReader, so that a
+// protocp.Copier with more than one worker converts rows concurrently, in
+// which case the reader parse row hooks may be called concurrently.
+func (r *Reader) ReadRecord() (protocp.Record, error) {
+	rec, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *Reader) readRecord() (*parsedRow, error) {
 	goRec, err := r.fileParser.Read()
+	if err == io.EOF {
+		return nil, err
+	}
 	if err != nil {
+		if row := r.fileParser.LastRow(); row != nil {
+			return nil, &csvtoprotoparse.RowError{Row: row, Errors: []error{csvtoprotoparse.NewCellError(err, protoFields)}}
+		}
 		return nil, err
 	}
-	msg, err := goRec.(*{{.struct_name}}).Proto()
+	return &parsedRow{r, r.fileParser.LastRow(), goRec.(*{{.struct_name}})}, nil
+}
+
+// parsedRow is a row parsed by a Reader but not yet converted to a
+// {{.message_type}}.
+type parsedRow struct {
+	reader *Reader
+	row    *csvcoder.Row
+	goRec  *{{.struct_name}}
+}
+
+// Message converts the record. It implements protocp.Record.
+func (rec *parsedRow) Message() (proto.Message, error) {
+	return rec.convert()
+}
+
+func (rec *parsedRow) convert() (*{{.message_type}}, error) {
+	msg, err := rec.goRec.Proto()
 	errs := []error{}
 	if err != nil {
 		errs = []error{err}
 	}
 	for _, hook := range parseRowReaderHooks {
-		if err := hook(r, msg, errs); err != nil {
+		if err := hook(rec.reader, msg, errs); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
 	if len(errs) != 0 {
-		return msg, errs[0]
+		return msg, &csvtoprotoparse.RowError{Row: rec.row, Errors: errs}
 	}
 	return msg, nil
 }
@@ -139,11 +190,9 @@ func (r *Reader) ReadMessage() (proto.Message, error) {
 func NewMessageReader(r io.Reader, options... csvtoprotoparse.ReaderOption) (protocp.MessageReader, error) {
   return NewReader(r, options...)
 }
-`))
 
-func (cg *codeGenerator) recordStructTypeName() string {
-	return strcase.LowerCamelCase(cg.mapping.MessageName) + "Record"
-}
+{{.writer_section}}
+`))
 
 func (cg *codeGenerator) goCode() (string, error) {
 	strBuilder := &strings.Builder{}
@@ -159,7 +208,10 @@ func (cg *codeGenerator) goCode() (string, error) {
 
 	params["record_struct_definition"] = structCode.structDef
 	params["to_proto_impl"] = "return nil, fmt.Errorf(`problem`)"
-	params["struct_name"] = cg.recordStructTypeName()
+	params["writer_section"], err = cg.writerCode()
+	if err != nil {
+		return "", err
+	}
 	if err := goFileTemplate.Execute(strBuilder, params); err != nil {
 		return "", err
 	}
@@ -185,7 +237,7 @@ func (cg *codeGenerator) sharedTemplateParams() (map[string]string, error) {
 		"package":      cg.mapping.GoOptions.GoPackageName,
 		"proto_import": cg.mapping.GoOptions.ProtoImport,
 		"message_type": fmt.Sprintf("pb.%s", cg.mapping.MessageName),
-		"struct_name":  cg.recordStructTypeName(),
+		"struct_name":  cg.rowStructTypeName(),
 	}, nil
 }
 
@@ -194,30 +246,23 @@ type structCode struct {
 }
 
 func (cg *codeGenerator) makeStructCode() (*structCode, error) {
-	structName := cg.recordStructTypeName()
 	params, err := cg.sharedTemplateParams()
 	if err != nil {
 		return nil, err
 	}
+	rowCode, err := cg.rowStructCode()
+	if err != nil {
+		return nil, err
+	}
 
-	topLevelLines := []string{}
-	fieldLines := []string{""}
-	var toProtoInitStatements, protoFieldLiterals []string
-
-	for i, c2f := range cg.mapping.ColumnToFieldMappings {
+	var toProtoInitStatements, protoFieldLiterals, protoFieldNames []string
+	for _, c2f := range cg.mapping.ColumnToFieldMappings {
 		if c2f.Ignored {
 			continue
 		}
 
 		fieldName := strcase.UpperCamelCase(c2f.ProtoName)
-		fieldType, err := getFieldTypeCode(c2f)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate code for mapping[%d] = %v: %w", i, c2f, err)
-		}
-		if fieldType.topLevelCode != "" {
-			topLevelLines = append(topLevelLines, fieldType.topLevelCode)
-		}
-		fieldLines = append(fieldLines, fmt.Sprintf("%s %s `csv:%q`", fieldName, fieldType.typeName, c2f.GetColName()))
+		protoFieldNames = append(protoFieldNames, fmt.Sprintf("%q: %q,", fieldName, c2f.GetProtoName()))
 
 		expr, err := getGoToProtoFieldExpression(
 			fmt.Sprintf("r.%s", fieldName),
@@ -233,22 +278,21 @@ func (cg *codeGenerator) makeStructCode() (*structCode, error) {
 		protoFieldLiterals = append(protoFieldLiterals, fmt.Sprintf("%s: %s,", strcase.UpperCamelCase(c2f.ProtoName), expr.valueExpr))
 	}
 
-	structDef := fmt.Sprintf("type %s struct{%s\n}", structName, strings.Join(fieldLines, "\n  "))
-
 	params["parse_section"] = strings.Join(toProtoInitStatements, "\n")
-	params["field_type_declarations"] = strings.Join(topLevelLines, "\n")
 	params["field_literals_section"] = strings.Join(protoFieldLiterals, "\n")
+	params["proto_fields_section"] = strings.Join(protoFieldNames, "\n")
 
 	b := &strings.Builder{}
 	if err := toProtoTemplate.Execute(b, params); err != nil {
 		return nil, err
 	}
 
-	return &structCode{structDef + b.String()}, nil
+	return &structCode{rowCode + b.String()}, nil
 }
 
 var toProtoTemplate = template.Must(template.New("toProtoTemplate").Parse(
 	`
+// Proto returns the {{.message_type}} for the row.
 func (r *{{.struct_name}}) Proto() (*{{.message_type}}, error) {
 	var err error
 	{{.parse_section}}
@@ -257,12 +301,11 @@ func (r *{{.struct_name}}) Proto() (*{{.message_type}}, error) {
 	}, err
 }
 
-{{.field_type_declarations}}
-
-func init() {
-	csvcoder.RegisterRowStruct(reflect.TypeOf(newRecord()))
+// protoFields maps the fields of {{.struct_name}} to the names of the proto
+// fields they are converted to.
+var protoFields = map[string]string{
+	{{.proto_fields_section}}
 }
-
 `))
 
 type fieldTypeCode struct {
@@ -270,7 +313,9 @@ type fieldTypeCode struct {
 	topLevelCode, typeName string
 }
 
-func getFieldTypeCode(c2f *pb.ColumnToFieldMapping) (*fieldTypeCode, error) {
+// getFieldTypeCode returns the Go type of the row struct field for a column.
+// The types of time and duration fields are named with the given prefix.
+func getFieldTypeCode(c2f *pb.ColumnToFieldMapping, typePrefix string) (*fieldTypeCode, error) {
 	switch protoType := c2f.GetProtoType(); protoType {
 	case "int32":
 		return &fieldTypeCode{"", "int32"}, nil
@@ -283,13 +328,14 @@ func getFieldTypeCode(c2f *pb.ColumnToFieldMapping) (*fieldTypeCode, error) {
 	case "string":
 		return &fieldTypeCode{"", "string"}, nil
 	case "google.protobuf.Timestamp":
-		typeName := strcase.LowerCamelCase(c2f.GetProtoName() + "Time")
+		typeName := typePrefix + strcase.UpperCamelCase(c2f.GetProtoName()) + "Time"
 		tz := c2f.GetTimeFormat().GetTimeZoneName()
 		if tz == "" {
 			tz = "UTC"
 		}
 		code, err := templateExecString(timeTypeTemplate, map[string]string{
 			"T":           typeName,
+			"column":      c2f.GetColName(),
 			"time_layout": c2f.GetTimeFormat().GetGoLayout(),
 			"tz":          tz,
 		})
@@ -298,10 +344,11 @@ func getFieldTypeCode(c2f *pb.ColumnToFieldMapping) (*fieldTypeCode, error) {
 		}
 		return &fieldTypeCode{code, typeName}, nil
 	case "google.protobuf.Duration":
-		typeName := strcase.LowerCamelCase(c2f.GetProtoName() + "Duration")
+		typeName := typePrefix + strcase.UpperCamelCase(c2f.GetProtoName()) + "Duration"
 		code, err := templateExecString(durationTypeTemplate, map[string]string{
-			"T":    typeName,
-			"unit": c2f.GetTimeFormat().GetGoLayout(),
+			"T":      typeName,
+			"column": c2f.GetColName(),
+			"unit":   c2f.GetDurationFormat().GetGoUnitSuffix(),
 		})
 		if err != nil {
 			return nil, err
@@ -313,10 +360,12 @@ func getFieldTypeCode(c2f *pb.ColumnToFieldMapping) (*fieldTypeCode, error) {
 }
 
 var timeTypeTemplate = template.Must(template.New("timeType").Parse(`
+// {{.T}} is the time in the {{.column | printf "%q"}} column, which has the
+// layout {{.time_layout | printf "%q"}} in the {{.tz}} time zone.
 type {{.T}} time.Time
 
-// time returns the underlying time.Time of a {{.T}} object.
-func (t {{.T}}) time() time.Time {
+// Time returns the underlying time.Time of a {{.T}} object.
+func (t {{.T}}) Time() time.Time {
 	return time.Time(t)
 }
 
@@ -326,7 +375,7 @@ func init() {
 	textcoder.Register(
 		reflect.TypeOf({{.T}}{}),
 		func(t {{.T}}) (string, error) {
-			return t.time().In(location).Format(layout), nil
+			return t.Time().In(location).Format(layout), nil
 		},
 		func(s string, dst *{{.T}}) error {
 			t, err := time.ParseInLocation(layout, s, location)
@@ -342,21 +391,24 @@ func init() {
 `))
 
 var durationTypeTemplate = template.Must(template.New("durationType").Parse(`
+// {{.T}} is the duration in the {{.column | printf "%q"}} column, which is a
+// number of {{.unit | printf "%q"}} units.
 type {{.T}} time.Duration
 
-// duration returns the underlying time.Duration of a {{.T}} object.
-func (d {{.T}}) duration() time.Duration {
+// Duration returns the underlying time.Duration of a {{.T}} object.
+func (d {{.T}}) Duration() time.Duration {
 	return time.Duration(d)
 }
 
 func init() {
+	const unit = {{.unit | printf "%q"}}
 	textcoder.Register(
-		reflect.TypeOf({{.T}}{}),
+		reflect.TypeOf({{.T}}(0)),
 		func(d {{.T}}) (string, error) {
-			return d.Duration().String(), nil
+			return csvtoprotoparse.FormatDurationValue(d.Duration(), unit)
 		},
 		func(s string, dst *{{.T}}) error {
-			d, err := csvtoprotoparse.ParseDuration(s, unit)
+			d, err := time.ParseDuration(s + unit)
 			if err != nil {
 				return fmt.Errorf("error parsing {{.T}}: %w", err)
 			}
@@ -385,7 +437,7 @@ func getGoToProtoFieldExpression(inExpr, outVar, protoType string) (*transformEx
 	case "google.protobuf.Timestamp":
 		return &transformExpr{
 			fmt.Sprintf(`
-%s, err := csvtoprotoparse.TimeToTimestamp(%s.time())
+%s, err := csvtoprotoparse.TimeToTimestamp(%s.Time())
 if err != nil {
 	return nil, err
 }
@@ -395,7 +447,7 @@ if err != nil {
 	case "google.protobuf.Duration":
 		return &transformExpr{
 			fmt.Sprintf(`
-%s, err := csvtoprotoparse.DurationToDurationProto(%s.duration())
+%s, err := csvtoprotoparse.DurationToDurationProto(%s.Duration())
 if err != nil {
 	return nil, err
 }
@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+
+	"github.com/stoewer/go-strcase"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+// GenerateRowStructCode returns a .go file that defines a Go struct for the
+// rows of the CSV files described by the mapping. The struct is registered
+// with csvcoder, so it may be used with csvcoder.NewFileParser and
+// csvcoder.NewFileWriter without generating or compiling any protocol buffers.
+// The converter generated by GenerateCode defines the same struct.
+//
+// The package of the file is packageName, or the go_package_name of the
+// mapping's go_options if packageName is empty.
+func GenerateRowStructCode(mapping *pb.RecordProtoMapping, packageName string) (string, error) {
+	if packageName == "" {
+		packageName = mapping.GetGoOptions().GetGoPackageName()
+	}
+	if packageName == "" {
+		return "", fmt.Errorf("a package name is required to generate a row struct, and go_options.go_package_name of the mapping is empty")
+	}
+	cg := &codeGenerator{mapping}
+	rowCode, err := cg.rowStructCode()
+	if err != nil {
+		return "", err
+	}
+	code, err := templateExecString(rowStructFileTemplate, map[string]string{
+		"package":     packageName,
+		"row_section": rowCode,
+	})
+	if err != nil {
+		return "", err
+	}
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+var rowStructFileTemplate = template.Must(template.New("rowStructFile").Parse(
+	`package {{.package}}
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/xtoproto/csvcoder"
+	"github.com/google/xtoproto/csvtoprotoparse"
+	"github.com/google/xtoproto/textcoder"
+)
+
+// Unused vars to ensure the imports are used.
+var (
+	_ = fmt.Sprintf
+	_ = time.Now
+	_ = csvtoprotoparse.MustLoadLocation
+	_ = textcoder.NewRegistry
+)
+
+{{.row_section}}
+`))
+
+// rowStructTypeName returns the name of the generated row struct.
+func (cg *codeGenerator) rowStructTypeName() string {
+	return strcase.UpperCamelCase(cg.mapping.GetMessageName()) + "Row"
+}
+
+// rowStructCode returns the definition of the row struct, the types of its
+// time and duration fields, and the init function that registers it.
+func (cg *codeGenerator) rowStructCode() (string, error) {
+	typePrefix := strcase.UpperCamelCase(cg.mapping.GetMessageName())
+	var fieldLines, typeDecls []string
+	for i, c2f := range cg.mapping.GetColumnToFieldMappings() {
+		if c2f.GetIgnored() {
+			continue
+		}
+		fieldType, err := getFieldTypeCode(c2f, typePrefix)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate code for mapping[%d] = %v: %w", i, c2f, err)
+		}
+		if fieldType.topLevelCode != "" {
+			typeDecls = append(typeDecls, fieldType.topLevelCode)
+		}
+		fieldLines = append(fieldLines, fmt.Sprintf("%s %s `csv:%q`", strcase.UpperCamelCase(c2f.GetProtoName()), fieldType.typeName, c2f.GetColName()))
+	}
+	return templateExecString(rowStructTemplate, map[string]string{
+		"row":         cg.rowStructTypeName(),
+		"message":     cg.mapping.GetMessageName(),
+		"fields":      strings.Join(fieldLines, "\n"),
+		"field_types": strings.Join(typeDecls, "\n"),
+	})
+}
+
+var rowStructTemplate = template.Must(template.New("rowStruct").Parse(`
+// {{.row}} is a row of a CSV file of {{.message}} records. It is registered
+// with csvcoder, so such files may be read with csvcoder.NewFileParser and
+// written with csvcoder.NewFileWriter.
+type {{.row}} struct {
+	{{.fields}}
+}
+
+{{.field_types}}
+
+func init() {
+	csvcoder.RegisterRowStruct(reflect.TypeOf(&{{.row}}{}))
+}
+`))
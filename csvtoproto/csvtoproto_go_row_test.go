@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+func TestGenerateRowStructCode(t *testing.T) {
+	mapping := &pb.RecordProtoMapping{
+		MessageName: "Trip",
+		PackageName: "trips",
+		ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+			{ColName: "name", ProtoType: "string", ProtoName: "name", ProtoTag: 1},
+			{ColName: "notes", Ignored: true},
+			{
+				ColName:     "start time",
+				ProtoType:   "google.protobuf.Timestamp",
+				ProtoName:   "start_time",
+				ProtoTag:    2,
+				ParsingInfo: &pb.ColumnToFieldMapping_TimeFormat{TimeFormat: &pb.TimeFormat{GoLayout: "2006-01-02"}},
+			},
+			{
+				ColName:     "elapsed",
+				ProtoType:   "google.protobuf.Duration",
+				ProtoName:   "elapsed",
+				ProtoTag:    3,
+				ParsingInfo: &pb.ColumnToFieldMapping_DurationFormat{DurationFormat: &pb.DurationFormat{GoUnitSuffix: "ms"}},
+			},
+		},
+	}
+	if _, err := GenerateRowStructCode(mapping, ""); err == nil {
+		t.Errorf("GenerateRowStructCode() got nil error without a package name")
+	}
+	code, err := GenerateRowStructCode(mapping, "trips")
+	if err != nil {
+		t.Fatalf("GenerateRowStructCode() failed: %v", err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "trip_row.go", code, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, code)
+	}
+	if got := f.Name.Name; got != "trips" {
+		t.Errorf("generated package is %q, want %q", got, "trips")
+	}
+	for _, imp := range f.Imports {
+		if strings.Contains(imp.Path.Value, "protobuf") || strings.Contains(imp.Path.Value, "protocp") {
+			t.Errorf("generated row struct code imports %s", imp.Path.Value)
+		}
+	}
+	for _, want := range []string{
+		"type TripRow struct {\n\tName      string              `csv:\"name\"`\n\tStartTime TripStartTimeTime   `csv:\"start time\"`\n\tElapsed   TripElapsedDuration `csv:\"elapsed\"`\n}",
+		"func (t TripStartTimeTime) Time() time.Time {",
+		"func (d TripElapsedDuration) Duration() time.Duration {",
+		`location := csvtoprotoparse.MustLoadLocation("UTC")`,
+		`const unit = "ms"`,
+		"csvcoder.RegisterRowStruct(reflect.TypeOf(&TripRow{}))",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("GenerateRowStructCode() returned code without %q:\n%s", want, code)
+		}
+	}
+
+	// The converter is built on the same row struct.
+	mapping.GoOptions = &pb.GoOptions{GoPackageName: "trip_converter", ProtoImport: "example.com/trippb"}
+	_, goCode, err := GenerateCode(mapping, false, true)
+	if err != nil {
+		t.Fatalf("GenerateCode() failed: %v", err)
+	}
+	for _, want := range []string{
+		"type TripRow struct {",
+		"func (r *TripRow) Proto() (*pb.Trip, error) {",
+		"csvtoprotoparse.TimeToTimestamp(r.StartTime.Time())",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Errorf("GenerateCode() returned code without %q:\n%s", want, goCode)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/stoewer/go-strcase"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+var goWriterTemplate = template.Must(template.New("writer").Parse(`
+// Header is the header row written by Writer. It names the mapped columns in
+// the order of the mapping.
+var Header = []string{
+	{{.header_literals}}
+}
+
+// FormatRow returns the cells of the CSV row for a {{.message_type}} in the
+// order of Header. Reading the row with a Reader returns the same message,
+// except for fields whose format loses precision.
+func FormatRow(msg *{{.message_type}}) ([]string, error) {
+	var err error
+	row := make([]string, len(Header))
+	{{.format_section}}
+	return row, err
+}
+
+// Writer writes {{.message_type}} messages as CSV rows. It implements
+// protocp.MessageWriter.
+type Writer struct {
+	csvWriter   *csv.Writer
+	wroteHeader bool
+}
+
+// NewWriter returns a Writer that writes CSV to w. The header row is written
+// before the first message.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{csvWriter: csv.NewWriter(w)}
+}
+
+// Write writes a single {{.message_type}}. Rows are buffered; call Flush to
+// write them to the underlying writer.
+func (w *Writer) Write(msg *{{.message_type}}) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	row, err := FormatRow(msg)
+	if err != nil {
+		return err
+	}
+	return w.csvWriter.Write(row)
+}
+
+// WriteAll writes the messages and flushes the writer.
+func (w *Writer) WriteAll(msgs []*{{.message_type}}) error {
+	for _, msg := range msgs {
+		if err := w.Write(msg); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Flush writes any buffered rows, including the header row if nothing has been
+// written yet, to the underlying writer.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csvWriter.Flush()
+	return w.csvWriter.Error()
+}
+
+func (w *Writer) writeHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	return w.csvWriter.Write(Header)
+}
+
+// AddRow writes a message, which must be a *{{.message_type}}.
+func (w *Writer) AddRow(ctx context.Context, msg proto.Message) error {
+	m, ok := msg.(*{{.message_type}})
+	if !ok {
+		return fmt.Errorf("got message of type %T, want *{{.message_type}}", msg)
+	}
+	return w.Write(m)
+}
+
+// Finalize flushes the writer.
+func (w *Writer) Finalize(ctx context.Context) error {
+	return w.Flush()
+}
+
+// NewMessageWriter returns a protocp.MessageWriter that writes CSV to w.
+func NewMessageWriter(w io.Writer) protocp.MessageWriter {
+	return NewWriter(w)
+}
+`))
+
+// writerCode returns the code of the generated CSV writer.
+func (cg *codeGenerator) writerCode() (string, error) {
+	params, err := cg.sharedTemplateParams()
+	if err != nil {
+		return "", err
+	}
+	var headerLiterals, formatStatements []string
+	for i, c2f := range cg.mapping.GetColumnToFieldMappings() {
+		if c2f.GetIgnored() {
+			continue
+		}
+		stmt, err := formatCellStatement(len(headerLiterals), c2f)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate code for mapping[%d] = %v: %w", i, c2f, err)
+		}
+		headerLiterals = append(headerLiterals, fmt.Sprintf("%q,", c2f.GetColName()))
+		formatStatements = append(formatStatements, stmt)
+	}
+	params["header_literals"] = strings.Join(headerLiterals, "\n")
+	params["format_section"] = strings.Join(formatStatements, "\n")
+	return templateExecString(goWriterTemplate, params)
+}
+
+// formatCellStatement returns a statement that sets row[index] to the
+// formatted value of the field described by c2f.
+func formatCellStatement(index int, c2f *pb.ColumnToFieldMapping) (string, error) {
+	getter := fmt.Sprintf("msg.Get%s()", strcase.UpperCamelCase(c2f.GetProtoName()))
+	var call string
+	switch protoType := c2f.GetProtoType(); protoType {
+	case "int32":
+		return fmt.Sprintf("row[%d] = csvtoprotoparse.FormatInt32(%s)", index, getter), nil
+	case "int64":
+		return fmt.Sprintf("row[%d] = csvtoprotoparse.FormatInt64(%s)", index, getter), nil
+	case "float":
+		return fmt.Sprintf("row[%d] = csvtoprotoparse.FormatFloat(%s)", index, getter), nil
+	case "double":
+		return fmt.Sprintf("row[%d] = csvtoprotoparse.FormatDouble(%s)", index, getter), nil
+	case "string":
+		return fmt.Sprintf("row[%d] = %s", index, getter), nil
+	case "google.protobuf.Timestamp":
+		tz := c2f.GetTimeFormat().GetTimeZoneName()
+		if tz == "" {
+			tz = "UTC"
+		}
+		call = fmt.Sprintf("csvtoprotoparse.FormatTimestamp(%s, %q, %q)", getter, c2f.GetTimeFormat().GetGoLayout(), tz)
+	case "google.protobuf.Duration":
+		call = fmt.Sprintf("csvtoprotoparse.FormatDuration(%s, %q)", getter, c2f.GetDurationFormat().GetGoUnitSuffix())
+	default:
+		return "", fmt.Errorf("unexpected type: %q", protoType)
+	}
+	return fmt.Sprintf(`if row[%d], err = %s; err != nil {
+	return nil, fmt.Errorf("error formatting column %%q: %%w", %q, err)
+}`, index, call, c2f.GetColName()), nil
+}
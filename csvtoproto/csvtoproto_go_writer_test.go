@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+func TestGenerateCode_writer(t *testing.T) {
+	mapping := &pb.RecordProtoMapping{
+		GoOptions:   &pb.GoOptions{GoPackageName: "trip_converter", ProtoImport: "example.com/trippb"},
+		MessageName: "Trip",
+		PackageName: "trips",
+		ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+			{ColName: "name", ProtoType: "string", ProtoName: "name", ProtoTag: 1},
+			{ColName: "notes", Ignored: true},
+			{ColName: "count", ProtoType: "int32", ProtoName: "count", ProtoTag: 2},
+			{
+				ColName:     "start time",
+				ProtoType:   "google.protobuf.Timestamp",
+				ProtoName:   "start_time",
+				ProtoTag:    3,
+				ParsingInfo: &pb.ColumnToFieldMapping_TimeFormat{TimeFormat: &pb.TimeFormat{GoLayout: "2006-01-02", TimeZoneName: "America/New_York"}},
+			},
+			{
+				ColName:     "elapsed",
+				ProtoType:   "google.protobuf.Duration",
+				ProtoName:   "elapsed",
+				ProtoTag:    4,
+				ParsingInfo: &pb.ColumnToFieldMapping_DurationFormat{DurationFormat: &pb.DurationFormat{GoUnitSuffix: "ms"}},
+			},
+		},
+	}
+	_, goCode, err := GenerateCode(mapping, false, true)
+	if err != nil {
+		t.Fatalf("GenerateCode() failed: %v", err)
+	}
+	for _, want := range []string{
+		"var Header = []string{\n\t\"name\",\n\t\"count\",\n\t\"start time\",\n\t\"elapsed\",\n}",
+		"row[0] = msg.GetName()",
+		"row[1] = csvtoprotoparse.FormatInt32(msg.GetCount())",
+		`row[2], err = csvtoprotoparse.FormatTimestamp(msg.GetStartTime(), "2006-01-02", "America/New_York")`,
+		`row[3], err = csvtoprotoparse.FormatDuration(msg.GetElapsed(), "ms")`,
+		"func (w *Writer) AddRow(ctx context.Context, msg proto.Message) error {",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Errorf("GenerateCode() returned code without %q:\n%s", want, goCode)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+const (
+	maxProtoTag           = 1<<29 - 1
+	firstReservedProtoTag = 19000
+	lastReservedProtoTag  = 19999
+)
+
+var (
+	protoIdentifier  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	protoPackageName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+)
+
+// supportedProtoTypes are the field types that GenerateCode can produce
+// converter code for.
+var supportedProtoTypes = map[string]bool{
+	"int32":                     true,
+	"int64":                     true,
+	"float":                     true,
+	"double":                    true,
+	"string":                    true,
+	"google.protobuf.Timestamp": true,
+	"google.protobuf.Duration":  true,
+}
+
+// wellKnownTypeImports are the files that must be listed in proto_imports for
+// fields of well-known types.
+var wellKnownTypeImports = map[string]string{
+	"google.protobuf.Timestamp": "google/protobuf/timestamp.proto",
+	"google.protobuf.Duration":  "google/protobuf/duration.proto",
+}
+
+// Validate returns an error describing every problem found in the mapping that
+// would prevent GenerateCode from producing a valid .proto file and converter.
+//
+// The go_options.go_package_name of the mapping is not checked because it is
+// commonly left empty in mappings produced by Infer and filled in when code is
+// generated; see the gomodgen package.
+func Validate(mapping *pb.RecordProtoMapping) error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if !protoIdentifier.MatchString(mapping.GetMessageName()) {
+		addProblem("message_name %q is not a valid proto identifier", mapping.GetMessageName())
+	}
+	if pkg := mapping.GetPackageName(); pkg != "" && !protoPackageName.MatchString(pkg) {
+		addProblem("package_name %q is not a valid proto package name", pkg)
+	}
+
+	names := make(map[string]string)
+	tags := make(map[int32]string)
+	checkField := func(desc, name string, tag int32) {
+		if !protoIdentifier.MatchString(name) {
+			addProblem("%s: proto_name %q is not a valid proto identifier", desc, name)
+		} else if other, ok := names[name]; ok {
+			addProblem("%s: proto_name %q is also used by %s", desc, name, other)
+		} else {
+			names[name] = desc
+		}
+		switch {
+		case tag < 1 || tag > maxProtoTag:
+			addProblem("%s: proto_tag %d is out of range [1, %d]", desc, tag, maxProtoTag)
+		case tag >= firstReservedProtoTag && tag <= lastReservedProtoTag:
+			addProblem("%s: proto_tag %d is reserved for the protocol buffer implementation", desc, tag)
+		default:
+			if other, ok := tags[tag]; ok {
+				addProblem("%s: proto_tag %d is also used by %s", desc, tag, other)
+			} else {
+				tags[tag] = desc
+			}
+		}
+	}
+
+	checkImports := func(desc, protoType string, imports []string) {
+		want, ok := wellKnownTypeImports[protoType]
+		if !ok {
+			return
+		}
+		for _, imp := range imports {
+			if imp == want {
+				return
+			}
+		}
+		addProblem("%s: proto_imports must include %q for fields of type %s", desc, want, protoType)
+	}
+
+	for i, c2f := range mapping.GetColumnToFieldMappings() {
+		if c2f.GetIgnored() {
+			continue
+		}
+		desc := fmt.Sprintf("column_to_field_mappings[%d] (column %q)", i, c2f.GetColName())
+		checkField(desc, c2f.GetProtoName(), c2f.GetProtoTag())
+		if c2f.GetColName() == "" {
+			addProblem("%s: col_name must be specified", desc)
+		}
+		if !supportedProtoTypes[c2f.GetProtoType()] {
+			addProblem("%s: unsupported proto_type %q", desc, c2f.GetProtoType())
+		}
+		if c2f.GetProtoType() == "google.protobuf.Timestamp" && c2f.GetTimeFormat().GetGoLayout() == "" {
+			addProblem("%s: time_format.go_layout must be specified for timestamp fields", desc)
+		}
+		checkImports(desc, c2f.GetProtoType(), c2f.GetProtoImports())
+	}
+	for i, fd := range mapping.GetExtraFieldDefinitions() {
+		desc := fmt.Sprintf("extra_field_definitions[%d]", i)
+		checkField(desc, fd.GetProtoName(), fd.GetProtoTag())
+		if fd.GetProtoType() == "" {
+			addProblem("%s: proto_type must be specified", desc)
+		}
+		checkImports(desc, fd.GetProtoType(), fd.GetProtoImports())
+	}
+	if name := mapping.GetSourceFileField(); name != "" {
+		if fd := findExtraField(mapping, name); fd == nil {
+			addProblem("source_file_field %q does not name a field of extra_field_definitions", name)
+		} else if fd.GetProtoType() != "string" {
+			addProblem("source_file_field %q has proto_type %q, want \"string\"", name, fd.GetProtoType())
+		}
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("invalid mapping; %d problems found:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// findExtraField returns the extra field definition with the given proto name,
+// or nil if there is none.
+func findExtraField(mapping *pb.RecordProtoMapping, name string) *pb.FieldDefinition {
+	for _, fd := range mapping.GetExtraFieldDefinitions() {
+		if fd.GetProtoName() == name {
+			return fd
+		}
+	}
+	return nil
+}
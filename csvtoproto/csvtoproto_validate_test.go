@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoproto
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+func TestValidate(t *testing.T) {
+	validMapping := func() *pb.RecordProtoMapping {
+		return &pb.RecordProtoMapping{
+			GoOptions:   &pb.GoOptions{GoPackageName: "my_message_converter"},
+			MessageName: "MyMessage",
+			PackageName: "my.package",
+			ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+				{ColName: "a", ProtoType: "int64", ProtoName: "a", ProtoTag: 1},
+				{ColName: "b c", ProtoType: "google.protobuf.Timestamp", ProtoName: "b_c", ProtoTag: 2, ProtoImports: []string{"google/protobuf/timestamp.proto"}, ParsingInfo: &pb.ColumnToFieldMapping_TimeFormat{TimeFormat: &pb.TimeFormat{GoLayout: "2006-01-02"}}},
+				{ColName: "ignored", Ignored: true},
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		edit func(m *pb.RecordProtoMapping)
+		// wantErrs are substrings of the expected error; nil means no error.
+		wantErrs []string
+	}{
+		{
+			name: "valid",
+			edit: func(m *pb.RecordProtoMapping) {},
+		},
+		{
+			name: "bad message and package names",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.MessageName = "My Message"
+				m.PackageName = "my..package"
+			},
+			wantErrs: []string{"2 problems found", "message_name", "package_name"},
+		},
+		{
+			name: "missing go package name is allowed",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.GoOptions = nil
+			},
+		},
+		{
+			name: "duplicate names and tags",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ColumnToFieldMappings[1].ProtoName = "a"
+				m.ColumnToFieldMappings[1].ProtoTag = 1
+			},
+			wantErrs: []string{
+				`proto_name "a" is also used by column_to_field_mappings[0]`,
+				"proto_tag 1 is also used by column_to_field_mappings[0]",
+			},
+		},
+		{
+			name: "reserved tag",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ColumnToFieldMappings[0].ProtoTag = 19001
+			},
+			wantErrs: []string{"proto_tag 19001 is reserved"},
+		},
+		{
+			name: "unsupported type and missing layout",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ColumnToFieldMappings[0].ProtoType = "bytes"
+				m.ColumnToFieldMappings[1].ParsingInfo = nil
+			},
+			wantErrs: []string{`unsupported proto_type "bytes"`, "time_format.go_layout"},
+		},
+		{
+			name: "missing well-known type import",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ColumnToFieldMappings[1].ProtoImports = nil
+			},
+			wantErrs: []string{`proto_imports must include "google/protobuf/timestamp.proto"`},
+		},
+		{
+			name: "source file field",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ExtraFieldDefinitions = []*pb.FieldDefinition{{ProtoName: "source_file", ProtoType: "string", ProtoTag: 3}}
+				m.SourceFileField = "source_file"
+			},
+		},
+		{
+			name: "source file field must be a string extra field",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ExtraFieldDefinitions = []*pb.FieldDefinition{{ProtoName: "source_file", ProtoType: "int64", ProtoTag: 3}}
+				m.SourceFileField = "source_file"
+			},
+			wantErrs: []string{`source_file_field "source_file" has proto_type "int64"`},
+		},
+		{
+			name: "unknown source file field",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.SourceFileField = "a"
+			},
+			wantErrs: []string{`source_file_field "a" does not name a field of extra_field_definitions`},
+		},
+		{
+			name: "ignored columns are not validated",
+			edit: func(m *pb.RecordProtoMapping) {
+				m.ColumnToFieldMappings[2].ProtoTag = 1
+				m.ColumnToFieldMappings[2].ProtoType = "bytes"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validMapping()
+			tt.edit(m)
+			err := Validate(m)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() got unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() got nil error, want error containing %q", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() got error %q, want error containing %q", err, want)
+				}
+			}
+		})
+	}
+}
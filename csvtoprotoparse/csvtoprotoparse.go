@@ -20,11 +20,15 @@
 package csvtoprotoparse
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
+	"github.com/google/xtoproto/csvcoder"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 	ts "google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -95,9 +99,130 @@ func DurationToDurationProto(d time.Duration) (*dpb.Duration, error) {
 	return ptypes.DurationProto(d), nil
 }
 
+// FormatFloat returns the CSV field for a float. ParseFloat returns the same
+// value for the field.
+func FormatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 32)
+}
+
+// FormatDouble returns the CSV field for a double. ParseDouble returns the
+// same value for the field.
+func FormatDouble(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// FormatInt32 returns the CSV field for an int32.
+func FormatInt32(v int32) string {
+	return strconv.FormatInt(int64(v), 10)
+}
+
+// FormatInt64 returns the CSV field for an int64.
+func FormatInt64(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+// FormatTimestamp formats a timestamp proto in the given time zone using a
+// layout. It is the inverse of ParseTimestamp for values that the layout
+// represents exactly. A nil timestamp is formatted as the empty string.
+func FormatTimestamp(t *ts.Timestamp, layout, timezone string) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return "", err
+	}
+	gt, err := ptypes.Timestamp(t)
+	if err != nil {
+		return "", err
+	}
+	return gt.In(loc).Format(layout), nil
+}
+
+// FormatDuration formats a duration proto as a number of units; see
+// FormatDurationValue. A nil duration is formatted as the empty string.
+func FormatDuration(d *dpb.Duration, unit string) (string, error) {
+	if d == nil {
+		return "", nil
+	}
+	gd, err := ptypes.Duration(d)
+	if err != nil {
+		return "", err
+	}
+	return FormatDurationValue(gd, unit)
+}
+
+// FormatDurationValue formats a duration as a decimal number of units, such
+// as "1.5" for 1500ms with unit "s", so that ParseDuration(s, unit) returns
+// the same duration. If unit is empty, the duration is formatted like
+// time.Duration.String.
+//
+// The result is exact for the units "ns", "us", "µs", "ms" and "s". Durations
+// in minutes or hours that are not a whole number of units are rounded to the
+// precision of a float64.
+func FormatDurationValue(d time.Duration, unit string) (string, error) {
+	if unit == "" {
+		return d.String(), nil
+	}
+	u, err := time.ParseDuration("1" + unit)
+	if err != nil {
+		return "", fmt.Errorf("invalid duration unit %q: %w", unit, err)
+	}
+	digits := 0
+	for p := u; p > 1 && p%10 == 0; p /= 10 {
+		digits++
+	}
+	if u != pow10(digits) {
+		return strconv.FormatFloat(float64(d)/float64(u), 'f', -1, 64), nil
+	}
+	// Format the duration exactly with integer arithmetic.
+	sign := ""
+	whole, frac := d/u, d%u
+	if d < 0 {
+		sign = "-"
+		whole, frac = -whole, -frac
+	}
+	s := sign + strconv.FormatInt(int64(whole), 10)
+	if frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", digits, int64(frac)), "0")
+	}
+	return s, nil
+}
+
+// pow10 returns 10^n nanoseconds.
+func pow10(n int) time.Duration {
+	d := time.Duration(1)
+	for i := 0; i < n; i++ {
+		d *= 10
+	}
+	return d
+}
+
 // ReaderOption is used to specify a custom argument to csvtoproto readers at construction time.
 type ReaderOption interface{}
 
+// fileNameOption is the ReaderOption returned by FileName.
+type fileNameOption string
+
+// FileName returns a ReaderOption that sets the name of the file being read,
+// which is used in error messages and errors. Generated readers use
+// "input.csv" by default.
+func FileName(name string) ReaderOption {
+	return fileNameOption(name)
+}
+
+// ReaderFileName returns the file name set by the last FileName option in
+// opts, or defaultName if there is none.
+func ReaderFileName(opts []ReaderOption, defaultName string) string {
+	name := defaultName
+	for _, opt := range opts {
+		if n, ok := opt.(fileNameOption); ok {
+			name = string(n)
+		}
+	}
+	return name
+}
+
 // MustLoadLocation returns a time.Location or panics.
 func MustLoadLocation(name string) *time.Location {
 	tz, err := time.LoadLocation(name)
@@ -106,3 +231,98 @@ func MustLoadLocation(name string) *time.Location {
 	}
 	return tz
 }
+
+// RowError is returned by generated readers for a row that failed to parse. It
+// holds every error reported for the row, including those returned by reader
+// parse row hooks, and implements protocp.RecordError.
+type RowError struct {
+	// Row is the row that failed to parse.
+	Row *csvcoder.Row
+	// Errors are the problems found with the row. There is at least one.
+	Errors []error
+}
+
+func (e *RowError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the first error.
+func (e *RowError) Unwrap() error {
+	return e.Errors[0]
+}
+
+// RecordPosition returns the position of the row, such as "input.csv:3".
+func (e *RowError) RecordPosition() string {
+	return e.Row.PositionString()
+}
+
+// RawRecord returns the row formatted as a CSV line without a line ending, or
+// the empty string if the row could not be read.
+func (e *RowError) RawRecord() string {
+	if e.Row.Strings() == nil {
+		return ""
+	}
+	b := &strings.Builder{}
+	w := csv.NewWriter(b)
+	w.Write(e.Row.Strings())
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// RecordErrors returns the errors of the row.
+func (e *RowError) RecordErrors() []error {
+	return e.Errors
+}
+
+// CellErrors returns the errors of the row that describe a single cell.
+func (e *RowError) CellErrors() []*CellError {
+	var cellErrs []*CellError
+	for _, err := range e.Errors {
+		var cellErr *CellError
+		if errors.As(err, &cellErr) {
+			cellErrs = append(cellErrs, cellErr)
+		}
+	}
+	return cellErrs
+}
+
+// CellError is returned by generated readers, as one of the errors of a
+// RowError, for a cell that could not be parsed. It adds the proto field of
+// the cell to a csvcoder.CellError, whose fields describe the position and
+// value of the cell.
+//
+// errors.As may be used to retrieve either a *CellError or the underlying
+// *csvcoder.CellError.
+type CellError struct {
+	*csvcoder.CellError
+	// ProtoField is the name of the proto field the cell is converted to,
+	// such as "start_time".
+	ProtoField string
+
+	// err is the error that wraps CellError.
+	err error
+}
+
+// NewCellError returns err as a *CellError if it wraps a *csvcoder.CellError,
+// or err itself otherwise. protoFields maps the names of the fields of the
+// row struct to the names of the proto fields they are converted to.
+func NewCellError(err error, protoFields map[string]string) error {
+	var cellErr *csvcoder.CellError
+	if !errors.As(err, &cellErr) {
+		return err
+	}
+	return &CellError{cellErr, protoFields[cellErr.Field], err}
+}
+
+func (e *CellError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error that wraps the csvcoder.CellError.
+func (e *CellError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csvtoprotoparse
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/xtoproto/csvcoder"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestFormatDurationValue(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		unit string
+		want string
+	}{
+		{90 * time.Second, "", "1m30s"},
+		{90 * time.Second, "s", "90"},
+		{1500 * time.Millisecond, "s", "1.5"},
+		{-1500 * time.Millisecond, "s", "-1.5"},
+		{-500 * time.Millisecond, "s", "-0.5"},
+		{time.Nanosecond, "s", "0.000000001"},
+		{1234567 * time.Nanosecond, "ms", "1.234567"},
+		{3 * time.Microsecond, "us", "3"},
+		{90 * time.Minute, "h", "1.5"},
+		{0, "ms", "0"},
+	} {
+		got, err := FormatDurationValue(tc.d, tc.unit)
+		if err != nil {
+			t.Errorf("FormatDurationValue(%v, %q) failed: %v", tc.d, tc.unit, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("FormatDurationValue(%v, %q) = %q, want %q", tc.d, tc.unit, got, tc.want)
+		}
+		parsed, err := ParseDuration(got, tc.unit)
+		if err != nil {
+			t.Errorf("ParseDuration(%q, %q) failed: %v", got, tc.unit, err)
+			continue
+		}
+		if want := ptypes.DurationProto(tc.d); !cmp.Equal(want, parsed, protocmp.Transform()) {
+			t.Errorf("ParseDuration(%q, %q) = %v, want %v", got, tc.unit, parsed, want)
+		}
+	}
+	if _, err := FormatDurationValue(time.Second, "parsecs"); err == nil {
+		t.Errorf("FormatDurationValue() got nil error for an invalid unit")
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	const layout = "2006-01-02 15:04:05.999"
+	want, err := ParseTimestamp("2020-05-01 09:30:00.25", layout, "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := FormatTimestamp(want, layout, "America/New_York")
+	if err != nil {
+		t.Fatalf("FormatTimestamp() failed: %v", err)
+	}
+	if s != "2020-05-01 09:30:00.25" {
+		t.Errorf("FormatTimestamp() = %q, want %q", s, "2020-05-01 09:30:00.25")
+	}
+	got, err := ParseTimestamp(s, layout, "America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got, protocmp.Transform()) {
+		t.Errorf("ParseTimestamp(FormatTimestamp(%v)) = %v", want, got)
+	}
+	if s, err := FormatTimestamp(nil, layout, "UTC"); s != "" || err != nil {
+		t.Errorf("FormatTimestamp(nil) = %q, %v; want empty string", s, err)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for _, v := range []float32{0, 1.1, -3.25e-20, math.MaxFloat32, float32(math.Inf(1))} {
+		got, err := ParseFloat(FormatFloat(v))
+		if err != nil || got != v {
+			t.Errorf("ParseFloat(FormatFloat(%v)) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []float64{0, 0.1, -1e300, math.SmallestNonzeroFloat64} {
+		got, err := ParseDouble(FormatDouble(v))
+		if err != nil || got != v {
+			t.Errorf("ParseDouble(FormatDouble(%v)) = %v, %v", v, got, err)
+		}
+	}
+}
+
+type testRecord struct {
+	Name  string `csv:"name"`
+	Count int32  `csv:"count"`
+}
+
+func init() {
+	csvcoder.RegisterRowStruct(reflect.TypeOf(&testRecord{}))
+}
+
+func TestNewCellError(t *testing.T) {
+	fp, err := csvcoder.NewFileParser(csv.NewReader(strings.NewReader("name,count\nx,many\n")), ReaderFileName([]ReaderOption{FileName("counts.csv")}, "input.csv"), &testRecord{})
+	if err != nil {
+		t.Fatalf("NewFileParser() failed: %v", err)
+	}
+	_, err = fp.Read()
+	if err == nil {
+		t.Fatalf("Read() got nil error for an invalid count")
+	}
+	rowErr := &RowError{Row: fp.LastRow(), Errors: []error{
+		NewCellError(err, map[string]string{"Count": "item_count"}),
+		fmt.Errorf("hook error"),
+	}}
+
+	var cellErr *CellError
+	if !errors.As(rowErr, &cellErr) {
+		t.Fatalf("errors.As(%v, *CellError) = false, want true", rowErr)
+	}
+	if got, want := cellErr.Error(), err.Error(); got != want {
+		t.Errorf("CellError.Error() = %q, want %q", got, want)
+	}
+	type cellInfo struct {
+		Position, ColumnName, Value, ProtoField string
+	}
+	got := cellInfo{cellErr.PositionString(), cellErr.ColumnName, cellErr.Value, cellErr.ProtoField}
+	want := cellInfo{"counts.csv:2:2", "count", "many", "item_count"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected diff in CellError (-want, +got):\n%s", diff)
+	}
+	var coderErr *csvcoder.CellError
+	if !errors.As(rowErr, &coderErr) || !errors.Is(rowErr, strconv.ErrSyntax) {
+		t.Errorf("RowError %v does not wrap a *csvcoder.CellError and strconv.ErrSyntax", rowErr)
+	}
+	if got := rowErr.CellErrors(); len(got) != 1 || got[0] != cellErr {
+		t.Errorf("CellErrors() = %v, want [%v]", got, cellErr)
+	}
+
+	if other := errors.New("not a cell"); NewCellError(other, nil) != other {
+		t.Errorf("NewCellError() changed an error without a csvcoder.CellError")
+	}
+}
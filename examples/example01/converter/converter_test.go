@@ -15,12 +15,17 @@
 package converter_test
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/xtoproto/examples/example01/converter"
+	"github.com/google/xtoproto/protocp"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 
 	pb "github.com/google/xtoproto/examples/example01"
@@ -64,3 +69,50 @@ func TestReader(t *testing.T) {
 		})
 	}
 }
+
+// messageCollector is a protocp.MessageWriter that keeps the messages it is
+// given.
+type messageCollector struct {
+	msgs []proto.Message
+}
+
+func (c *messageCollector) AddRow(ctx context.Context, msg proto.Message) error {
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func (c *messageCollector) Finalize(ctx context.Context) error {
+	return nil
+}
+
+func TestCopier_workers(t *testing.T) {
+	var _ protocp.RecordReader = (*converter.Reader)(nil)
+
+	csv := &strings.Builder{}
+	csv.WriteString("name,age,height\n")
+	var want []proto.Message
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(csv, "person%d,%d,%dcm\n", i, i, 100+i)
+		want = append(want, &pb.MyMessage{
+			Name:   fmt.Sprintf("person%d", i),
+			Age:    int64(i),
+			Height: fmt.Sprintf("%dcm", 100+i),
+		})
+	}
+	csv.WriteString("bad,x,1m\n")
+
+	newReader := func(r io.Reader) (protocp.MessageReader, error) {
+		return converter.NewMessageReader(r)
+	}
+	cp := protocp.NewCopier(newReader, protocp.Workers(4), protocp.SkipErrors(1))
+	got := &messageCollector{}
+	if err := cp.Copy(context.Background(), strings.NewReader(csv.String()), got); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got.msgs, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected diff (-want, +got):\n%s", diff)
+	}
+	if stats := cp.Stats(); stats.Skipped != 1 {
+		t.Errorf("Copy() skipped %d rows, want 1", stats.Skipped)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/google/xtoproto/csvtoprotoparse"
 	"github.com/google/xtoproto/examples/example02/converter02"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/google/xtoproto/examples/example02"
@@ -41,9 +42,9 @@ func TestReader(t *testing.T) {
 	}{
 		{
 			"single line",
-			`project_name,lines_of_code,url,last_modified
-"xtoproto",3000,"https://github.com/google/xtoproto",2020-10-04
-"bazel",500000,"https://bazel.build",2020-2-26
+			`project_name,lines_of_code,url,last_modified,build_time_ms
+"xtoproto",3000,"https://github.com/google/xtoproto",2020-10-04,45000
+"bazel",500000,"https://bazel.build",2020-2-26,1500.5
 `,
 			nil,
 			nil,
@@ -53,12 +54,14 @@ func TestReader(t *testing.T) {
 					LinesOfCode:  3000,
 					Url:          "https://github.com/google/xtoproto",
 					LastModified: mustTimestamp(time.Date(2020, 10, 4, 0, 0, 0, 0, pacificTZ)),
+					BuildTime:    durationpb.New(45 * time.Second),
 				},
 				{
 					ProjectName:  "bazel",
 					LinesOfCode:  500000,
 					Url:          "https://bazel.build",
 					LastModified: mustTimestamp(time.Date(2020, 2, 26, 0, 0, 0, 0, pacificTZ)),
+					BuildTime:    durationpb.New(1500500 * time.Microsecond),
 				},
 			},
 		},
@@ -81,6 +84,30 @@ func TestReader(t *testing.T) {
 	}
 }
 
+func TestWriter_roundTrip(t *testing.T) {
+	// The rows are in the format written by Writer, so writing the messages
+	// read from them produces the same text.
+	const csv = `project_name,lines_of_code,url,last_modified,build_time_ms
+xtoproto,3000,https://github.com/google/xtoproto,2020-10-4,45000
+bazel,500000,https://bazel.build,2020-2-26,1500.5
+`
+	r, err := converter02.NewReader(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("NewReader error: %v", err)
+	}
+	recs, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	out := &strings.Builder{}
+	if err := converter02.NewWriter(out).WriteAll(recs); err != nil {
+		t.Fatalf("WriteAll() error: %v", err)
+	}
+	if diff := cmp.Diff(csv, out.String()); diff != "" {
+		t.Errorf("unexpected diff in written CSV (-want, +got):\n%s", diff)
+	}
+}
+
 func mustTimestamp(t time.Time) *timestamppb.Timestamp {
 	ts, err := ptypes.TimestampProto(t)
 	if err != nil {
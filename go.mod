@@ -3,18 +3,21 @@ module github.com/google/xtoproto
 go 1.14
 
 require (
+	github.com/bazelbuild/buildtools v0.0.0-20201102150426-f0f162f0456b
 	github.com/bazelbuild/rules_go v0.23.3
 	github.com/bmatcuk/doublestar v1.3.4
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v1.4.2
 	github.com/google/go-cmp v0.5.3
 	github.com/jhump/protoreflect v1.8.0
+	github.com/klauspost/compress v1.11.7
 	github.com/mitchellh/go-wordwrap v1.0.0
 	github.com/stoewer/go-strcase v1.2.0
+	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
 	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
 	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/grpc v1.30.0
-	google.golang.org/protobuf v1.25.1-0.20200805231151-a709e31e5d12
+	google.golang.org/protobuf v1.25.1-0.20200805231151-a709e31e5d12 // gomodgen imports protoc-gen-go/internal_gengo; update with care
 )
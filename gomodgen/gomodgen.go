@@ -0,0 +1,314 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gomodgen generates xtoproto code inside a Go module so that
+// converters may be produced with "go generate" instead of Bazel.
+//
+// Given a RecordProtoMapping, Generate writes a .proto file, the .pb.go file
+// for that proto, and the converter .go file. Import paths are derived from the
+// go.mod file of the enclosing module, so the generated packages build with
+// plain "go build". A typical use is a go:generate directive next to a mapping
+// file:
+//
+//	//go:generate go run github.com/google/xtoproto/cmd/xtoproto generate -go_module -mapping=my_message.pbtxt
+package gomodgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/xtoproto/internal/unifieddiff"
+	"github.com/google/xtoproto/service"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/stoewer/go-strcase"
+	// internal_gengo has no compatibility guarantee; see CompileProto.
+	"google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+// Options configures Generate.
+type Options struct {
+	// ConverterDir is the directory of the generated converter package. It
+	// defaults to the current directory.
+	ConverterDir string
+
+	// ProtoDir is the directory where the .proto and .pb.go files are written.
+	// It must differ from ConverterDir because the converter imports the
+	// generated proto package. It defaults to a subdirectory of ConverterDir
+	// named after the message; for example, "mymessagepb" for MyMessage.
+	ProtoDir string
+
+	// ProtocCommand, if non-empty, is run in ProtoDir with the name of the
+	// .proto file appended to its arguments to produce the .pb.go file, for
+	// example "protoc --go_out=paths=source_relative:.". The command is split
+	// on whitespace and is not interpreted by a shell; a command with no
+	// program name is an error.
+	//
+	// If empty, the .pb.go file is generated in-process; see CompileProto for
+	// why a protoc command may be preferable.
+	ProtocCommand string
+
+	// OverwritePolicy and DryRun are passed to GenerateCode. The .pb.go file is
+	// treated like the .proto file it is generated from, except that
+	// OVERWRITE_NEVER also applies to it. DryRun may not be combined with
+	// ProtocCommand.
+	OverwritePolicy spb.GenerateCodeRequest_OverwritePolicy
+	DryRun          bool
+}
+
+// Result describes the files written by Generate.
+type Result struct {
+	// Module is the Go module that contains the generated code.
+	Module *Module
+
+	// Response is the response of the GenerateCode call used to produce the
+	// .proto and converter files. Paths are relative to Module.Dir.
+	Response *spb.GenerateCodeResponse
+
+	// PBGoFile is the .pb.go file generated in-process. It is nil if
+	// ProtocCommand was used. For dry runs, its unified_diff is set.
+	PBGoFile *spb.GenerateCodeResponse_File
+}
+
+// ReadMapping reads a text-format RecordProtoMapping file.
+func ReadMapping(path string) (*pb.RecordProtoMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &pb.RecordProtoMapping{}
+	if err := prototext.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing mapping %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Generate generates the .proto, .pb.go, and converter files for the mapping
+// into the Go module containing opts.ConverterDir.
+//
+// The go_options of the mapping are filled in from the module: proto_import is
+// always set to the import path of ProtoDir, and go_package_name defaults to
+// the last element of the converter package's import path. The .proto and .go
+// files are produced by calling s.GenerateCode with the module directory as
+// the workspace; readFile and writeFile are used to check and write the
+// in-process .pb.go file.
+func Generate(ctx context.Context, s spb.XToProtoServiceServer, readFile service.FileReaderFunc, writeFile service.FileWriterFunc, mapping *pb.RecordProtoMapping, opts *Options) (*Result, error) {
+	if opts.ProtocCommand != "" && len(strings.Fields(opts.ProtocCommand)) == 0 {
+		return nil, fmt.Errorf("protoc command %q has no program name", opts.ProtocCommand)
+	}
+	if opts.DryRun && opts.ProtocCommand != "" {
+		return nil, fmt.Errorf("a dry run may not use a protoc command")
+	}
+	converterDir := opts.ConverterDir
+	if converterDir == "" {
+		converterDir = "."
+	}
+	protoDir := opts.ProtoDir
+	if protoDir == "" {
+		protoDir = filepath.Join(converterDir, defaultProtoPackageName(mapping.GetMessageName()))
+	}
+	mod, err := FindModule(converterDir)
+	if err != nil {
+		return nil, err
+	}
+	converterRelDir, err := mod.RelativePath(converterDir)
+	if err != nil {
+		return nil, err
+	}
+	protoRelDir, err := mod.RelativePath(protoDir)
+	if err != nil {
+		return nil, err
+	}
+	if converterRelDir == protoRelDir {
+		return nil, fmt.Errorf("proto directory and converter directory must differ, both are %s", converterDir)
+	}
+
+	mapping = proto.Clone(mapping).(*pb.RecordProtoMapping)
+	if mapping.GoOptions == nil {
+		mapping.GoOptions = &pb.GoOptions{}
+	}
+	mapping.GoOptions.ProtoImport = path.Join(mod.Path, protoRelDir)
+	if mapping.GoOptions.GoPackageName == "" {
+		mapping.GoOptions.GoPackageName = goPackageName(path.Join(mod.Path, converterRelDir))
+	}
+
+	resp, err := s.GenerateCode(ctx, &spb.GenerateCodeRequest{
+		Mapping:         mapping,
+		WorkspacePath:   mod.Dir,
+		ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{Directory: protoRelDir},
+		Converter:       &spb.GenerateCodeRequest_Converter{Directory: converterRelDir},
+		OverwritePolicy: opts.OverwritePolicy,
+		DryRun:          opts.DryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Module: mod, Response: resp}
+
+	protoFile := resp.GetProtoFile()
+	if opts.ProtocCommand != "" {
+		if err := runProtoc(ctx, opts.ProtocCommand, filepath.Join(mod.Dir, filepath.FromSlash(protoRelDir)), path.Base(protoFile.GetWorkspaceRelativePath())); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	pbGoFile, err := CompileProto(protoFile.GetWorkspaceRelativePath(), protoFile.GetNewContents())
+	if err != nil {
+		return nil, err
+	}
+	result.PBGoFile = pbGoFile
+	fullPath := filepath.Join(mod.Dir, filepath.FromSlash(pbGoFile.GetWorkspaceRelativePath()))
+	if opts.DryRun || opts.OverwritePolicy == spb.GenerateCodeRequest_OVERWRITE_NEVER {
+		existing, err := readFile(ctx, fullPath)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if exists && !bytes.Equal(existing, pbGoFile.GetNewContents()) && opts.OverwritePolicy == spb.GenerateCodeRequest_OVERWRITE_NEVER {
+			return nil, fmt.Errorf("file %s already exists and overwrite policy is %s", fullPath, opts.OverwritePolicy)
+		}
+		if opts.DryRun {
+			oldName := pbGoFile.GetWorkspaceRelativePath()
+			if !exists {
+				oldName = "/dev/null"
+			}
+			pbGoFile.UnifiedDiff = unifieddiff.Diff(oldName, pbGoFile.GetWorkspaceRelativePath(), string(existing), string(pbGoFile.GetNewContents()))
+			return result, nil
+		}
+	}
+	if err := writeFile(ctx, fullPath, pbGoFile.GetNewContents()); err != nil {
+		return nil, fmt.Errorf("error writing %s: %w", fullPath, err)
+	}
+	return result, nil
+}
+
+// CompileProto generates the .pb.go file for a .proto file without invoking
+// protoc.
+//
+// The file name should be relative to the module root. The output file is
+// placed next to the .proto file, as with protoc-gen-go's
+// paths=source_relative option. Imports of the well-known types are
+// supported; other imports are not.
+//
+// The code is generated by protoc-gen-go's internal_gengo package, which is
+// not covered by the google.golang.org/protobuf compatibility promise. It is
+// tested with the version required by xtoproto's go.mod; a program that
+// requires a newer version may fail to build or generate different code. Use
+// Options.ProtocCommand to generate with a specific protoc-gen-go instead.
+func CompileProto(fileName string, contents []byte) (*spb.GenerateCodeResponse_File, error) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{
+			fileName: string(contents),
+		}),
+		IncludeSourceCodeInfo: true,
+	}
+	fds, err := parser.ParseFiles(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing generated .proto file: %w", err)
+	}
+
+	var protoFiles []*descriptorpb.FileDescriptorProto
+	seen := make(map[string]bool)
+	var addFile func(fd *desc.FileDescriptor)
+	addFile = func(fd *desc.FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+		for _, dep := range fd.GetDependencies() {
+			addFile(dep)
+		}
+		protoFiles = append(protoFiles, fd.AsFileDescriptorProto())
+	}
+	for _, fd := range fds {
+		addFile(fd)
+	}
+
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{fileName},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      protoFiles,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			internal_gengo.GenerateFile(gen, f)
+		}
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		return nil, fmt.Errorf("error generating .pb.go file: %s", resp.GetError())
+	}
+	if got := len(resp.GetFile()); got != 1 {
+		return nil, fmt.Errorf("internal error: generated %d files, want 1", got)
+	}
+	return &spb.GenerateCodeResponse_File{
+		WorkspaceRelativePath: resp.GetFile()[0].GetName(),
+		NewContents:           []byte(resp.GetFile()[0].GetContent()),
+	}, nil
+}
+
+func runProtoc(ctx context.Context, command, dir, protoFileName string) error {
+	args := strings.Fields(command)
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], protoFileName)...)
+	cmd.Dir = dir
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("protoc command %q failed: %w\n%s", command, err, out.String())
+	}
+	return nil
+}
+
+var (
+	notPackageNameChar   = regexp.MustCompile(`[^a-z0-9]`)
+	notGoPackageNameChar = regexp.MustCompile(`[^a-z0-9_]`)
+)
+
+// defaultProtoPackageName returns the name of the directory used for the
+// generated proto package of a message.
+func defaultProtoPackageName(messageName string) string {
+	return notPackageNameChar.ReplaceAllString(strings.ToLower(strcase.SnakeCase(messageName)), "") + "pb"
+}
+
+// goPackageName returns a valid Go package name based on the last element of
+// an import path.
+func goPackageName(importPath string) string {
+	name := strcase.SnakeCase(path.Base(importPath))
+	name = notGoPackageNameChar.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "converter_" + name
+	}
+	return name
+}
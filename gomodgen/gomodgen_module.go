@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gomodgen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Module describes the Go module that contains the generated code.
+type Module struct {
+	// Dir is the absolute path of the directory containing go.mod.
+	Dir string
+	// Path is the module path from the module statement of go.mod.
+	Path string
+}
+
+// FindModule returns the Go module that contains dir by searching dir and its
+// parent directories for a go.mod file.
+func FindModule(dir string) (*Module, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for d := absDir; ; d = filepath.Dir(d) {
+		data, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			modPath, err := parseModulePath(data)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s: %w", filepath.Join(d, "go.mod"), err)
+			}
+			return &Module{Dir: d, Path: modPath}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if parent := filepath.Dir(d); parent == d {
+			return nil, fmt.Errorf("no go.mod file found in %s or any parent directory", absDir)
+		}
+	}
+}
+
+// ImportPath returns the Go import path of the package in the given directory,
+// which must be inside the module.
+func (m *Module) ImportPath(dir string) (string, error) {
+	rel, err := m.RelativePath(dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(m.Path, rel), nil
+}
+
+// RelativePath returns the slash-separated path of dir relative to the module
+// root directory. dir must be inside the module.
+func (m *Module) RelativePath(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(m.Dir, absDir)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("directory %s is not inside module %s (%s)", dir, m.Path, m.Dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// parseModulePath returns the module path declared in the contents of a go.mod
+// file.
+func parseModulePath(goMod []byte) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(goMod))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if i := strings.Index(line, "//"); i != -1 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		modPath := fields[1]
+		if strings.HasPrefix(modPath, `"`) || strings.HasPrefix(modPath, "`") {
+			unquoted, err := strconv.Unquote(modPath)
+			if err != nil {
+				return "", fmt.Errorf("invalid module statement %q: %w", line, err)
+			}
+			modPath = unquoted
+		}
+		return modPath, nil
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("missing module statement")
+}
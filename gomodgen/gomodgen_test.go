@@ -0,0 +1,173 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gomodgen
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/xtoproto/service"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+func Test_parseModulePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		goMod   string
+		want    string
+		wantErr bool
+	}{
+		{"simple", "module example.com/m\n\ngo 1.14\n", "example.com/m", false},
+		{"comment", "// leading comment\nmodule example.com/m // trailing\n", "example.com/m", false},
+		{"quoted", "module \"example.com/q\"\n", "example.com/q", false},
+		{"missing", "go 1.14\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModulePath([]byte(tt.goMod))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseModulePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseModulePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	ctx := context.Background()
+	modDir, err := ioutil.TempDir("", "gomodgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(modDir)
+	if err := ioutil.WriteFile(filepath.Join(modDir, "go.mod"), []byte("module example.com/m\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	converterDir := filepath.Join(modDir, "records", "conv")
+
+	writeFile := func(_ context.Context, path string, data []byte) error {
+		if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, data, 0660)
+	}
+	readFile := func(_ context.Context, path string) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}
+	mapping := &pb.RecordProtoMapping{
+		MessageName: "MyMessage",
+		PackageName: "my_package",
+		ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+			{ColName: "a", ProtoType: "int64", ProtoName: "a", ProtoTag: 1},
+			{
+				ColName:      "t",
+				ColumnIndex:  1,
+				ProtoType:    "google.protobuf.Timestamp",
+				ProtoName:    "t",
+				ProtoTag:     2,
+				ProtoImports: []string{"google/protobuf/timestamp.proto"},
+				ParsingInfo: &pb.ColumnToFieldMapping_TimeFormat{
+					TimeFormat: &pb.TimeFormat{GoLayout: "2006-01-02"},
+				},
+			},
+		},
+	}
+	result, err := Generate(ctx, service.New(modDir, readFile, writeFile), readFile, writeFile, mapping, &Options{ConverterDir: converterDir})
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+
+	gotPaths := []string{
+		result.Response.GetProtoFile().GetWorkspaceRelativePath(),
+		result.PBGoFile.GetWorkspaceRelativePath(),
+		result.Response.GetConverterGoFile().GetWorkspaceRelativePath(),
+	}
+	wantPaths := []string{
+		"records/conv/mymessagepb/my_message.proto",
+		"records/conv/mymessagepb/my_message.pb.go",
+		"records/conv/my_message.go",
+	}
+	if diff := cmp.Diff(wantPaths, gotPaths); diff != "" {
+		t.Errorf("unexpected diff in generated paths (-want,+got): %s", diff)
+	}
+
+	wantPackages := map[string]string{
+		"records/conv/mymessagepb/my_message.pb.go": "mymessagepb",
+		"records/conv/my_message.go":                "conv",
+	}
+	for relPath, wantPackage := range wantPackages {
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(modDir, relPath), nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatalf("generated file %s does not parse: %v", relPath, err)
+		}
+		if got := f.Name.Name; got != wantPackage {
+			t.Errorf("%s has package %q, want %q", relPath, got, wantPackage)
+		}
+		if relPath != "records/conv/my_message.go" {
+			continue
+		}
+		foundProtoImport := false
+		for _, imp := range f.Imports {
+			if p, _ := strconv.Unquote(imp.Path.Value); p == "example.com/m/records/conv/mymessagepb" {
+				foundProtoImport = true
+			}
+		}
+		if !foundProtoImport {
+			t.Errorf("%s does not import the generated proto package", relPath)
+		}
+	}
+
+	// Regenerating the same mapping should not change any files.
+	result, err = Generate(ctx, service.New(modDir, readFile, writeFile), readFile, writeFile, mapping, &Options{
+		ConverterDir:    converterDir,
+		OverwritePolicy: spb.GenerateCodeRequest_OVERWRITE_NEVER,
+		DryRun:          true,
+	})
+	if err != nil {
+		t.Fatalf("Generate() dry run failed: %v", err)
+	}
+	for _, f := range []*spb.GenerateCodeResponse_File{
+		result.Response.GetProtoFile(),
+		result.PBGoFile,
+		result.Response.GetConverterGoFile(),
+	} {
+		if diff := f.GetUnifiedDiff(); diff != "" {
+			t.Errorf("dry run reported changes to %s:\n%s", f.GetWorkspaceRelativePath(), diff)
+		}
+	}
+}
+
+func TestGenerate_invalidOptions(t *testing.T) {
+	for _, opts := range []*Options{
+		{ProtocCommand: " \t"},
+		{ProtocCommand: "protoc --go_out=.", DryRun: true},
+	} {
+		_, err := Generate(context.Background(), nil, nil, nil, &pb.RecordProtoMapping{MessageName: "MyMessage"}, opts)
+		if err == nil {
+			t.Errorf("Generate() with options %+v succeeded, want error", opts)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unifieddiff produces line-based diffs of text files in the unified
+// format understood by patch and code review tools.
+package unifieddiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines printed around each change.
+const contextLines = 3
+
+// Diff returns a unified diff that transforms oldText into newText. The file
+// names are used in the "---" and "+++" header lines. The empty string is
+// returned if the texts are equal.
+func Diff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	edits := lineEdits(oldLines, newLines)
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(edits) {
+		writeHunk(b, h, edits, oldLines, newLines)
+	}
+	return b.String()
+}
+
+type editKind int
+
+const (
+	equal editKind = iota
+	deletion
+	insertion
+)
+
+// edit is a single line of the diff. oldIndex and newIndex are the positions
+// of the line in the old and new texts; only the relevant index is meaningful
+// for deletions and insertions.
+type edit struct {
+	kind               editKind
+	oldIndex, newIndex int
+}
+
+// lineEdits computes a minimal edit script using the longest common
+// subsequence of lines.
+func lineEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{equal, i, j})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			edits = append(edits, edit{insertion, i, j})
+			j++
+		default:
+			edits = append(edits, edit{deletion, i, j})
+			i++
+		}
+	}
+	return edits
+}
+
+// hunk is a range of edits [start, end) to be printed together.
+type hunk struct {
+	start, end int
+}
+
+// hunks groups changed lines with their surrounding context lines. Changes
+// separated by fewer than 2*contextLines unchanged lines share a hunk.
+func hunks(edits []edit) []hunk {
+	var out []hunk
+	for i := 0; i < len(edits); i++ {
+		if edits[i].kind == equal {
+			continue
+		}
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].kind == equal {
+				run++
+			}
+			if run == len(edits) || run-end > 2*contextLines {
+				end += contextLines
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = run
+		}
+		out = append(out, hunk{start, end})
+		i = end
+	}
+	return out
+}
+
+func writeHunk(b *strings.Builder, h hunk, edits []edit, oldLines, newLines []string) {
+	oldStart, newStart := edits[h.start].oldIndex, edits[h.start].newIndex
+	oldCount, newCount := 0, 0
+	for _, e := range edits[h.start:h.end] {
+		if e.kind != insertion {
+			oldCount++
+		}
+		if e.kind != deletion {
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, e := range edits[h.start:h.end] {
+		switch e.kind {
+		case equal:
+			writeLine(b, " ", oldLines[e.oldIndex])
+		case deletion:
+			writeLine(b, "-", oldLines[e.oldIndex])
+		case insertion:
+			writeLine(b, "+", newLines[e.newIndex])
+		}
+	}
+}
+
+// hunkRange formats the line range of a hunk. Line numbers are 1-based; an
+// empty range refers to the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(b *strings.Builder, prefix, line string) {
+	b.WriteString(prefix)
+	if strings.HasSuffix(line, "\n") {
+		b.WriteString(line)
+		return
+	}
+	b.WriteString(line)
+	b.WriteString("\n\\ No newline at end of file\n")
+}
+
+// splitLines splits text into lines, keeping the trailing newline of each
+// line.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
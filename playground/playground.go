@@ -55,7 +55,9 @@ f,x`,
 	}
 
 	readFile service.FileReaderFunc = func(_ context.Context, path string) ([]byte, error) {
-		return nil, fmt.Errorf("reading files not supported on web (%q)", path)
+		// Reading files is not supported on web. Report that the file does not
+		// exist so that BUILD files are generated from scratch.
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
 	}
 	writeFile service.FileWriterFunc = func(_ context.Context, path string, data []byte) error {
 		// writing files not supported on web
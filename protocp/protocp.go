@@ -21,16 +21,32 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 const csvReaderBufferSize = 1024 * 1024 * 10
 
 // Copier converts an input record-oriented stream to another record-oriented
 // stream.
+//
+// A Copier may be used for several Copy calls, including concurrent ones. Its
+// Stats accumulate over all calls.
 type Copier struct {
-	newMessageReader func(io.Reader) (MessageReader, error)
+	newMessageReader func(r io.Reader, fileName string) (MessageReader, error)
+	sourceFileField  protoreflect.Name
+	workers          int
+	unordered        bool
+	maxInFlight      int
+
+	// Error policy; see FailFast, SkipErrors and DeadLetters.
+	skipErrors  bool
+	maxErrors   int
+	deadLetters DeadLetterWriter
+
+	stats *stats
 }
 
 // MessageReader iterates through proto messages.
@@ -41,46 +57,157 @@ type MessageReader interface {
 
 // MessageWriter is a generic interface for writing output protos to some record-oriented format.
 type MessageWriter interface {
-	addRow(ctx context.Context, message proto.Message) error
-	finalize(ctx context.Context) error
+	// AddRow writes a single message.
+	AddRow(ctx context.Context, message proto.Message) error
+
+	// Finalize is called once after the last message has been added. It
+	// flushes any buffered output but does not close the underlying writer.
+	Finalize(ctx context.Context) error
 }
 
 // NewCopier returns a CSV converter for the given CSV path and reader/writer generators.
 //
 // newMessageReader returns a function for iterating through csv records as proto.Message instances.
-// newMessageWriter returns a MessageWriter for writing the messages to some output sink.
-func NewCopier(newMessageReader func(io.Reader) (MessageReader, error)) *Copier {
-	return &Copier{
-		newMessageReader,
+// By default, messages are read and written one at a time; see Workers for
+// converting records concurrently.
+func NewCopier(newMessageReader func(io.Reader) (MessageReader, error), opts ...CopierOption) *Copier {
+	return NewFileCopier(func(r io.Reader, fileName string) (MessageReader, error) {
+		return newMessageReader(r)
+	}, opts...)
+}
+
+// NewFileCopier is like NewCopier, but newMessageReader is also passed the
+// name of the file being read, for use in error messages. The name is empty
+// for inputs passed to Copy.
+func NewFileCopier(newMessageReader func(r io.Reader, fileName string) (MessageReader, error), opts ...CopierOption) *Copier {
+	cp := &Copier{
+		newMessageReader: newMessageReader,
+		workers:          1,
+		stats:            &stats{},
+	}
+	for _, opt := range opts {
+		opt.apply(cp)
+	}
+	if cp.maxInFlight == 0 {
+		cp.maxInFlight = defaultInFlightPerWorker * cp.workers
 	}
+	return cp
 }
 
 // Copy translates each CSV line into a proto.Message and outputs all the protos to a
 // record-oriented writer.
+//
+// Records that fail to be read or converted are handled according to the
+// error policy; by default, the first one stops the copy. Copy stops with the
+// context's error if ctx is canceled or its deadline passes before all records
+// have been copied. Finalize is only called if the copy succeeds.
 func (cp *Copier) Copy(ctx context.Context, r io.Reader, writer MessageWriter) error {
-	msgReader, err := cp.newMessageReader(r)
+	if err := cp.copy(ctx, r, &copyRun{}, writer); err != nil {
+		return err
+	}
+	return writer.Finalize(ctx)
+}
+
+// copyRun holds the state shared by the inputs of a single Copy, CopyFile or
+// CopyFiles call.
+type copyRun struct {
+	// fileName is the name of the input being copied, if known.
+	fileName string
+	// skipped is the number of bad records skipped so far.
+	skipped int
+}
+
+// copy copies a single input without finalizing the writer.
+func (cp *Copier) copy(ctx context.Context, r io.Reader, run *copyRun, writer MessageWriter) error {
+	cp.stats.start()
+	defer cp.stats.finish()
+	msgReader, err := cp.newMessageReader(&countingReader{r, &cp.stats.bytes}, run.fileName)
 	if err != nil {
 		return err
 	}
 
+	if recReader, ok := msgReader.(RecordReader); ok && cp.workers > 1 {
+		return cp.copyConcurrently(ctx, recReader, run, writer)
+	}
+	return cp.copySequentially(ctx, msgReader, run, writer)
+}
+
+func (cp *Copier) copySequentially(ctx context.Context, msgReader MessageReader, run *copyRun, writer MessageWriter) error {
 	for i := 1; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		msg, err := msgReader.ReadMessage()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
+			if err := cp.handleBadRecord(ctx, i, err, run); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cp.write(ctx, writer, run, i, msg); err != nil {
 			return err
 		}
-		if err := writer.addRow(ctx, msg); err != nil {
-			return fmt.Errorf("problem adding row %d: %w", i, err)
+	}
+}
+
+// write adds a message to the writer and updates the counters.
+func (cp *Copier) write(ctx context.Context, writer MessageWriter, run *copyRun, row int, msg proto.Message) error {
+	if cp.sourceFileField != "" && run.fileName != "" {
+		if err := setSourceFile(msg, cp.sourceFileField, run.fileName); err != nil {
+			cp.stats.addError(err)
+			return err
 		}
 	}
-	return writer.finalize(ctx)
+	if err := writer.AddRow(ctx, msg); err != nil {
+		err = fmt.Errorf("problem adding row %d: %w", row, err)
+		cp.stats.addError(err)
+		return err
+	}
+	cp.stats.addRow()
+	return nil
 }
 
 // CopyFile opens a file, translates each record of the file into a
 // proto.Message, and outputs all the protos to an output MessageWriter.
-func (cp *Copier) CopyFile(ctx context.Context, fs FileSystem, fileName string, writer MessageWriter) (finalErr error) {
+//
+// Compressed files are decompressed as described by Decompress.
+func (cp *Copier) CopyFile(ctx context.Context, fs FileSystem, fileName string, writer MessageWriter) error {
+	if err := cp.copyFile(ctx, fs, fileName, &copyRun{}, writer); err != nil {
+		return err
+	}
+	return writer.Finalize(ctx)
+}
+
+// CopyFiles copies every file matched by patterns to a single MessageWriter,
+// in the order the patterns are given. Each pattern may be a file name, a
+// directory, or a glob; see ListFileSystem. Compressed files are decompressed
+// as described by Decompress.
+//
+// The error policy applies to the copy as a whole; for example, SkipErrors(5)
+// allows five bad records in total rather than five per file. Finalize is
+// called once, after the last file has been copied.
+func (cp *Copier) CopyFiles(ctx context.Context, fs ListFileSystem, patterns []string, writer MessageWriter) error {
+	var fileNames []string
+	for _, pattern := range patterns {
+		matches, err := fs.List(ctx, pattern)
+		if err != nil {
+			return err
+		}
+		fileNames = append(fileNames, matches...)
+	}
+	run := &copyRun{}
+	for _, fileName := range fileNames {
+		if err := cp.copyFile(ctx, fs, fileName, run, writer); err != nil {
+			return err
+		}
+	}
+	return writer.Finalize(ctx)
+}
+
+func (cp *Copier) copyFile(ctx context.Context, fs FileSystem, fileName string, run *copyRun, writer MessageWriter) (finalErr error) {
 	fio, err := fs.OpenRead(ctx, fileName)
 	if err != nil {
 		return err
@@ -90,11 +217,53 @@ func (cp *Copier) CopyFile(ctx context.Context, fs FileSystem, fileName string,
 			finalErr = err
 		}
 	}()
+	r, err := Decompress(bufio.NewReaderSize(fio, csvReaderBufferSize), fileName)
+	if err != nil {
+		return fmt.Errorf("error decompressing %s: %w", fileName, err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}()
 
-	return cp.Copy(ctx, bufio.NewReaderSize(fio, csvReaderBufferSize), writer)
+	run.fileName = fileName
+	return cp.copy(ctx, r, run, writer)
 }
 
 // FileSystem provides a file system abstraction in the context of protocp.
 type FileSystem interface {
 	OpenRead(context.Context, string) (io.ReadCloser, error)
 }
+
+// SourceFileField returns a CopierOption that sets the string field with the
+// given name to the name of the file each message was read from. It has no
+// effect on inputs passed to Copy, whose names are not known.
+func SourceFileField(name string) CopierOption {
+	return simpleOption(func(cp *Copier) {
+		cp.sourceFileField = protoreflect.Name(name)
+	})
+}
+
+// setSourceFile sets the named string field of msg to fileName.
+func setSourceFile(msg proto.Message, name protoreflect.Name, fileName string) error {
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(name)
+	if fd == nil || fd.Kind() != protoreflect.StringKind || fd.Cardinality() == protoreflect.Repeated {
+		return fmt.Errorf("source file field %q is not a singular string field of %s", name, m.Descriptor().FullName())
+	}
+	m.Set(fd, protoreflect.ValueOfString(fileName))
+	return nil
+}
+
+// countingReader counts the bytes read from an io.Reader.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultInFlightPerWorker is used to compute the default MaxInFlight value.
+const defaultInFlightPerWorker = 16
+
+// CopierOption configures a Copier.
+type CopierOption interface {
+	apply(*Copier)
+}
+
+type simpleOption func(cp *Copier)
+
+func (opt simpleOption) apply(cp *Copier) {
+	opt(cp)
+}
+
+// Workers returns a CopierOption that converts records with n goroutines.
+//
+// Records are only converted concurrently if the MessageReader returned by
+// newMessageReader implements RecordReader; otherwise, and if n is 1, records
+// are read and written one at a time. Values less than 1 are treated as 1.
+func Workers(n int) CopierOption {
+	return simpleOption(func(cp *Copier) {
+		if n < 1 {
+			n = 1
+		}
+		cp.workers = n
+	})
+}
+
+// UnorderedOutput returns a CopierOption that lets messages be written in the
+// order their conversion finishes rather than in input order. This avoids
+// waiting for slow records when order does not matter. It has no effect
+// unless Workers is greater than 1.
+func UnorderedOutput() CopierOption {
+	return simpleOption(func(cp *Copier) {
+		cp.unordered = true
+	})
+}
+
+// MaxInFlight returns a CopierOption that limits the number of records that
+// have been read but not yet written to n, which bounds the memory used by
+// concurrent conversion. Reading pauses while the limit is reached. The default
+// is 16 records per worker.
+func MaxInFlight(n int) CopierOption {
+	return simpleOption(func(cp *Copier) {
+		if n < 1 {
+			n = 1
+		}
+		cp.maxInFlight = n
+	})
+}
+
+// RecordReader is implemented by MessageReaders that can read a record
+// without converting it to a message. The Copier uses it to convert records
+// concurrently.
+type RecordReader interface {
+	MessageReader
+
+	// ReadRecord returns the next record in the stream. At the end of the
+	// stream, it returns io.EOF.
+	ReadRecord() (Record, error)
+}
+
+// Record is a record that has been read but not yet converted.
+type Record interface {
+	// Message converts the record to a message. It may be called concurrently
+	// with ReadRecord and with the Message method of other records.
+	Message() (proto.Message, error)
+}
+
+// copyJob is a record being converted by copyConcurrently.
+type copyJob struct {
+	row    int
+	record Record
+	msg    proto.Message
+	// err is an error reading or converting the record.
+	err error
+	// done is closed once msg and err are set.
+	done chan struct{}
+}
+
+// copyConcurrently reads records on one goroutine, converts them on
+// cp.workers goroutines, and writes them on the calling goroutine.
+func (cp *Copier) copyConcurrently(ctx context.Context, r RecordReader, run *copyRun, writer MessageWriter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// slots limits the number of jobs between reading and writing.
+	slots := make(chan struct{}, cp.maxInFlight)
+	jobs := make(chan *copyJob, cp.maxInFlight)
+	// Jobs are written in the order they are received from output. In
+	// ordered mode, the reader sends jobs to output as they are read;
+	// otherwise the workers send them once they are converted.
+	output := make(chan *copyJob, cp.maxInFlight)
+	send := func(ch chan<- *copyJob, job *copyJob) bool {
+		select {
+		case ch <- job:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg, workers sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		if !cp.unordered {
+			defer close(output)
+		}
+		for row := 1; ; row++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			rec, err := r.ReadRecord()
+			if err == io.EOF {
+				return
+			}
+			job := &copyJob{row: row, record: rec, err: err, done: make(chan struct{})}
+			if !send(jobs, job) {
+				return
+			}
+			if !cp.unordered && !send(output, job) {
+				return
+			}
+			var recErr RecordError
+			if err != nil && !errors.As(err, &recErr) {
+				// Only records with a RecordError may be skipped, so
+				// other read errors end the stream.
+				return
+			}
+		}
+	}()
+	workers.Add(cp.workers)
+	for i := 0; i < cp.workers; i++ {
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				switch {
+				case job.err != nil:
+				case ctx.Err() != nil:
+					job.err = ctx.Err()
+				default:
+					job.msg, job.err = job.record.Message()
+				}
+				close(job.done)
+				if cp.unordered && !send(output, job) {
+					return
+				}
+			}
+		}()
+	}
+	if cp.unordered {
+		go func() {
+			workers.Wait()
+			close(output)
+		}()
+	}
+
+	err := cp.writeJobs(ctx, output, slots, run, writer)
+	cancel()
+	wg.Wait()
+	workers.Wait()
+	return err
+}
+
+// writeJobs writes the messages of the jobs received from output, releasing
+// a slot for each one.
+func (cp *Copier) writeJobs(ctx context.Context, output <-chan *copyJob, slots <-chan struct{}, run *copyRun, writer MessageWriter) error {
+	for job := range output {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if job.err != nil {
+			if err := cp.handleBadRecord(ctx, job.row, job.err, run); err != nil {
+				return err
+			}
+		} else if err := cp.write(ctx, writer, run, job.row, job.msg); err != nil {
+			return err
+		}
+		<-slots
+	}
+	// The reader stops early if ctx is done.
+	return ctx.Err()
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// lineReader is a RecordReader that returns a StringValue for each line of
+// its input. Converting a line fails with a *lineError if it starts with
+// "bad" and with another error if it starts with "fatal".
+type lineReader struct {
+	lines []string
+	// inFlight is incremented for every record read and decremented by
+	// recordingWriter for every message written.
+	inFlight    *int64Gauge
+	maxDelay    time.Duration
+	readRecords int
+}
+
+func (r *lineReader) ReadMessage() (proto.Message, error) {
+	rec, err := r.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+	return rec.Message()
+}
+
+func (r *lineReader) ReadRecord() (Record, error) {
+	if r.readRecords == len(r.lines) {
+		return nil, io.EOF
+	}
+	line := r.lines[r.readRecords]
+	r.readRecords++
+	r.inFlight.add(1)
+	return &lineRecord{line, r.maxDelay}, nil
+}
+
+type lineRecord struct {
+	line     string
+	maxDelay time.Duration
+}
+
+func (rec *lineRecord) Message() (proto.Message, error) {
+	if rec.maxDelay > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(rec.maxDelay))))
+	}
+	if strings.HasPrefix(rec.line, "bad") {
+		return nil, &lineError{rec.line}
+	}
+	if strings.HasPrefix(rec.line, "fatal") {
+		return nil, fmt.Errorf("fatal line %q", rec.line)
+	}
+	return wrapperspb.String(rec.line), nil
+}
+
+// lineError implements RecordError.
+type lineError struct {
+	line string
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("bad line %q", e.line)
+}
+
+func (e *lineError) RecordPosition() string {
+	return "input"
+}
+
+func (e *lineError) RawRecord() string {
+	return e.line
+}
+
+func (e *lineError) RecordErrors() []error {
+	return []error{errors.New("bad")}
+}
+
+// messageOnlyReader hides the RecordReader methods of a lineReader.
+type messageOnlyReader struct {
+	r *lineReader
+}
+
+func (r messageOnlyReader) ReadMessage() (proto.Message, error) {
+	return r.r.ReadMessage()
+}
+
+// int64Gauge tracks a value and its maximum.
+type int64Gauge struct {
+	mu       sync.Mutex
+	value    int64
+	maxValue int64
+}
+
+func (g *int64Gauge) add(n int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += n
+	if g.value > g.maxValue {
+		g.maxValue = g.value
+	}
+}
+
+type recordingWriter struct {
+	inFlight  *int64Gauge
+	got       []string
+	finalized bool
+	// cancel, if not nil, is called after the given number of messages.
+	cancel      func()
+	cancelAfter int
+}
+
+func (w *recordingWriter) AddRow(ctx context.Context, message proto.Message) error {
+	w.inFlight.add(-1)
+	w.got = append(w.got, message.(*wrapperspb.StringValue).GetValue())
+	if w.cancel != nil && len(w.got) == w.cancelAfter {
+		w.cancel()
+	}
+	return nil
+}
+
+func (w *recordingWriter) Finalize(ctx context.Context) error {
+	w.finalized = true
+	return nil
+}
+
+func TestCopier_Copy(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("line %03d", i))
+	}
+	for _, tc := range []struct {
+		name string
+		opts []CopierOption
+		// hideRecords hides the RecordReader methods from the Copier.
+		hideRecords bool
+		lines       []string
+		// cancelAfter cancels the context after the given number of rows if
+		// it is greater than zero.
+		cancelAfter   int
+		wantUnordered bool
+		wantErr       string
+		wantErrIs     error
+		// wantMaxInFlight is an upper bound on the number of records between
+		// reading and writing.
+		wantMaxInFlight int64
+	}{
+		{
+			name:  "sequential",
+			lines: lines,
+		},
+		{
+			name:        "workers without RecordReader",
+			opts:        []CopierOption{Workers(8)},
+			hideRecords: true,
+			lines:       lines,
+		},
+		{
+			name:            "ordered",
+			opts:            []CopierOption{Workers(8), MaxInFlight(10)},
+			lines:           lines,
+			wantMaxInFlight: 10,
+		},
+		{
+			name:            "unordered",
+			opts:            []CopierOption{Workers(8), UnorderedOutput(), MaxInFlight(4)},
+			lines:           lines,
+			wantUnordered:   true,
+			wantMaxInFlight: 4,
+		},
+		{
+			name:    "conversion error",
+			opts:    []CopierOption{Workers(4)},
+			lines:   []string{"a", "b", "bad c", "d"},
+			wantErr: "bad line",
+		},
+		{
+			name:        "canceled sequential",
+			lines:       lines,
+			cancelAfter: 10,
+			wantErrIs:   context.Canceled,
+		},
+		{
+			name:        "canceled concurrent",
+			opts:        []CopierOption{Workers(4)},
+			lines:       lines,
+			cancelAfter: 10,
+			wantErrIs:   context.Canceled,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			inFlight := &int64Gauge{}
+			input := strings.Join(tc.lines, "\n")
+			cp := NewCopier(func(r io.Reader) (MessageReader, error) {
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					return nil, err
+				}
+				lr := &lineReader{lines: strings.Split(string(data), "\n"), inFlight: inFlight, maxDelay: 200 * time.Microsecond}
+				if tc.hideRecords {
+					return messageOnlyReader{lr}, nil
+				}
+				return lr, nil
+			}, tc.opts...)
+			w := &recordingWriter{inFlight: inFlight}
+			if tc.cancelAfter > 0 {
+				w.cancel, w.cancelAfter = cancel, tc.cancelAfter
+			}
+
+			err := cp.Copy(ctx, strings.NewReader(input), w)
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Copy() got error %v, want error containing %q", err, tc.wantErr)
+				}
+				if got := cp.Stats().Errors; got != 1 {
+					t.Errorf("Stats().Errors = %d, want 1", got)
+				}
+				return
+			case tc.wantErrIs != nil:
+				if !errors.Is(err, tc.wantErrIs) {
+					t.Fatalf("Copy() got error %v, want %v", err, tc.wantErrIs)
+				}
+				if w.finalized {
+					t.Errorf("Copy() finalized the writer after an error")
+				}
+				return
+			case err != nil:
+				t.Fatalf("Copy() failed: %v", err)
+			}
+
+			got := w.got
+			if tc.wantUnordered {
+				sort.Strings(got)
+			}
+			if diff := cmp.Diff(tc.lines, got); diff != "" {
+				t.Errorf("unexpected diff in written messages (-want,+got): %s", diff)
+			}
+			if !w.finalized {
+				t.Errorf("Copy() did not finalize the writer")
+			}
+			if tc.wantMaxInFlight != 0 && inFlight.maxValue > tc.wantMaxInFlight {
+				t.Errorf("got %d records in flight, want at most %d", inFlight.maxValue, tc.wantMaxInFlight)
+			}
+			stats := cp.Stats()
+			if stats.Rows != int64(len(tc.lines)) || stats.Bytes != int64(len(input)) || stats.Errors != 0 || stats.Elapsed <= 0 {
+				t.Errorf("Stats() = %+v, want %d rows, %d bytes, and no errors", stats, len(tc.lines), len(input))
+			}
+			time.Sleep(time.Millisecond)
+			if later := cp.Stats(); later.Elapsed != stats.Elapsed {
+				t.Errorf("Stats().Elapsed changed from %v to %v after Copy returned", stats.Elapsed, later.Elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RecordError is implemented by errors that describe a single record that
+// could not be read or converted, such as a row with a malformed cell.
+//
+// Only errors that implement RecordError, or wrap one, are subject to the
+// error policy of a Copier. Other errors, such as I/O errors, always stop the
+// copy.
+type RecordError interface {
+	error
+
+	// RecordPosition returns the position of the record in the input, such
+	// as "input.csv:3".
+	RecordPosition() string
+
+	// RawRecord returns the text of the record as it appears in the input, or
+	// the empty string if it is not known.
+	RawRecord() string
+
+	// RecordErrors returns every problem found with the record.
+	RecordErrors() []error
+}
+
+// BadRecord is a record that a Copier skipped because of an error.
+type BadRecord struct {
+	// Row is the 1-based position of the record among the records read from
+	// its input.
+	Row int
+	// Position is the position of the record in the input.
+	Position string
+	// Raw is the text of the record, if known.
+	Raw string
+	// Errors are the problems found with the record.
+	Errors []error
+}
+
+// DeadLetterWriter receives the records that a Copier skips.
+type DeadLetterWriter interface {
+	WriteBadRecord(ctx context.Context, rec *BadRecord) error
+}
+
+// jsonDeadLetterWriter writes bad records as JSON objects.
+type jsonDeadLetterWriter struct {
+	enc *json.Encoder
+}
+
+// NewDeadLetterWriter returns a DeadLetterWriter that writes each bad record
+// to w as a JSON object on its own line, for example
+//
+//	{"row":3,"position":"input.csv:4","raw":"x,oops","errors":["column \"count\": invalid syntax"]}
+//
+// Writes are not buffered.
+func NewDeadLetterWriter(w io.Writer) DeadLetterWriter {
+	return &jsonDeadLetterWriter{json.NewEncoder(w)}
+}
+
+func (w *jsonDeadLetterWriter) WriteBadRecord(ctx context.Context, rec *BadRecord) error {
+	entry := struct {
+		Row      int      `json:"row"`
+		Position string   `json:"position,omitempty"`
+		Raw      string   `json:"raw,omitempty"`
+		Errors   []string `json:"errors"`
+	}{Row: rec.Row, Position: rec.Position, Raw: rec.Raw}
+	for _, err := range rec.Errors {
+		entry.Errors = append(entry.Errors, err.Error())
+	}
+	return w.enc.Encode(entry)
+}
+
+// FailFast returns a CopierOption that stops the copy at the first record
+// that fails to be read or converted. This is the default.
+func FailFast() CopierOption {
+	return simpleOption(func(cp *Copier) {
+		cp.skipErrors = false
+		cp.deadLetters = nil
+	})
+}
+
+// SkipErrors returns a CopierOption that skips records that fail to be read
+// or converted, as long as they implement RecordError. Copy fails once more
+// than maxErrors records have been skipped; a negative maxErrors allows any
+// number of errors.
+func SkipErrors(maxErrors int) CopierOption {
+	return simpleOption(func(cp *Copier) {
+		cp.skipErrors = true
+		cp.maxErrors = maxErrors
+	})
+}
+
+// DeadLetters returns a CopierOption that skips bad records like SkipErrors
+// and also writes them to w.
+func DeadLetters(w DeadLetterWriter, maxErrors int) CopierOption {
+	return simpleOption(func(cp *Copier) {
+		cp.skipErrors = true
+		cp.maxErrors = maxErrors
+		cp.deadLetters = w
+	})
+}
+
+// handleBadRecord applies the error policy to a record that failed to be read
+// or converted. It returns nil if the copy should continue.
+func (cp *Copier) handleBadRecord(ctx context.Context, row int, err error, run *copyRun) error {
+	cp.stats.addError(err)
+	var recErr RecordError
+	if !cp.skipErrors || !errors.As(err, &recErr) {
+		return err
+	}
+	if cp.maxErrors >= 0 && run.skipped >= cp.maxErrors {
+		return fmt.Errorf("more than %d bad records; last error: %w", cp.maxErrors, err)
+	}
+	if cp.deadLetters != nil {
+		rec := &BadRecord{
+			Row:      row,
+			Position: recErr.RecordPosition(),
+			Raw:      recErr.RawRecord(),
+			Errors:   recErr.RecordErrors(),
+		}
+		if err := cp.deadLetters.WriteBadRecord(ctx, rec); err != nil {
+			return fmt.Errorf("problem writing bad row %d to dead letters: %w", row, err)
+		}
+		cp.stats.addDeadLetter()
+	}
+	run.skipped++
+	cp.stats.addSkipped()
+	return nil
+}
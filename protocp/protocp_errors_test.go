@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCopier_errorPolicies(t *testing.T) {
+	lines := []string{"a", "bad b", "c", "bad d", "e"}
+	for _, tc := range []struct {
+		name  string
+		opts  []CopierOption
+		lines []string
+		// deadLetters adds a DeadLetters option with the given maximum.
+		deadLetters     bool
+		maxErrors       int
+		want            []string
+		wantErr         bool
+		wantDeadLetters string
+		wantSkipped     int64
+	}{
+		{
+			name:    "fail fast",
+			lines:   lines,
+			wantErr: true,
+		},
+		{
+			name:        "skip all errors",
+			opts:        []CopierOption{SkipErrors(-1)},
+			lines:       lines,
+			want:        []string{"a", "c", "e"},
+			wantSkipped: 2,
+		},
+		{
+			name:        "skip all errors concurrently",
+			opts:        []CopierOption{SkipErrors(-1), Workers(3)},
+			lines:       lines,
+			want:        []string{"a", "c", "e"},
+			wantSkipped: 2,
+		},
+		{
+			name:        "error budget exceeded",
+			opts:        []CopierOption{SkipErrors(1)},
+			lines:       lines,
+			wantErr:     true,
+			wantSkipped: 1,
+		},
+		{
+			name:    "errors that are not record errors are not skipped",
+			opts:    []CopierOption{SkipErrors(-1)},
+			lines:   []string{"a", "fatal b", "c"},
+			wantErr: true,
+		},
+		{
+			name:        "dead letters",
+			lines:       lines,
+			deadLetters: true,
+			maxErrors:   -1,
+			want:        []string{"a", "c", "e"},
+			wantDeadLetters: `{"row":2,"position":"input","raw":"bad b","errors":["bad"]}
+{"row":4,"position":"input","raw":"bad d","errors":["bad"]}
+`,
+			wantSkipped: 2,
+		},
+		{
+			name:        "dead letters concurrently",
+			opts:        []CopierOption{Workers(3), UnorderedOutput()},
+			lines:       []string{"a", "bad b"},
+			deadLetters: true,
+			maxErrors:   -1,
+			want:        []string{"a"},
+			wantDeadLetters: `{"row":2,"position":"input","raw":"bad b","errors":["bad"]}
+`,
+			wantSkipped: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			deadLetters := &bytes.Buffer{}
+			opts := tc.opts
+			if tc.deadLetters {
+				opts = append(opts, DeadLetters(NewDeadLetterWriter(deadLetters), tc.maxErrors))
+			}
+			inFlight := &int64Gauge{}
+			cp := NewCopier(func(r io.Reader) (MessageReader, error) {
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					return nil, err
+				}
+				return &lineReader{lines: strings.Split(string(data), "\n"), inFlight: inFlight}, nil
+			}, opts...)
+			w := &recordingWriter{inFlight: inFlight}
+			err := cp.Copy(context.Background(), strings.NewReader(strings.Join(tc.lines, "\n")), w)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Copy() got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := cp.Stats().Skipped; got != tc.wantSkipped {
+				t.Errorf("Stats().Skipped = %d, want %d", got, tc.wantSkipped)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.want, w.got); diff != "" {
+				t.Errorf("unexpected diff in written messages (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantDeadLetters, deadLetters.String()); diff != "" {
+				t.Errorf("unexpected diff in dead letters (-want,+got): %s", diff)
+			}
+			stats := cp.Stats()
+			if !strings.Contains(stats.Summary(), "bad line") || len(stats.SampleErrors) != int(stats.Errors) {
+				t.Errorf("Stats().Summary() = %q, want a summary listing every error", stats.Summary())
+			}
+		})
+	}
+}
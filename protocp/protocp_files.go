@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ListFileSystem is a FileSystem that can find files by pattern.
+type ListFileSystem interface {
+	FileSystem
+
+	// List returns the names of the files matched by pattern in lexical
+	// order. The pattern may be the name of a file, the name of a directory,
+	// or a glob in the syntax of github.com/bmatcuk/doublestar, where "**"
+	// matches any number of path elements. Matched directories are replaced
+	// by every file below them. List returns an error if nothing matches.
+	List(ctx context.Context, pattern string) ([]string, error)
+}
+
+// LocalFileSystem is a ListFileSystem for the files of the operating system.
+type LocalFileSystem struct{}
+
+// OpenRead opens the named file for reading.
+func (LocalFileSystem) OpenRead(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+// List implements ListFileSystem.
+func (LocalFileSystem) List(ctx context.Context, pattern string) ([]string, error) {
+	matches, err := doublestar.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+	}
+	var files []string
+	for _, m := range matches {
+		err := filepath.Walk(m, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortedFiles(pattern, files)
+}
+
+// MemoryFileSystem is a ListFileSystem that holds files in memory, which is
+// useful in tests. File names are slash-separated paths; directories exist
+// implicitly.
+type MemoryFileSystem struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemoryFileSystem returns a MemoryFileSystem containing the given files,
+// which are keyed by name.
+func NewMemoryFileSystem(files map[string][]byte) *MemoryFileSystem {
+	fs := &MemoryFileSystem{files: make(map[string][]byte)}
+	for name, contents := range files {
+		fs.WriteFile(name, contents)
+	}
+	return fs
+}
+
+// WriteFile adds a file to the file system, replacing any existing file with
+// the same name.
+func (fs *MemoryFileSystem) WriteFile(name string, contents []byte) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files[path.Clean(name)] = contents
+}
+
+// OpenRead opens the named file for reading.
+func (fs *MemoryFileSystem) OpenRead(ctx context.Context, name string) (io.ReadCloser, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	contents, ok := fs.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+// List implements ListFileSystem.
+func (fs *MemoryFileSystem) List(ctx context.Context, pattern string) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	pattern = path.Clean(pattern)
+	var files []string
+	for name := range fs.files {
+		// The file matches if it or one of its parent directories matches.
+		for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			ok, err := doublestar.Match(pattern, dir)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+			if ok {
+				files = append(files, name)
+				break
+			}
+		}
+	}
+	return sortedFiles(pattern, files)
+}
+
+// sortedFiles sorts the files matched by a pattern and removes duplicates.
+func sortedFiles(pattern string, files []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %q", pattern)
+	}
+	sort.Strings(files)
+	out := files[:1]
+	for _, f := range files[1:] {
+		if f != out[len(out)-1] {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// compression is a compressed file format understood by Decompress.
+type compression struct {
+	ext string
+	// detect reports whether the start of a file is in the format.
+	detect    func(start []byte) bool
+	newReader func(io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = []*compression{
+	{".gz", hasMagic(0x1f, 0x8b), func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	{".bz2", isBzip2, func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}},
+	{".zst", hasMagic(0x28, 0xb5, 0x2f, 0xfd), func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}},
+	{".xz", hasMagic(0xfd, '7', 'z', 'X', 'Z', 0x00), func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	}},
+}
+
+// maxMagicLength is the number of bytes examined to detect a format, which is
+// the length of the bzip2 signature.
+const maxMagicLength = 10
+
+func hasMagic(magic ...byte) func([]byte) bool {
+	return func(start []byte) bool {
+		return bytes.HasPrefix(start, magic)
+	}
+}
+
+// bzip2BlockMagic follows the "BZh" header and block size digit of a bzip2
+// file with at least one block.
+var bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+
+// isBzip2 reports whether start is the beginning of a bzip2 file. The "BZh"
+// header alone is too likely to start a text file, so the block size digit and
+// block magic number must follow it.
+func isBzip2(start []byte) bool {
+	return len(start) >= 10 &&
+		bytes.HasPrefix(start, []byte("BZh")) &&
+		start[3] >= '1' && start[3] <= '9' &&
+		bytes.Equal(start[4:10], bzip2BlockMagic)
+}
+
+// Decompress returns a reader of the decompressed contents of r, which was
+// read from the file with the given name.
+//
+// The extension of name (".gz", ".bz2", ".zst" or ".xz") selects the gzip,
+// bzip2, zstd or xz format. Files with other names are decompressed if they
+// start with the magic number of one of the formats; otherwise the contents
+// are returned unchanged. Closing the returned reader does not close r.
+func Decompress(r io.Reader, name string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	for _, c := range compressions {
+		if strings.HasSuffix(name, c.ext) {
+			return c.newReader(br)
+		}
+	}
+	// A short or empty file has no magic number; Peek reports that with an
+	// error that may be ignored.
+	start, _ := br.Peek(maxMagicLength)
+	for _, c := range compressions {
+		if c.detect(start) {
+			return c.newReader(br)
+		}
+	}
+	return ioutil.NopCloser(br), nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testFileContents = "line 1\nline 2\n"
+
+// bzip2Contents is testFileContents compressed with bzip2, which the standard
+// library can only decompress.
+var bzip2Contents = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x31, 0x88, 0x21, 0x68, 0x00, 0x00,
+	0x05, 0x59, 0x00, 0x00, 0x10, 0x40, 0x00, 0x30, 0x00, 0x02, 0x25, 0x20, 0x00, 0x31, 0x0c, 0x08,
+	0x12, 0x86, 0x46, 0x89, 0x31, 0x90, 0x87, 0x10, 0xf1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x03, 0x18,
+	0x82, 0x16, 0x80,
+}
+
+func compress(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := newWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, testFileContents); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	gzipContents := compress(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+	zstdContents := compress(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) })
+	xzContents := compress(t, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) })
+	for _, tc := range []struct {
+		name     string
+		fileName string
+		contents []byte
+		want     string
+		wantErr  bool
+	}{
+		{name: "plain", fileName: "data.csv", contents: []byte(testFileContents), want: testFileContents},
+		{name: "empty", fileName: "data.csv", want: ""},
+		{name: "gzip", fileName: "data.csv.gz", contents: gzipContents, want: testFileContents},
+		{name: "bzip2", fileName: "data.csv.bz2", contents: bzip2Contents, want: testFileContents},
+		{name: "zstd", fileName: "data.csv.zst", contents: zstdContents, want: testFileContents},
+		{name: "xz", fileName: "data.csv.xz", contents: xzContents, want: testFileContents},
+		{name: "gzip without extension", fileName: "data", contents: gzipContents, want: testFileContents},
+		{name: "bzip2 without extension", fileName: "data", contents: bzip2Contents, want: testFileContents},
+		{name: "zstd without extension", fileName: "data.csv", contents: zstdContents, want: testFileContents},
+		{name: "text starting with bzip2 header", fileName: "data.csv", contents: []byte("BZh9 rows\n"), want: "BZh9 rows\n"},
+		{name: "zstd with wrong extension", fileName: "data.gz", contents: zstdContents, wantErr: true},
+		{name: "extension of uncompressed file", fileName: "data.csv.gz", contents: []byte(testFileContents), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Decompress(bytes.NewReader(tc.contents), tc.fileName)
+			if err == nil {
+				defer r.Close()
+				var got []byte
+				got, err = ioutil.ReadAll(r)
+				if err == nil && string(got) != tc.want {
+					t.Errorf("Decompress() read %q, want %q", got, tc.want)
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Decompress() got error %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryFileSystem_List(t *testing.T) {
+	fs := NewMemoryFileSystem(map[string][]byte{
+		"a.csv":              nil,
+		"data/b.csv":         nil,
+		"data/c.txt":         nil,
+		"data/2020/d.csv.gz": nil,
+		"data/2021/e.csv":    nil,
+	})
+	testList(t, fs, func(name string) string { return name })
+}
+
+func TestLocalFileSystem_List(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protocp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.csv", "data/b.csv", "data/c.txt", "data/2020/d.csv.gz", "data/2021/e.csv"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testList(t, LocalFileSystem{}, func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) })
+}
+
+// testList checks the List method of a file system containing the files a.csv,
+// data/b.csv, data/c.txt, data/2020/d.csv.gz and data/2021/e.csv. path maps a
+// slash-separated name relative to the root of the files to a name in fs.
+func testList(t *testing.T, fs ListFileSystem, path func(string) string) {
+	t.Helper()
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{pattern: "a.csv", want: []string{"a.csv"}},
+		{pattern: "data", want: []string{"data/2020/d.csv.gz", "data/2021/e.csv", "data/b.csv", "data/c.txt"}},
+		{pattern: "data/*.csv", want: []string{"data/b.csv"}},
+		{pattern: "data/*", want: []string{"data/2020/d.csv.gz", "data/2021/e.csv", "data/b.csv", "data/c.txt"}},
+		{pattern: "**/*.csv", want: []string{"a.csv", "data/2021/e.csv", "data/b.csv"}},
+		{pattern: "data/{2020,2021}/*.csv*", want: []string{"data/2020/d.csv.gz", "data/2021/e.csv"}},
+		{pattern: "missing.csv", wantErr: true},
+		{pattern: "data/*.json", wantErr: true},
+	} {
+		got, err := fs.List(context.Background(), path(tc.pattern))
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("List(%q) got error %v, want error: %v", tc.pattern, err, tc.wantErr)
+			continue
+		}
+		var want []string
+		for _, name := range tc.want {
+			want = append(want, path(name))
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("List(%q) got unexpected files (-want, +got):\n%s", tc.pattern, diff)
+		}
+	}
+}
+
+func TestCopier_CopyFiles(t *testing.T) {
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	io.WriteString(gz, "c\nbad d\n")
+	gz.Close()
+	fs := NewMemoryFileSystem(map[string][]byte{
+		"first.txt":      []byte("a\nb\n"),
+		"more/second.gz": gzipped.Bytes(),
+		"more/third.txt": []byte("bad e\nf\n"),
+	})
+	newReader := func(r io.Reader, fileName string) (MessageReader, error) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return &lineReader{lines: strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), inFlight: &int64Gauge{}}, nil
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []CopierOption
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "skip errors across files",
+			opts:     []CopierOption{SkipErrors(2)},
+			patterns: []string{"first.txt", "more"},
+			want:     []string{"a", "b", "c", "f"},
+		},
+		{
+			name:     "error limit applies to all files",
+			opts:     []CopierOption{SkipErrors(1)},
+			patterns: []string{"first.txt", "more"},
+			wantErr:  true,
+		},
+		{
+			name:     "files are copied in pattern order",
+			patterns: []string{"more/*.gz", "first.txt"},
+			opts:     []CopierOption{SkipErrors(-1), Workers(2)},
+			want:     []string{"c", "a", "b"},
+		},
+		{
+			name:     "source file field",
+			patterns: []string{"**/*.txt"},
+			opts:     []CopierOption{SkipErrors(-1), SourceFileField("value")},
+			want:     []string{"first.txt", "first.txt", "more/third.txt"},
+		},
+		{
+			name:     "bad source file field",
+			patterns: []string{"first.txt"},
+			opts:     []CopierOption{SourceFileField("missing")},
+			wantErr:  true,
+		},
+		{
+			name:     "no matching files",
+			patterns: []string{"first.txt", "*.csv"},
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &recordingWriter{inFlight: &int64Gauge{}}
+			err := NewFileCopier(newReader, tc.opts...).CopyFiles(context.Background(), fs, tc.patterns, w)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("CopyFiles() got error %v, want error: %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tc.want, w.got); diff != "" {
+				t.Errorf("CopyFiles() wrote unexpected messages (-want, +got):\n%s", diff)
+			}
+			if !w.finalized {
+				t.Errorf("CopyFiles() did not finalize the writer")
+			}
+		})
+	}
+}
+
+func TestSetSourceFile(t *testing.T) {
+	msg := &wrapperspb.StringValue{}
+	if err := setSourceFile(msg, "value", "in.csv"); err != nil {
+		t.Fatalf("setSourceFile() failed: %v", err)
+	}
+	if want := wrapperspb.String("in.csv"); !proto.Equal(msg, want) {
+		t.Errorf("setSourceFile() got %v, want %v", msg, want)
+	}
+	if err := setSourceFile(&wrapperspb.Int64Value{}, "value", "in.csv"); err == nil {
+		t.Errorf("setSourceFile() got nil error for an int64 field")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are progress counters of a Copier.
+type Stats struct {
+	// Rows is the number of messages written.
+	Rows int64
+	// Bytes is the number of input bytes read. Readers may read ahead, so this
+	// can include bytes of records that have not been converted yet.
+	Bytes int64
+	// Errors is the number of records that failed to be read, converted, or
+	// written.
+	Errors int64
+	// Skipped is the number of bad records skipped because of the error
+	// policy, and DeadLettered is the number of those written to a
+	// DeadLetterWriter.
+	Skipped, DeadLettered int64
+	// Elapsed is the time since the first call to Copy started. Once no copy
+	// is running, it stops at the time the last one finished.
+	Elapsed time.Duration
+	// SampleErrors contains the messages of the first few errors.
+	SampleErrors []string
+}
+
+// Summary returns a human-readable report of the counters, for example
+//
+//	copied 998 rows (12.3 KB) in 1.2s (831.7 rows/s); 2 errors, 2 rows skipped, 2 dead-lettered
+//	  input.csv:4: column "count": invalid syntax
+//	  input.csv:9: column "when": cannot parse "x" as "2006"
+func (s Stats) Summary() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "copied %d rows (%.1f KB) in %v (%.1f rows/s); %d errors, %d rows skipped, %d dead-lettered",
+		s.Rows, float64(s.Bytes)/1000, s.Elapsed.Round(time.Millisecond), s.RowsPerSecond(), s.Errors, s.Skipped, s.DeadLettered)
+	for _, msg := range s.SampleErrors {
+		fmt.Fprintf(b, "\n  %s", msg)
+	}
+	if omitted := s.Errors - int64(len(s.SampleErrors)); omitted > 0 {
+		fmt.Fprintf(b, "\n  ... and %d more errors", omitted)
+	}
+	return b.String()
+}
+
+// RowsPerSecond returns the average number of messages written per second.
+func (s Stats) RowsPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Rows) / s.Elapsed.Seconds()
+}
+
+// BytesPerSecond returns the average number of input bytes read per second.
+func (s Stats) BytesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// Stats returns the progress counters of the Copier. It is safe to call Stats
+// while Copy is running.
+func (cp *Copier) Stats() Stats {
+	return cp.stats.snapshot()
+}
+
+// stats holds the counters of a Copier. The counters are accessed atomically,
+// so stats must be allocated separately to guarantee 64-bit alignment.
+type stats struct {
+	rows, bytes, errors, skipped, deadLettered int64
+	// startNanos is the Unix time in nanoseconds of the first call to start,
+	// and finishNanos is that of the last call to finish. running is the
+	// number of calls to start without a matching call to finish.
+	startNanos, finishNanos, running int64
+
+	mu           sync.Mutex
+	sampleErrors []string
+}
+
+// maxSampleErrors is the maximum length of Stats.SampleErrors.
+const maxSampleErrors = 10
+
+func (s *stats) start() {
+	atomic.AddInt64(&s.running, 1)
+	atomic.CompareAndSwapInt64(&s.startNanos, 0, time.Now().UnixNano())
+}
+
+func (s *stats) finish() {
+	atomic.StoreInt64(&s.finishNanos, time.Now().UnixNano())
+	atomic.AddInt64(&s.running, -1)
+}
+
+func (s *stats) addRow() {
+	atomic.AddInt64(&s.rows, 1)
+}
+
+func (s *stats) addError(err error) {
+	atomic.AddInt64(&s.errors, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sampleErrors) < maxSampleErrors {
+		s.sampleErrors = append(s.sampleErrors, err.Error())
+	}
+}
+
+func (s *stats) addSkipped() {
+	atomic.AddInt64(&s.skipped, 1)
+}
+
+func (s *stats) addDeadLetter() {
+	atomic.AddInt64(&s.deadLettered, 1)
+}
+
+func (s *stats) snapshot() Stats {
+	st := Stats{
+		Rows:   atomic.LoadInt64(&s.rows),
+		Bytes:  atomic.LoadInt64(&s.bytes),
+		Errors: atomic.LoadInt64(&s.errors),
+
+		Skipped:      atomic.LoadInt64(&s.skipped),
+		DeadLettered: atomic.LoadInt64(&s.deadLettered),
+	}
+	s.mu.Lock()
+	st.SampleErrors = append([]string(nil), s.sampleErrors...)
+	s.mu.Unlock()
+	if start := atomic.LoadInt64(&s.startNanos); start != 0 {
+		end := time.Now().UnixNano()
+		if atomic.LoadInt64(&s.running) == 0 {
+			end = atomic.LoadInt64(&s.finishNanos)
+		}
+		st.Elapsed = time.Duration(end - start)
+	}
+	return st
+}
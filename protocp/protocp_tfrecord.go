@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// tfRecordMaskDelta is added to rotated checksums by maskedCRC.
+const tfRecordMaskDelta = 0xa282ead8
+
+// NewTFRecordWriter returns a MessageWriter that writes messages in the binary
+// wire format using the TFRecord framing of TensorFlow's tf.data.TFRecordDataset.
+// Each record is written as
+//
+//	uint64 length
+//	uint32 masked CRC-32C of length
+//	byte   data[length]
+//	uint32 masked CRC-32C of data
+//
+// with all integers in little-endian byte order.
+func NewTFRecordWriter(w io.Writer) MessageWriter {
+	return newStreamWriter(w, func(b []byte, message proto.Message) ([]byte, error) {
+		size := proto.Size(message)
+		var header [12]byte
+		binary.LittleEndian.PutUint64(header[:8], uint64(size))
+		binary.LittleEndian.PutUint32(header[8:], maskedCRC(header[:8]))
+		b = append(b, header[:]...)
+		start := len(b)
+		b, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(b, message)
+		if err != nil {
+			return nil, err
+		}
+		var footer [4]byte
+		binary.LittleEndian.PutUint32(footer[:], maskedCRC(b[start:]))
+		return append(b, footer[:]...), nil
+	})
+}
+
+// maskedCRC returns the CRC-32C checksum of data masked as in TFRecord files.
+// Masking avoids problems when computing checksums of data that contains
+// embedded checksums.
+func maskedCRC(data []byte) uint32 {
+	crc := crc32.Checksum(data, castagnoliTable)
+	return (crc>>15 | crc<<17) + tfRecordMaskDelta
+}
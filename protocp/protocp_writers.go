@@ -15,8 +15,13 @@
 package protocp
 
 import (
+	"bufio"
 	"context"
+	"io"
 
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -31,21 +36,95 @@ func NewCompositeRecordWriter(writers ...MessageWriter) MessageWriter {
 	return &compositeRecordWriter{writers}
 }
 
-func (cw *compositeRecordWriter) addRow(ctx context.Context, message proto.Message) error {
+func (cw *compositeRecordWriter) AddRow(ctx context.Context, message proto.Message) error {
 	for _, w := range cw.writers {
-		if err := w.addRow(ctx, message); err != nil {
+		if err := w.AddRow(ctx, message); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (cw *compositeRecordWriter) finalize(ctx context.Context) error {
+func (cw *compositeRecordWriter) Finalize(ctx context.Context) error {
 	var firstErr error
 	for _, w := range cw.writers {
-		if err := w.finalize(ctx); err != nil && firstErr == nil {
+		if err := w.Finalize(ctx); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
 	return firstErr
 }
+
+// streamWriter writes encoded messages to a buffered io.Writer.
+type streamWriter struct {
+	w *bufio.Writer
+	// encode appends the encoding of a message, including any framing, to b.
+	encode func(b []byte, message proto.Message) ([]byte, error)
+	buf    []byte
+}
+
+func newStreamWriter(w io.Writer, encode func(b []byte, message proto.Message) ([]byte, error)) *streamWriter {
+	return &streamWriter{w: bufio.NewWriter(w), encode: encode}
+}
+
+func (sw *streamWriter) AddRow(ctx context.Context, message proto.Message) error {
+	b, err := sw.encode(sw.buf[:0], message)
+	if err != nil {
+		return err
+	}
+	sw.buf = b
+	_, err = sw.w.Write(b)
+	return err
+}
+
+func (sw *streamWriter) Finalize(ctx context.Context) error {
+	return sw.w.Flush()
+}
+
+// NewDelimitedWriter returns a MessageWriter that writes each message in the
+// binary wire format preceded by its length as a varint. This is the format
+// read by Java's parseDelimitedFrom and C++'s ParseDelimitedFromZeroCopyStream.
+func NewDelimitedWriter(w io.Writer) MessageWriter {
+	return newStreamWriter(w, func(b []byte, message proto.Message) ([]byte, error) {
+		size := proto.Size(message)
+		b = protowire.AppendVarint(b, uint64(size))
+		return proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(b, message)
+	})
+}
+
+// NewJSONLinesWriter returns a MessageWriter that writes each message on its
+// own line in the JSON format (https://jsonlines.org). The Multiline and Indent
+// options are ignored.
+func NewJSONLinesWriter(w io.Writer, opts protojson.MarshalOptions) MessageWriter {
+	opts.Multiline = false
+	opts.Indent = ""
+	return newStreamWriter(w, func(b []byte, message proto.Message) ([]byte, error) {
+		data, err := opts.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(b, data...), '\n'), nil
+	})
+}
+
+// NewTextprotoWriter returns a MessageWriter that writes messages in the text
+// format. If opts.Multiline is false, each message is written on its own line;
+// otherwise messages are separated by an empty line.
+func NewTextprotoWriter(w io.Writer, opts prototext.MarshalOptions) MessageWriter {
+	first := true
+	return newStreamWriter(w, func(b []byte, message proto.Message) ([]byte, error) {
+		data, err := opts.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Multiline && !first {
+			b = append(b, '\n')
+		}
+		first = false
+		b = append(b, data...)
+		if len(data) == 0 || data[len(data)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		return b, nil
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMessageWriters(t *testing.T) {
+	messages := []proto.Message{
+		wrapperspb.String("hi"),
+		wrapperspb.String("a\nb"),
+	}
+	for _, tc := range []struct {
+		name      string
+		newWriter func(w *bytes.Buffer) MessageWriter
+		check     func(t *testing.T, got []byte)
+	}{
+		{
+			name:      "delimited",
+			newWriter: func(w *bytes.Buffer) MessageWriter { return NewDelimitedWriter(w) },
+			check: func(t *testing.T, got []byte) {
+				want := "\x04\x0a\x02hi\x05\x0a\x03a\nb"
+				if diff := cmp.Diff(want, string(got)); diff != "" {
+					t.Errorf("unexpected diff (-want,+got): %s", diff)
+				}
+			},
+		},
+		{
+			name: "json lines",
+			newWriter: func(w *bytes.Buffer) MessageWriter {
+				return NewJSONLinesWriter(w, protojson.MarshalOptions{Multiline: true})
+			},
+			check: func(t *testing.T, got []byte) {
+				lines := bytes.Split(bytes.TrimSuffix(got, []byte("\n")), []byte("\n"))
+				if len(lines) != len(messages) {
+					t.Fatalf("got %d lines, want %d: %q", len(lines), len(messages), got)
+				}
+				for i, line := range lines {
+					m := &wrapperspb.StringValue{}
+					if err := protojson.Unmarshal(line, m); err != nil {
+						t.Fatalf("line %d: %v", i+1, err)
+					}
+					if !proto.Equal(m, messages[i]) {
+						t.Errorf("line %d is %v, want %v", i+1, m, messages[i])
+					}
+				}
+			},
+		},
+		{
+			name: "textproto",
+			newWriter: func(w *bytes.Buffer) MessageWriter {
+				return NewTextprotoWriter(w, prototext.MarshalOptions{})
+			},
+			check: func(t *testing.T, got []byte) {
+				lines := bytes.Split(bytes.TrimSuffix(got, []byte("\n")), []byte("\n"))
+				if len(lines) != len(messages) {
+					t.Fatalf("got %d lines, want %d: %q", len(lines), len(messages), got)
+				}
+				for i, line := range lines {
+					m := &wrapperspb.StringValue{}
+					if err := prototext.Unmarshal(line, m); err != nil {
+						t.Fatalf("line %d: %v", i+1, err)
+					}
+					if !proto.Equal(m, messages[i]) {
+						t.Errorf("line %d is %v, want %v", i+1, m, messages[i])
+					}
+				}
+			},
+		},
+		{
+			name: "multiline textproto",
+			newWriter: func(w *bytes.Buffer) MessageWriter {
+				return NewTextprotoWriter(w, prototext.MarshalOptions{Multiline: true})
+			},
+			check: func(t *testing.T, got []byte) {
+				records := bytes.Split(got, []byte("\n\n"))
+				if len(records) != len(messages) {
+					t.Fatalf("got %d records, want %d: %q", len(records), len(messages), got)
+				}
+				for i, record := range records {
+					m := &wrapperspb.StringValue{}
+					if err := prototext.Unmarshal(record, m); err != nil {
+						t.Fatalf("record %d: %v", i+1, err)
+					}
+					if !proto.Equal(m, messages[i]) {
+						t.Errorf("record %d is %v, want %v", i+1, m, messages[i])
+					}
+				}
+			},
+		},
+		{
+			name:      "tfrecord",
+			newWriter: func(w *bytes.Buffer) MessageWriter { return NewTFRecordWriter(w) },
+			check: func(t *testing.T, got []byte) {
+				// The checksums were computed with an independent CRC-32C
+				// implementation.
+				want := "0400000000000000424552040a0268698780fd91"
+				if gotHex := hex.EncodeToString(got); !bytes.HasPrefix([]byte(gotHex), []byte(want)) {
+					t.Errorf("got first record %s, want %s", gotHex, want)
+				}
+				if got, want := len(got), 2*16+4+5; got != want {
+					t.Errorf("got %d bytes, want %d", got, want)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			buf := &bytes.Buffer{}
+			w := tc.newWriter(buf)
+			for _, m := range messages {
+				if err := w.AddRow(ctx, m); err != nil {
+					t.Fatalf("AddRow() failed: %v", err)
+				}
+			}
+			if err := w.Finalize(ctx); err != nil {
+				t.Fatalf("Finalize() failed: %v", err)
+			}
+			tc.check(t, buf.Bytes())
+		})
+	}
+}
+
+func TestMaskedCRC(t *testing.T) {
+	// The CRC-32C of "123456789" is 0xe3069283.
+	if got, want := maskedCRC([]byte("123456789")), uint32(0xc78ab0e5); got != want {
+		t.Errorf("maskedCRC() = %#x, want %#x", got, want)
+	}
+}
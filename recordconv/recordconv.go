@@ -0,0 +1,375 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recordconv converts records to protocol buffer messages at runtime
+// based on a RecordProtoMapping.
+//
+// Unlike the code generated by csvtoproto, a Converter does not need a
+// compiled .proto file or generated Go code: the message type is built
+// dynamically from the mapping and the output messages are dynamicpb
+// messages. This makes it suitable for previewing the result of a mapping
+// before generating code for it.
+package recordconv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/xtoproto/csvcoder"
+	"github.com/google/xtoproto/csvtoproto"
+	"github.com/google/xtoproto/csvtoprotoparse"
+	"github.com/google/xtoproto/protocp"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/stoewer/go-strcase"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+// Converter converts records to messages of the type described by a
+// RecordProtoMapping.
+type Converter struct {
+	mapping *pb.RecordProtoMapping
+	fd      protoreflect.FileDescriptor
+	md      protoreflect.MessageDescriptor
+	fields  []*fieldConverter
+	// sourceFile is the field set to the name of the input file, or nil.
+	sourceFile protoreflect.FieldDescriptor
+}
+
+// fieldConverter sets a single field of the output message from a cell value.
+type fieldConverter struct {
+	colName string
+	fd      protoreflect.FieldDescriptor
+	parse   func(value string) (protoreflect.Value, error)
+}
+
+// New returns a Converter for the given mapping.
+func New(mapping *pb.RecordProtoMapping) (*Converter, error) {
+	protoCode, _, err := csvtoproto.GenerateCode(mapping, true, false)
+	if err != nil {
+		return nil, err
+	}
+	fileName := strcase.SnakeCase(mapping.GetMessageName()) + ".proto"
+	if pkg := mapping.GetPackageName(); pkg != "" {
+		fileName = strings.ReplaceAll(pkg, ".", "/") + "/" + fileName
+	}
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{
+			fileName: protoCode,
+		}),
+	}
+	fdProtos, err := parser.ParseFilesButDoNotLink(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("mapping produces an invalid .proto file: %w", err)
+	}
+	fd, err := protodesc.NewFile(fdProtos[0], protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("mapping produces an invalid .proto file: %w", err)
+	}
+	md := fd.Messages().ByName(protoreflect.Name(mapping.GetMessageName()))
+	if md == nil {
+		return nil, fmt.Errorf("internal error: message %q not found in generated .proto file", mapping.GetMessageName())
+	}
+
+	c := &Converter{mapping: mapping, fd: fd, md: md}
+	for i, c2f := range mapping.GetColumnToFieldMappings() {
+		if c2f.GetIgnored() {
+			continue
+		}
+		field := md.Fields().ByName(protoreflect.Name(c2f.GetProtoName()))
+		if field == nil {
+			return nil, fmt.Errorf("internal error: field %q not found in generated message", c2f.GetProtoName())
+		}
+		parse, err := valueParser(c2f)
+		if err != nil {
+			return nil, fmt.Errorf("column_to_field_mappings[%d]: %w", i, err)
+		}
+		c.fields = append(c.fields, &fieldConverter{c2f.GetColName(), field, parse})
+	}
+	if name := mapping.GetSourceFileField(); name != "" {
+		c.sourceFile = md.Fields().ByName(protoreflect.Name(name))
+		if c.sourceFile == nil || c.sourceFile.Kind() != protoreflect.StringKind {
+			return nil, fmt.Errorf("source_file_field %q is not a string field of %s", name, md.FullName())
+		}
+	}
+	return c, nil
+}
+
+// FileDescriptor returns the descriptor of the .proto file generated for the
+// mapping.
+func (c *Converter) FileDescriptor() protoreflect.FileDescriptor {
+	return c.fd
+}
+
+// MessageDescriptor returns the descriptor of the output message type.
+func (c *Converter) MessageDescriptor() protoreflect.MessageDescriptor {
+	return c.md
+}
+
+// ColumnNames returns the names of the columns read by the converter.
+func (c *Converter) ColumnNames() []string {
+	var names []string
+	for _, f := range c.fields {
+		names = append(names, f.colName)
+	}
+	return names
+}
+
+// RowError is returned for a row that fails to convert.
+type RowError struct {
+	// Path is the name of the file that contains the row; it may be empty.
+	Path string
+	// Row is the position of the row in the file.
+	Row csvcoder.RowNumber
+	// Problems describes each cell of the row that failed to convert.
+	Problems []string
+	// Values are the cells of the row, or nil if the row could not be read.
+	Values []string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s: %s", e.RecordPosition(), strings.Join(e.Problems, "; "))
+}
+
+// RecordPosition returns the file name and row number of the row. It
+// implements protocp.RecordError.
+func (e *RowError) RecordPosition() string {
+	return csvcoder.NewRow(nil, nil, e.Row, e.Path).PositionString()
+}
+
+// RawRecord returns the row formatted as a CSV line without a line ending. It
+// implements protocp.RecordError.
+func (e *RowError) RawRecord() string {
+	if e.Values == nil {
+		return ""
+	}
+	b := &strings.Builder{}
+	w := csv.NewWriter(b)
+	w.Write(e.Values)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// RecordErrors returns an error for each problem. It implements
+// protocp.RecordError.
+func (e *RowError) RecordErrors() []error {
+	var errs []error
+	for _, p := range e.Problems {
+		errs = append(errs, errors.New(p))
+	}
+	return errs
+}
+
+// ConvertRow returns the message for a single row. If the mapping has a
+// source_file_field, it is set to the path of the row.
+//
+// If some cells fail to parse, ConvertRow returns the partially populated
+// message along with a *RowError describing every failed cell.
+func (c *Converter) ConvertRow(row *csvcoder.Row) (proto.Message, error) {
+	msg := dynamicpb.NewMessage(c.md)
+	var problems []string
+	for _, f := range c.fields {
+		idx := row.Header().ColumnIndex(f.colName)
+		if !idx.IsValid() {
+			problems = append(problems, fmt.Sprintf("missing column %q", f.colName))
+			continue
+		}
+		if idx.Offset() >= len(row.Strings()) {
+			problems = append(problems, fmt.Sprintf("row does not have a value for column %q", f.colName))
+			continue
+		}
+		v, err := f.parse(row.Strings()[idx.Offset()])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("column %q: %v", f.colName, err))
+			continue
+		}
+		msg.Set(f.fd, v)
+	}
+	if c.sourceFile != nil && row.Path() != "" {
+		msg.Set(c.sourceFile, protoreflect.ValueOfString(row.Path()))
+	}
+	if len(problems) != 0 {
+		return msg, &RowError{row.Path(), row.Number(), problems, row.Strings()}
+	}
+	return msg, nil
+}
+
+func valueParser(c2f *pb.ColumnToFieldMapping) (func(string) (protoreflect.Value, error), error) {
+	switch protoType := c2f.GetProtoType(); protoType {
+	case "int32":
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseInt32(s)
+			return protoreflect.ValueOfInt32(v), err
+		}, nil
+	case "int64":
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseInt64(s)
+			return protoreflect.ValueOfInt64(v), err
+		}, nil
+	case "float":
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseFloat(s)
+			return protoreflect.ValueOfFloat32(v), err
+		}, nil
+	case "double":
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseDouble(s)
+			return protoreflect.ValueOfFloat64(v), err
+		}, nil
+	case "string":
+		return func(s string) (protoreflect.Value, error) {
+			return protoreflect.ValueOfString(s), nil
+		}, nil
+	case "google.protobuf.Timestamp":
+		layout := c2f.GetTimeFormat().GetGoLayout()
+		tz := c2f.GetTimeFormat().GetTimeZoneName()
+		if tz == "" {
+			tz = "UTC"
+		}
+		if _, err := time.LoadLocation(tz); err != nil {
+			return nil, err
+		}
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseTimestamp(s, layout, tz)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(v.ProtoReflect()), nil
+		}, nil
+	case "google.protobuf.Duration":
+		unit := c2f.GetDurationFormat().GetGoUnitSuffix()
+		return func(s string) (protoreflect.Value, error) {
+			v, err := csvtoprotoparse.ParseDuration(s, unit)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(v.ProtoReflect()), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proto_type %q", protoType)
+	}
+}
+
+// Reader reads messages from a CSV file using a Converter. It implements
+// protocp.MessageReader.
+type Reader struct {
+	c        *Converter
+	r        *csv.Reader
+	fileName string
+	hdr      *csvcoder.Header
+	rowNum   csvcoder.RowNumber
+}
+
+// NewReader returns a Reader that reads CSV records from r. The first record
+// must be a header row. The file name is only used in error messages.
+func (c *Converter) NewReader(r io.Reader, fileName string) (*Reader, error) {
+	csvReader := csv.NewReader(r)
+	hdrValues, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header row of %s: %w", fileName, err)
+	}
+	hdr := csvcoder.NewHeader(hdrValues)
+	var missing []string
+	for _, col := range c.ColumnNames() {
+		if !hdr.ColumnIndex(col).IsValid() {
+			missing = append(missing, fmt.Sprintf("%q", col))
+		}
+	}
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("header row of %s is missing %d columns: %s", fileName, len(missing), strings.Join(missing, ", "))
+	}
+	return &Reader{c, csvReader, fileName, hdr, 1}, nil
+}
+
+// Read returns the next message. At the end of the input, Read returns io.EOF.
+//
+// If the row fails to convert, Read returns a *RowError along with the
+// partially converted message, if any; the next call to Read continues with
+// the following row.
+func (r *Reader) Read() (proto.Message, error) {
+	values, err := r.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil && !isParseError(err) {
+		return nil, err
+	}
+	row := csvcoder.NewRow(values, r.hdr, r.rowNum, r.fileName)
+	r.rowNum++
+	if err != nil {
+		return nil, &RowError{r.fileName, row.Number(), []string{fmt.Sprintf("csv.Reader error: %v", err)}, nil}
+	}
+	return r.c.ConvertRow(row)
+}
+
+// RowNumber returns the position of the row most recently returned by Read.
+func (r *Reader) RowNumber() csvcoder.RowNumber {
+	return r.rowNum - 1
+}
+
+// ReadMessage is the same as Read.
+func (r *Reader) ReadMessage() (proto.Message, error) {
+	return r.Read()
+}
+
+// ReadRecord reads the next row without converting it. It implements
+// protocp.RecordReader so that a protocp.Copier can convert rows
+// concurrently. Errors reading a row are returned when the row is converted.
+func (r *Reader) ReadRecord() (protocp.Record, error) {
+	values, err := r.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil && !isParseError(err) {
+		return nil, err
+	}
+	row := csvcoder.NewRow(values, r.hdr, r.rowNum, r.fileName)
+	r.rowNum++
+	rec := &record{c: r.c, row: row}
+	if err != nil {
+		rec.err = &RowError{r.fileName, row.Number(), []string{fmt.Sprintf("csv.Reader error: %v", err)}, nil}
+	}
+	return rec, nil
+}
+
+// isParseError reports whether err is a syntax error in a single row, after
+// which the csv.Reader can continue with the next row.
+func isParseError(err error) bool {
+	var parseErr *csv.ParseError
+	return errors.As(err, &parseErr)
+}
+
+// record is a row read by ReadRecord.
+type record struct {
+	c   *Converter
+	row *csvcoder.Row
+	err error
+}
+
+// Message converts the row. It is safe to call concurrently.
+func (rec *record) Message() (proto.Message, error) {
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	return rec.c.ConvertRow(rec.row)
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordconv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/xtoproto/protocp"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb "github.com/google/xtoproto/proto/recordtoproto"
+)
+
+var testMapping = &pb.RecordProtoMapping{
+	GoOptions:   &pb.GoOptions{GoPackageName: "my_message_converter"},
+	MessageName: "MyMessage",
+	PackageName: "mypackage",
+	ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+		{ColName: "name", ProtoType: "string", ProtoName: "name", ProtoTag: 1},
+		{ColName: "count", ProtoType: "int32", ProtoName: "count", ProtoTag: 2},
+		{ColName: "score", ProtoType: "double", ProtoName: "score", ProtoTag: 3},
+		{
+			ColName:      "when",
+			ProtoType:    "google.protobuf.Timestamp",
+			ProtoName:    "when",
+			ProtoTag:     4,
+			ProtoImports: []string{"google/protobuf/timestamp.proto"},
+			ParsingInfo:  &pb.ColumnToFieldMapping_TimeFormat{TimeFormat: &pb.TimeFormat{GoLayout: "2006-01-02", TimeZoneName: "UTC"}},
+		},
+		{
+			ColName:      "elapsed",
+			ProtoType:    "google.protobuf.Duration",
+			ProtoName:    "elapsed",
+			ProtoTag:     5,
+			ProtoImports: []string{"google/protobuf/duration.proto"},
+			ParsingInfo:  &pb.ColumnToFieldMapping_DurationFormat{DurationFormat: &pb.DurationFormat{GoUnitSuffix: "s"}},
+		},
+		{ColName: "notes", Ignored: true},
+	},
+}
+
+func TestReader(t *testing.T) {
+	c, err := New(testMapping)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if got, want := string(c.MessageDescriptor().FullName()), "mypackage.MyMessage"; got != want {
+		t.Errorf("MessageDescriptor().FullName() = %q, want %q", got, want)
+	}
+	input := strings.Join([]string{
+		"notes,elapsed,when,score,count,name",
+		"x,90,2020-05-01,1.5,3,alpha",
+		"y,1,2020-05-02,oops,4,beta",
+	}, "\n")
+	r, err := c.NewReader(strings.NewReader(input), "input.csv")
+	if err != nil {
+		t.Fatalf("NewReader() failed: %v", err)
+	}
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	want := dynamicpb.NewMessage(c.MessageDescriptor())
+	if err := prototext.Unmarshal([]byte(`name:"alpha" count:3 score:1.5 when:{seconds:1588291200} elapsed:{seconds:90}`), want); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, msg, protocmp.Transform()); diff != "" {
+		t.Errorf("Read() got unexpected message (-want, +got):\n%s", diff)
+	}
+
+	msg, err = r.Read()
+	if err == nil {
+		t.Fatalf("Read() got nil error for row with an invalid score")
+	}
+	if !strings.Contains(err.Error(), "input.csv:3") || !strings.Contains(err.Error(), `column "score"`) {
+		t.Errorf("Read() got error %q, want error mentioning input.csv:3 and column \"score\"", err)
+	}
+	var rowErr *RowError
+	if !errors.As(err, &rowErr) {
+		t.Fatalf("Read() got error of type %T, want *RowError", err)
+	}
+	if got, want := rowErr.Row.Ordinal(), 3; got != want || len(rowErr.Problems) != 1 {
+		t.Errorf("Read() got RowError for row %d with problems %q, want row %d with 1 problem", got, rowErr.Problems, want)
+	}
+	var recErr protocp.RecordError
+	if !errors.As(err, &recErr) {
+		t.Fatalf("Read() got error of type %T, want a protocp.RecordError", err)
+	}
+	if got, want := recErr.RawRecord(), "y,1,2020-05-02,oops,4,beta"; got != want {
+		t.Errorf("RawRecord() = %q, want %q", got, want)
+	}
+	if got, want := recErr.RecordPosition(), "input.csv:3"; got != want {
+		t.Errorf("RecordPosition() = %q, want %q", got, want)
+	}
+	if got := msg.ProtoReflect().Get(c.MessageDescriptor().Fields().ByName("name")).String(); got != "beta" {
+		t.Errorf("partially converted message has name %q, want %q", got, "beta")
+	}
+
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Read() at end of input got error %v, want io.EOF", err)
+	}
+}
+
+func TestReader_concurrentCopy(t *testing.T) {
+	c, err := New(testMapping)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	lines := []string{"notes,elapsed,when,score,count,name"}
+	var want []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("x,1,2020-05-01,1.5,%d,row%d", i, i))
+		want = append(want, fmt.Sprintf("row%d", i))
+	}
+	cp := protocp.NewCopier(func(r io.Reader) (protocp.MessageReader, error) {
+		return c.NewReader(r, "input.csv")
+	}, protocp.Workers(4))
+	w := &nameWriter{c.MessageDescriptor().Fields().ByName("name"), nil}
+	if err := cp.Copy(context.Background(), strings.NewReader(strings.Join(lines, "\n")), w); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if diff := cmp.Diff(want, w.names); diff != "" {
+		t.Errorf("Copy() wrote unexpected messages (-want, +got):\n%s", diff)
+	}
+}
+
+// nameWriter is a protocp.MessageWriter that records the value of a string
+// field of each message.
+type nameWriter struct {
+	fd    protoreflect.FieldDescriptor
+	names []string
+}
+
+func (w *nameWriter) AddRow(ctx context.Context, m proto.Message) error {
+	w.names = append(w.names, m.ProtoReflect().Get(w.fd).String())
+	return nil
+}
+
+func (w *nameWriter) Finalize(ctx context.Context) error {
+	return nil
+}
+
+func TestNewReader_missingColumns(t *testing.T) {
+	c, err := New(testMapping)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	_, err = c.NewReader(strings.NewReader("name,count\nalpha,3\n"), "input.csv")
+	if err == nil || !strings.Contains(err.Error(), "missing 3 columns") {
+		t.Errorf("NewReader() got error %v, want error about 3 missing columns", err)
+	}
+}
+
+func TestNew_unsupportedType(t *testing.T) {
+	m := &pb.RecordProtoMapping{
+		GoOptions:   &pb.GoOptions{GoPackageName: "x"},
+		MessageName: "M",
+		ColumnToFieldMappings: []*pb.ColumnToFieldMapping{
+			{ColName: "a", ProtoType: "sint32", ProtoName: "a", ProtoTag: 1},
+		},
+	}
+	if _, err := New(m); err == nil {
+		t.Errorf("New() got nil error for unsupported proto_type")
+	}
+}
+
+func TestReader_sourceFileField(t *testing.T) {
+	m := proto.Clone(testMapping).(*pb.RecordProtoMapping)
+	m.ExtraFieldDefinitions = []*pb.FieldDefinition{{ProtoName: "source_file", ProtoType: "string", ProtoTag: 6}}
+	m.SourceFileField = "source_file"
+	c, err := New(m)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	r, err := c.NewReader(strings.NewReader("notes,elapsed,when,score,count,name\nx,90,2020-05-01,1.5,3,alpha\n"), "data/input.csv")
+	if err != nil {
+		t.Fatalf("NewReader() failed: %v", err)
+	}
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if got, want := msg.ProtoReflect().Get(c.MessageDescriptor().Fields().ByName("source_file")).String(), "data/input.csv"; got != want {
+		t.Errorf("Read() got message with source_file %q, want %q", got, want)
+	}
+}
@@ -38,6 +38,7 @@ type InferredProto struct {
 	messageName string
 	columns     []*inferredColumn
 	goOpts      *pb.GoOptions
+	conflicts   []*Conflict
 }
 
 // Code returns the source for a .proto file.
@@ -99,29 +100,46 @@ func (ip *InferredProto) FormattedMapping(template *pb.RecordProtoMapping) strin
 }
 
 // RecordBasedInferrer provides a builder interface to an InferredProto.
+//
+// Records may come from several inputs, such as multiple example files. Each
+// input is started with AddInput, and the type of each column is inferred from
+// the values of all inputs combined.
 type RecordBasedInferrer struct {
-	rows [][]string
 	opts *Options
+	// columnNames are the names of the columns of the inferred mapping, in
+	// order.
+	columnNames []string
+	inputs      []*input
 }
 
-// AddRow appends a row to the builder's set of rows. Returns an error if the number of columns in the new row does not
-// match the number of columns in the first row added.
+// AddRow appends a row to the current input. If AddInput has not been called,
+// the first row added is the header row of an unnamed input. Returns an error
+// if the number of columns in the new row does not match the number of columns
+// in the header row of the input.
 func (b *RecordBasedInferrer) AddRow(row []string) error {
-	if (len(b.rows) > 0) && (len(row) != len(b.rows[0])) {
-		return fmt.Errorf("invalid row length; expected %d got %d for row %s", len(b.rows[0]), len(row), row)
+	if len(b.inputs) == 0 {
+		return b.AddInput("", row)
+	}
+	in := b.inputs[len(b.inputs)-1]
+	if len(row) != len(in.header) {
+		return fmt.Errorf("invalid row length; expected %d got %d for row %s", len(in.header), len(row), row)
 	}
 
-	b.rows = append(b.rows, row)
+	in.rows = append(in.rows, row)
 
 	return nil
 }
 
 // Build constructs an InferredProto using the builder's internal data.
 func (b *RecordBasedInferrer) Build() (*InferredProto, error) {
-	if len(b.rows) < 2 {
-		return nil, fmt.Errorf("not enough rows to infer types: %d", len(b.rows))
+	numRows := 0
+	for _, in := range b.inputs {
+		numRows += len(in.rows)
+	}
+	if numRows == 0 {
+		return nil, fmt.Errorf("not enough rows to infer types: %d header rows and no other rows", len(b.inputs))
 	}
-	numCols := len(b.rows[0])
+	numCols := len(b.columnNames)
 	if numCols == 0 {
 		return nil, fmt.Errorf("not enough columns to infer types: %d", numCols)
 	}
@@ -141,12 +159,20 @@ func (b *RecordBasedInferrer) Build() (*InferredProto, error) {
 	}
 
 	for i := 0; i < numCols; i++ {
-		cv := &columnValues{i, b.rows}
+		cv := b.columnValues(i)
 		comment := cv.statisticalComment()
 		colType, err := cv.inferType(b.opts)
 		if err != nil {
 			return nil, err
 		}
+		conflicts, err := b.conflicts(i, colType)
+		if err != nil {
+			return nil, err
+		}
+		if missing := countConflicts(conflicts, MissingColumn); missing != 0 {
+			comment += fmt.Sprintf(". Missing from %d of %d inputs", missing, len(b.inputs))
+		}
+		result.conflicts = append(result.conflicts, conflicts...)
 		result.columns = append(result.columns, &inferredColumn{
 			csvColumnName: cv.columnName(),
 			fieldName:     columnNameToFieldName(cv.columnName()),
@@ -155,6 +181,9 @@ func (b *RecordBasedInferrer) Build() (*InferredProto, error) {
 			comment:       comment,
 		})
 	}
+	sort.SliceStable(result.conflicts, func(i, j int) bool {
+		return result.conflicts[i].InputIndex < result.conflicts[j].InputIndex
+	})
 
 	return result, nil
 }
@@ -193,23 +222,22 @@ type Options struct {
 
 	// TimestampLocation is the time zone name used to parse timestamps that do not have an explicit timezone.
 	TimestampLocation *time.Location
+
+	// HeaderMerge says how the header rows of multiple inputs are combined.
+	HeaderMerge HeaderMergePolicy
 }
 
 type columnValues struct {
-	index int
-	rows  [][]string
+	name   string
+	values []string
 }
 
 func (cv *columnValues) columnName() string {
-	return cv.rows[0][cv.index]
+	return cv.name
 }
 
 func (cv *columnValues) rawValues() []string {
-	var values []string
-	for _, row := range cv.rows[1:] {
-		values = append(values, row[cv.index])
-	}
-	return values
+	return cv.values
 }
 
 const valuesToDisplayInStatisticalComment = 5
@@ -0,0 +1,236 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordinfer
+
+import (
+	"fmt"
+)
+
+// HeaderMergePolicy says how the header rows of multiple inputs are combined
+// into the columns of the inferred mapping.
+type HeaderMergePolicy int
+
+const (
+	// RequireMatchingHeaders requires the header row of every input to equal
+	// the header row of the first input.
+	RequireMatchingHeaders HeaderMergePolicy = iota
+
+	// UnionHeaders infers a field for every column that appears in any input,
+	// in order of first appearance. Columns are matched by name, so the column
+	// names of each input must be unique. Inputs that lack a column are
+	// reported as conflicts of kind MissingColumn.
+	UnionHeaders
+)
+
+// ConflictKind is the kind of a Conflict.
+type ConflictKind int
+
+const (
+	// MissingColumn means that an input does not have a column of the
+	// inferred mapping.
+	MissingColumn ConflictKind = iota + 1
+
+	// TypeMismatch means that the values of a column in one input suggest a
+	// different type than the values of all inputs combined.
+	TypeMismatch
+)
+
+// String returns the name of the kind.
+func (k ConflictKind) String() string {
+	switch k {
+	case MissingColumn:
+		return "MissingColumn"
+	case TypeMismatch:
+		return "TypeMismatch"
+	default:
+		return fmt.Sprintf("ConflictKind(%d)", int(k))
+	}
+}
+
+// Conflict describes how a single input disagrees with the mapping inferred
+// from all inputs. Conflicts are only reported when there are several inputs.
+type Conflict struct {
+	// InputIndex is the index of the input in the order the inputs were
+	// added.
+	InputIndex int
+	// InputName is the name passed to AddInput.
+	InputName string
+	// ColumnName is the name of the column the conflict is about.
+	ColumnName  string
+	Kind        ConflictKind
+	Description string
+}
+
+// Conflicts returns the conflicts found between the inputs used to infer the
+// proto, ordered by input.
+func (ip *InferredProto) Conflicts() []*Conflict {
+	return ip.conflicts
+}
+
+// input is a header row and the records that follow it.
+type input struct {
+	name   string
+	header []string
+	// indices maps the index of a column of the mapping to the index of the
+	// same column in the input's rows.
+	indices map[int]int
+	rows    [][]string
+}
+
+// values returns the values of a column of the mapping in the input's rows,
+// or false if the input does not have the column.
+func (in *input) values(column int) ([]string, bool) {
+	j, ok := in.indices[column]
+	if !ok {
+		return nil, false
+	}
+	var values []string
+	for _, row := range in.rows {
+		values = append(values, row[j])
+	}
+	return values, true
+}
+
+// AddInput starts a new input with the given header row. The rows added by
+// AddRow until the next call to AddInput belong to this input. The name is
+// used in errors and conflicts.
+//
+// The header is combined with the headers of the previous inputs according to
+// the HeaderMerge option.
+func (b *RecordBasedInferrer) AddInput(name string, header []string) error {
+	policy := RequireMatchingHeaders
+	if b.opts != nil {
+		policy = b.opts.HeaderMerge
+	}
+	in := &input{name: name, header: header, indices: make(map[int]int)}
+	switch {
+	case len(b.inputs) == 0 && policy == RequireMatchingHeaders:
+		b.columnNames = append([]string(nil), header...)
+		for i := range header {
+			in.indices[i] = i
+		}
+	case policy == RequireMatchingHeaders:
+		if err := checkHeader(b.columnNames, header); err != nil {
+			return fmt.Errorf("header of input %q does not match the header of input %q: %w", name, b.inputs[0].name, err)
+		}
+		for i := range header {
+			in.indices[i] = i
+		}
+	case policy == UnionHeaders:
+		byName := make(map[string]int)
+		for i, colName := range b.columnNames {
+			byName[colName] = i
+		}
+		seen := make(map[string]bool)
+		for j, colName := range header {
+			if seen[colName] {
+				return fmt.Errorf("input %q has more than one column named %q", name, colName)
+			}
+			seen[colName] = true
+			i, ok := byName[colName]
+			if !ok {
+				i = len(b.columnNames)
+				b.columnNames = append(b.columnNames, colName)
+			}
+			in.indices[i] = j
+		}
+	default:
+		return fmt.Errorf("unknown header merge policy %d", policy)
+	}
+	b.inputs = append(b.inputs, in)
+	return nil
+}
+
+// columnValues returns the values of a column of the mapping in all inputs.
+func (b *RecordBasedInferrer) columnValues(column int) *columnValues {
+	cv := &columnValues{name: b.columnNames[column]}
+	for _, in := range b.inputs {
+		values, _ := in.values(column)
+		cv.values = append(cv.values, values...)
+	}
+	return cv
+}
+
+// conflicts returns the conflicts between the inputs and the type inferred for
+// a column from all inputs.
+func (b *RecordBasedInferrer) conflicts(column int, inferred columnType) ([]*Conflict, error) {
+	if len(b.inputs) < 2 {
+		return nil, nil
+	}
+	colName := b.columnNames[column]
+	var conflicts []*Conflict
+	for i, in := range b.inputs {
+		values, ok := in.values(column)
+		if !ok {
+			conflicts = append(conflicts, &Conflict{
+				InputIndex:  i,
+				InputName:   in.name,
+				ColumnName:  colName,
+				Kind:        MissingColumn,
+				Description: fmt.Sprintf("input does not have column %q", colName),
+			})
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		cv := &columnValues{name: colName, values: values}
+		inputType, err := cv.inferType(b.opts)
+		if err != nil {
+			return nil, err
+		}
+		if got, want := describeColumnType(inputType), describeColumnType(inferred); got != want {
+			conflicts = append(conflicts, &Conflict{
+				InputIndex:  i,
+				InputName:   in.name,
+				ColumnName:  colName,
+				Kind:        TypeMismatch,
+				Description: fmt.Sprintf("values of column %q in this input are %s, but the values of all inputs are %s", colName, got, want),
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+func countConflicts(conflicts []*Conflict, kind ConflictKind) int {
+	n := 0
+	for _, c := range conflicts {
+		if c.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// describeColumnType returns a human-readable description of a column type.
+// Two types with the same description are equivalent.
+func describeColumnType(t columnType) string {
+	if tt, ok := t.(*timeColumnType); ok {
+		return fmt.Sprintf("%s with layout %q", tt.protoType(), tt.layout)
+	}
+	return t.protoType()
+}
+
+func checkHeader(want, got []string) error {
+	if len(want) != len(got) {
+		return fmt.Errorf("got %d columns, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			return fmt.Errorf("column %d is %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	return nil
+}
@@ -211,3 +211,131 @@ func TestRecordBasedInferrer(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordBasedInferrer_multipleInputs(t *testing.T) {
+	type input struct {
+		name string
+		rows [][]string
+	}
+	for _, tc := range []struct {
+		name          string
+		policy        HeaderMergePolicy
+		inputs        []input
+		wantColumns   []string
+		wantTypes     []string
+		wantConflicts []*Conflict
+		wantErr       bool
+	}{
+		{
+			name:   "matching headers",
+			policy: RequireMatchingHeaders,
+			inputs: []input{
+				{"a.csv", [][]string{{"a", "b"}, {"1", "x"}}},
+				{"b.csv", [][]string{{"a", "b"}, {"2", "y"}}},
+			},
+			wantColumns: []string{"a", "b"},
+			wantTypes:   []string{"int64", "string"},
+		},
+		{
+			name:   "type evidence is merged",
+			policy: RequireMatchingHeaders,
+			inputs: []input{
+				{"a.csv", [][]string{{"a", "b"}, {"1", "x"}}},
+				{"b.csv", [][]string{{"a", "b"}, {"2.5", "y"}}},
+			},
+			wantColumns: []string{"a", "b"},
+			wantTypes:   []string{"float", "string"},
+			wantConflicts: []*Conflict{
+				{
+					InputIndex:  0,
+					InputName:   "a.csv",
+					ColumnName:  "a",
+					Kind:        TypeMismatch,
+					Description: `values of column "a" in this input are int64, but the values of all inputs are float`,
+				},
+			},
+		},
+		{
+			name:   "mismatched headers",
+			policy: RequireMatchingHeaders,
+			inputs: []input{
+				{"a.csv", [][]string{{"a", "b"}, {"1", "x"}}},
+				{"b.csv", [][]string{{"b", "a"}, {"y", "2"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:   "union of headers",
+			policy: UnionHeaders,
+			inputs: []input{
+				{"a.csv", [][]string{{"a", "b"}, {"1", "x"}}},
+				{"b.csv", [][]string{{"c", "a"}, {"2020-01-02", "2"}}},
+			},
+			wantColumns: []string{"a", "b", "c"},
+			wantTypes:   []string{"int64", "string", "google.protobuf.Timestamp"},
+			wantConflicts: []*Conflict{
+				{
+					InputIndex:  0,
+					InputName:   "a.csv",
+					ColumnName:  "c",
+					Kind:        MissingColumn,
+					Description: `input does not have column "c"`,
+				},
+				{
+					InputIndex:  1,
+					InputName:   "b.csv",
+					ColumnName:  "b",
+					Kind:        MissingColumn,
+					Description: `input does not have column "b"`,
+				},
+			},
+		},
+		{
+			name:   "duplicate column names with union",
+			policy: UnionHeaders,
+			inputs: []input{
+				{"a.csv", [][]string{{"a", "a"}, {"1", "2"}}},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewRecordBasedInferrer(&Options{MessageName: "ABC", HeaderMerge: tc.policy})
+			var err error
+			for _, in := range tc.inputs {
+				if err = b.AddInput(in.name, in.rows[0]); err != nil {
+					break
+				}
+				for _, row := range in.rows[1:] {
+					if err = b.AddRow(row); err != nil {
+						break
+					}
+				}
+			}
+			var ip *InferredProto
+			if err == nil {
+				ip, err = b.Build()
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var gotColumns, gotTypes []string
+			for _, m := range ip.Mapping().GetColumnToFieldMappings() {
+				gotColumns = append(gotColumns, m.GetColName())
+				gotTypes = append(gotTypes, m.GetProtoType())
+			}
+			if diff := cmp.Diff(tc.wantColumns, gotColumns); diff != "" {
+				t.Errorf("unexpected diff in columns (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantTypes, gotTypes); diff != "" {
+				t.Errorf("unexpected diff in types (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tc.wantConflicts, ip.Conflicts()); diff != "" {
+				t.Errorf("unexpected diff in conflicts (-want,+got): %s", diff)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	sgrpcpb "github.com/google/xtoproto/proto/service"
 )
@@ -31,16 +32,23 @@ type service struct {
 	defaultWorkspaceDir string
 	readFile            FileReaderFunc
 	writeFile           FileWriterFunc
+
+	// progressInterval is the minimum time between progress messages sent by
+	// streaming RPCs. If zero, progress is reported after every request.
+	progressInterval time.Duration
 }
 
+const defaultProgressInterval = time.Second
+
 // New returns a new XToProtoService.
 //
 // defaultWorkspaceDir will be used for the workspace directory when no value
 // is passed in with the request.
 func New(defaultWorkspaceDir string, readFile FileReaderFunc, writeFile FileWriterFunc) sgrpcpb.XToProtoServiceServer {
 	return &service{
-		defaultWorkspaceDir,
-		readFile,
-		writeFile,
+		defaultWorkspaceDir: defaultWorkspaceDir,
+		readFile:            readFile,
+		writeFile:           writeFile,
+		progressInterval:    defaultProgressInterval,
 	}
 }
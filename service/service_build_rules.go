@@ -0,0 +1,389 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/edit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+const (
+	protoRulesBzl   = "@rules_proto//proto:defs.bzl"
+	goProtoRulesBzl = "@io_bazel_rules_go//proto:def.bzl"
+	goRulesBzl      = "@io_bazel_rules_go//go:def.bzl"
+
+	publicVisibility = "//visibility:public"
+)
+
+// buildFileNames are the names of Bazel build files in order of preference.
+// When a package has no build file, the first name is used.
+var buildFileNames = []string{"BUILD.bazel", "BUILD"}
+
+// wellKnownProtoDeps maps the .proto imports that may appear in a mapping to
+// the proto_library rules that provide them.
+var wellKnownProtoDeps = map[string]string{
+	"google/protobuf/duration.proto":  "@com_google_protobuf//:duration_proto",
+	"google/protobuf/timestamp.proto": "@com_google_protobuf//:timestamp_proto",
+}
+
+// defaultXToProtoRepository is the name of the Bazel repository of xtoproto
+// used when a request does not set one. It is the workspace name in
+// xtoproto's WORKSPACE.bazel file.
+const defaultXToProtoRepository = "xtoproto"
+
+// converterRuntimeDeps returns the go_library dependencies of the generated
+// converter code, where repo is the name of the Bazel repository of xtoproto.
+// This list should be kept in sync with bazel/defs.bzl.
+func converterRuntimeDeps(repo string) []string {
+	if repo == "" {
+		repo = defaultXToProtoRepository
+	}
+	return []string{
+		"@org_golang_google_protobuf//proto:go_default_library",
+		"@" + repo + "//csvcoder",
+		"@" + repo + "//csvtoprotoparse",
+		"@" + repo + "//protocp",
+		"@" + repo + "//textcoder",
+	}
+}
+
+// buildFile is a parsed BUILD file that is being updated.
+type buildFile struct {
+	// Path to the file including the workspace directory.
+	fullPath string
+	// Path to the file relative to the workspace root.
+	workspaceRelativePath string
+	// Bazel package of the file; "" for the workspace root.
+	pkg string
+	f   *build.File
+}
+
+// updateBuildFiles creates or updates the BUILD files for the generated .proto
+// and .go files.
+//
+// protoPath and goPath are the workspace-relative paths of the generated files;
+// either may be empty if the corresponding file was not generated. The
+// returned files are not written. If the proto rules and the go_library rule
+// live in the same package, both return values are the same file.
+func (s *service) updateBuildFiles(ctx context.Context, req *spb.GenerateCodeRequest, protoPath, goPath string) (*outputFile, *outputFile, error) {
+	workspace := s.workspacePathForRequest(req)
+	files := make(map[string]*buildFile)
+	getFile := func(pkg string) (*buildFile, error) {
+		if bf := files[pkg]; bf != nil {
+			return bf, nil
+		}
+		bf, err := s.loadBuildFile(ctx, workspace, pkg)
+		if err != nil {
+			return nil, err
+		}
+		files[pkg] = bf
+		return bf, nil
+	}
+
+	var protoBuildFile, goBuildFile *buildFile
+	goProtoLabel := ""
+	if protoPath != "" && req.GetProtoDefinition().GetUpdateBuildRules() {
+		bf, err := getFile(bazelPackage(protoPath))
+		if err != nil {
+			return nil, nil, err
+		}
+		goProtoLabel = bf.updateProtoRules(req, path.Base(protoPath))
+		protoBuildFile = bf
+	}
+	if goPath != "" && req.GetConverter().GetUpdateBuildRules() {
+		bf, err := getFile(bazelPackage(goPath))
+		if err != nil {
+			return nil, nil, err
+		}
+		bf.updateConverterRule(req, path.Base(goPath), goProtoLabel)
+		goBuildFile = bf
+	}
+
+	outputs := make(map[*buildFile]*outputFile)
+	output := func(bf *buildFile) *outputFile {
+		if bf == nil {
+			return nil
+		}
+		if out := outputs[bf]; out != nil {
+			return out
+		}
+		outputs[bf] = &outputFile{
+			fullPath: bf.fullPath,
+			file: &spb.GenerateCodeResponse_File{
+				WorkspaceRelativePath: bf.workspaceRelativePath,
+				NewContents:           build.FormatWithoutRewriting(bf.f),
+			},
+		}
+		return outputs[bf]
+	}
+	return output(protoBuildFile), output(goBuildFile), nil
+}
+
+// loadBuildFile reads and parses the BUILD file of the given package. If the
+// package has no BUILD file, an empty one is returned.
+func (s *service) loadBuildFile(ctx context.Context, workspace, pkg string) (*buildFile, error) {
+	for _, name := range buildFileNames {
+		wsRelativePath := path.Join(pkg, name)
+		fullPath := path.Join(workspace, wsRelativePath)
+		data, err := s.readFile(ctx, fullPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fileErrToStatusErr(fullPath, err)
+		}
+		f, err := build.ParseBuild(wsRelativePath, data)
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "failed to parse BUILD file %q: %v", wsRelativePath, err)
+		}
+		return &buildFile{fullPath, wsRelativePath, pkg, f}, nil
+	}
+	wsRelativePath := path.Join(pkg, buildFileNames[0])
+	return &buildFile{
+		path.Join(workspace, wsRelativePath),
+		wsRelativePath,
+		pkg,
+		&build.File{Path: wsRelativePath, Type: build.TypeBuild},
+	}, nil
+}
+
+// updateProtoRules creates or updates the proto_library and go_proto_library
+// rules for the given .proto file. It returns the absolute label of the
+// go_proto_library rule.
+func (bf *buildFile) updateProtoRules(req *spb.GenerateCodeRequest, protoFileName string) string {
+	protoRule := bf.ruleWithSrc("proto_library", protoFileName)
+	if protoRule == nil {
+		protoRule = bf.findOrAddRule("proto_library", strings.TrimSuffix(protoFileName, ".proto")+"_proto")
+	}
+	protoRuleName := protoRule.Name()
+	addToListAttr(protoRule, "srcs", protoFileName)
+	for _, dep := range protoDeps(req) {
+		addToListAttr(protoRule, "deps", dep)
+	}
+	setDefaultVisibility(protoRule)
+
+	goProtoRule := bf.ruleWithStringAttr("go_proto_library", "proto", ":"+protoRuleName)
+	if goProtoRule == nil {
+		goProtoRule = bf.findOrAddRule("go_proto_library", strings.TrimSuffix(protoRuleName, "_proto")+"_go_proto")
+	}
+	if importPath := req.GetMapping().GetGoOptions().GetProtoImport(); importPath != "" {
+		goProtoRule.SetAttr("importpath", &build.StringExpr{Value: importPath})
+	}
+	goProtoRule.SetAttr("proto", &build.StringExpr{Value: ":" + protoRuleName})
+	setDefaultVisibility(goProtoRule)
+
+	bf.ensureLoad(protoRulesBzl, "proto_library")
+	bf.ensureLoad(goProtoRulesBzl, "go_proto_library")
+
+	return "//" + bf.pkg + ":" + goProtoRule.Name()
+}
+
+// updateConverterRule creates or updates the go_library rule for the
+// generated converter .go file.
+//
+// goProtoLabel is the absolute label of the go_proto_library of the mapped
+// message, or empty if it is unknown.
+func (bf *buildFile) updateConverterRule(req *spb.GenerateCodeRequest, goFileName, goProtoLabel string) {
+	rule := bf.ruleWithSrc("go_library", goFileName)
+	if rule == nil {
+		rule = bf.findOrAddRule("go_library", req.GetMapping().GetGoOptions().GetGoPackageName())
+	}
+	addToListAttr(rule, "srcs", goFileName)
+	if importPath := req.GetConverter().GetGoImportPath(); importPath != "" {
+		rule.SetAttr("importpath", &build.StringExpr{Value: importPath})
+	}
+	deps := converterRuntimeDeps(req.GetConverter().GetXtoprotoRepository())
+	if goProtoLabel != "" {
+		deps = append(deps, bf.relativeLabel(goProtoLabel))
+	}
+	for _, dep := range deps {
+		addToListAttr(rule, "deps", dep)
+	}
+	setDefaultVisibility(rule)
+
+	bf.ensureLoad(goRulesBzl, "go_library")
+}
+
+// ruleWithSrc returns the first rule of the given kind that lists src in its
+// srcs attribute.
+func (bf *buildFile) ruleWithSrc(kind, src string) *build.Rule {
+	for _, r := range bf.f.Rules(kind) {
+		for _, got := range r.AttrStrings("srcs") {
+			if got == src {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// ruleWithStringAttr returns the first rule of the given kind with the given
+// string attribute value.
+func (bf *buildFile) ruleWithStringAttr(kind, attr, value string) *build.Rule {
+	for _, r := range bf.f.Rules(kind) {
+		if r.AttrString(attr) == value {
+			return r
+		}
+	}
+	return nil
+}
+
+// findOrAddRule returns the rule with the given name, appending a new rule of
+// the given kind to the file if no such rule exists.
+func (bf *buildFile) findOrAddRule(kind, name string) *build.Rule {
+	if r := edit.FindRuleByName(bf.f, name); r != nil {
+		return r
+	}
+	r := build.NewRule(&build.CallExpr{
+		X:              &build.Ident{Name: kind},
+		ForceMultiLine: true,
+	})
+	r.SetAttr("name", &build.StringExpr{Value: name})
+	bf.f.Stmt = append(bf.f.Stmt, r.Call)
+	return r
+}
+
+// ensureLoad makes sure that symbol is loaded from the given .bzl file. A new
+// load statement is placed after the existing load statements.
+func (bf *buildFile) ensureLoad(location, symbol string) {
+	lastLoad := -1
+	for i, stmt := range bf.f.Stmt {
+		load, ok := stmt.(*build.LoadStmt)
+		if !ok {
+			continue
+		}
+		lastLoad = i
+		if load.Module.Value == location {
+			edit.AppendToLoad(load, []string{symbol}, []string{symbol})
+			return
+		}
+	}
+	if lastLoad == -1 {
+		bf.f.Stmt = edit.InsertLoad(bf.f.Stmt, location, []string{symbol}, []string{symbol})
+		return
+	}
+	bf.f.Stmt = edit.InsertAfter(lastLoad, bf.f.Stmt, edit.NewLoad(location, []string{symbol}, []string{symbol}))
+}
+
+// relativeLabel returns a label that is relative to the package of the BUILD
+// file if the label is in the same package.
+func (bf *buildFile) relativeLabel(label string) string {
+	if prefix := "//" + bf.pkg + ":"; strings.HasPrefix(label, prefix) {
+		return ":" + strings.TrimPrefix(label, prefix)
+	}
+	return label
+}
+
+// addToListAttr adds value to a list attribute if it is not already present.
+// Plain lists of strings are kept in the order used by buildifier: local
+// labels, then labels in the main repository, then external labels.
+func addToListAttr(r *build.Rule, attr, value string) {
+	list, ok := r.Attr(attr).(*build.ListExpr)
+	if !ok && r.Attr(attr) != nil {
+		edit.AddValueToListAttribute(r, attr, "", &build.StringExpr{Value: value}, nil)
+		return
+	}
+	if list == nil {
+		list = &build.ListExpr{}
+		r.SetAttr(attr, list)
+	}
+	i := 0
+	for ; i < len(list.List); i++ {
+		str, ok := list.List[i].(*build.StringExpr)
+		if !ok {
+			continue
+		}
+		if str.Value == value {
+			return
+		}
+		if labelLess(value, str.Value) {
+			break
+		}
+	}
+	list.List = append(list.List[:i], append([]build.Expr{&build.StringExpr{Value: value}}, list.List[i:]...)...)
+	if len(list.List) > 1 {
+		list.ForceMultiLine = true
+	}
+}
+
+// labelLess reports whether label a sorts before label b in buildifier's
+// ordering.
+func labelLess(a, b string) bool {
+	phase := func(label string) int {
+		switch {
+		case strings.HasPrefix(label, ":"):
+			return 0
+		case strings.HasPrefix(label, "@"):
+			return 2
+		default:
+			return 1
+		}
+	}
+	if pa, pb := phase(a), phase(b); pa != pb {
+		return pa < pb
+	}
+	return a < b
+}
+
+func setDefaultVisibility(r *build.Rule) {
+	if r.Attr("visibility") == nil {
+		addToListAttr(r, "visibility", publicVisibility)
+	}
+}
+
+// protoDeps returns the proto_library dependencies of the .proto file
+// generated for the request.
+func protoDeps(req *spb.GenerateCodeRequest) []string {
+	var imports []string
+	for _, c2f := range req.GetMapping().GetColumnToFieldMappings() {
+		if c2f.GetIgnored() {
+			continue
+		}
+		imports = append(imports, c2f.GetProtoImports()...)
+	}
+	for _, fd := range req.GetMapping().GetExtraFieldDefinitions() {
+		imports = append(imports, fd.GetProtoImports()...)
+	}
+	depSet := make(map[string]bool)
+	for _, imp := range imports {
+		if dep, ok := wellKnownProtoDeps[imp]; ok {
+			depSet[dep] = true
+		}
+	}
+	var deps []string
+	for dep := range depSet {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// bazelPackage returns the package name of a workspace-relative file path.
+func bazelPackage(workspaceRelativePath string) string {
+	if dir := path.Dir(workspaceRelativePath); dir != "." {
+		return dir
+	}
+	return ""
+}
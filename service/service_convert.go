@@ -0,0 +1,193 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/google/xtoproto/recordconv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+// convertStreamBatchSize is the maximum number of records and errors in each
+// ConvertStream response.
+const convertStreamBatchSize = 100
+
+// Convert converts input files using a mapping. See the definition of
+// ConvertRequest in service.proto for more details.
+func (s *service) Convert(ctx context.Context, req *spb.ConvertRequest) (*spb.ConvertResponse, error) {
+	var resp *spb.ConvertResponse
+	err := s.convert(ctx, req, func(header *spb.ConvertResponse) error {
+		resp = header
+		return nil
+	}, func(rec *spb.ConvertedRecord, recErr *spb.RecordError) error {
+		if rec != nil {
+			resp.Records = append(resp.Records, rec)
+		} else {
+			resp.Errors = append(resp.Errors, recErr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ConvertStream is like Convert but streams the results in batches.
+func (s *service) ConvertStream(req *spb.ConvertRequest, stream spb.XToProtoService_ConvertStreamServer) error {
+	var batch *spb.ConvertResponse
+	flush := func() error {
+		if batch == nil {
+			return nil
+		}
+		err := stream.Send(batch)
+		batch = &spb.ConvertResponse{}
+		return err
+	}
+	err := s.convert(stream.Context(), req, func(header *spb.ConvertResponse) error {
+		batch = header
+		return nil
+	}, func(rec *spb.ConvertedRecord, recErr *spb.RecordError) error {
+		if rec != nil {
+			batch.Records = append(batch.Records, rec)
+		} else {
+			batch.Errors = append(batch.Errors, recErr)
+		}
+		if len(batch.Records)+len(batch.Errors) >= convertStreamBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch.GetRecords()) != 0 || len(batch.GetErrors()) != 0 || batch.GetMessageType() != "" {
+		return flush()
+	}
+	return nil
+}
+
+// convert runs the conversion for a request. It first calls header with a
+// response that has the message type fields set, and then calls record with
+// either the converted record or the error of each input record.
+func (s *service) convert(ctx context.Context, req *spb.ConvertRequest, header func(*spb.ConvertResponse) error, record func(*spb.ConvertedRecord, *spb.RecordError) error) error {
+	if req.GetMapping() == nil {
+		return status.Errorf(codes.InvalidArgument, "missing input mapping")
+	}
+	switch req.GetInputFormat() {
+	case spb.Format_UNSPECIFIED_FORMAT, spb.Format_CSV:
+	default:
+		return status.Errorf(codes.Unimplemented, "input_format %s is not supported yet", req.GetInputFormat())
+	}
+	encode, err := recordEncoder(req.GetOutputEncoding())
+	if err != nil {
+		return err
+	}
+	conv, err := recordconv.New(req.GetMapping())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid mapping: %v", err)
+	}
+	if err := header(&spb.ConvertResponse{
+		MessageType: string(conv.MessageDescriptor().FullName()),
+		ProtoFile:   protodesc.ToFileDescriptorProto(conv.FileDescriptor()),
+	}); err != nil {
+		return err
+	}
+
+	var count int64
+	for i, input := range req.GetInputs() {
+		contents, err := s.inputContents(ctx, input)
+		if err != nil {
+			return err
+		}
+		// Inputs given as content have no file name, so source_file_field
+		// is left unset for their records.
+		r, err := conv.NewReader(bytes.NewReader(contents), input.GetInputPath())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "inputs[%d]: %v", i, err)
+		}
+		for {
+			if max := req.GetMaxRecords(); max > 0 && count >= max {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			msg, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			count++
+			var rowErr *recordconv.RowError
+			if errors.As(err, &rowErr) {
+				if err := record(nil, &spb.RecordError{
+					Position:  &spb.RecordPosition{InputIndex: int32(i), RowNumber: int64(rowErr.Row.Ordinal())},
+					Problems:  rowErr.Problems,
+					RawRecord: rowErr.RawRecord(),
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return status.Errorf(codes.Internal, "%v", err)
+			}
+			rec, err := encode(msg)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to encode record: %v", err)
+			}
+			rec.Position = &spb.RecordPosition{InputIndex: int32(i), RowNumber: int64(r.RowNumber().Ordinal())}
+			if err := record(rec, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordEncoder returns a function that wraps converted messages using the
+// given encoding.
+func recordEncoder(encoding spb.ConvertRequest_OutputEncoding) (func(proto.Message) (*spb.ConvertedRecord, error), error) {
+	switch encoding {
+	case spb.ConvertRequest_ANY:
+		return func(m proto.Message) (*spb.ConvertedRecord, error) {
+			a, err := anypb.New(m)
+			if err != nil {
+				return nil, err
+			}
+			return &spb.ConvertedRecord{Message: &spb.ConvertedRecord_Any{Any: a}}, nil
+		}, nil
+	case spb.ConvertRequest_SERIALIZED:
+		return func(m proto.Message) (*spb.ConvertedRecord, error) {
+			data, err := proto.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+			return &spb.ConvertedRecord{Message: &spb.ConvertedRecord_Serialized{Serialized: data}}, nil
+		}, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown output_encoding %v", encoding)
+	}
+}
@@ -47,41 +47,51 @@ func (s *service) GenerateCode(ctx context.Context, req *spb.GenerateCodeRequest
 		return nil, grpc.Errorf(codes.InvalidArgument, "failed to generate code: %v", err)
 	}
 
+	var outputs []*outputFile
 	var outputProtoFile *spb.GenerateCodeResponse_File
+	protoPathWSRelative := ""
 	if genProto {
 		codePath, codePathWSRelative, err := s.protoPath(req)
 		if err != nil {
 			return nil, grpc.Errorf(codes.InvalidArgument, "invalid output specification for .proto file: %v", err)
 		}
-		// TODO(reddaly): Consider configurable overwrite behavior.
-		if err := s.writeFile(ctx, codePath, []byte(protoCode)); err != nil {
-			return nil, fileErrToStatusErr(codePath, err)
-		}
 		outputProtoFile = &spb.GenerateCodeResponse_File{
 			WorkspaceRelativePath: codePathWSRelative,
-			NewContents:           []byte(protoCode),
+			NewContents:           withGeneratedHeader(protoCode),
 		}
+		outputs = append(outputs, &outputFile{codePath, true, outputProtoFile})
+		protoPathWSRelative = codePathWSRelative
 	}
 	var outputGoFile *spb.GenerateCodeResponse_File
+	goPathWSRelative := ""
 	if genGo {
 		codePath, codePathWSRelative, err := s.converterGoPath(req)
 		if err != nil {
 			return nil, grpc.Errorf(codes.InvalidArgument, "invalid output specification for .go file: %v", err)
 		}
-		// TODO(reddaly): Consider configurable overwrite behavior.
-		if err := s.writeFile(ctx, codePath, []byte(goCode)); err != nil {
-			return nil, fileErrToStatusErr(codePath, err)
-		}
 		outputGoFile = &spb.GenerateCodeResponse_File{
 			WorkspaceRelativePath: codePathWSRelative,
-			NewContents:           []byte(goCode),
+			NewContents:           withGeneratedHeader(goCode),
 		}
+		outputs = append(outputs, &outputFile{codePath, true, outputGoFile})
+		goPathWSRelative = codePathWSRelative
+	}
+
+	protoBuildFile, converterBuildFile, err := s.updateBuildFiles(ctx, req, protoPathWSRelative, goPathWSRelative)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, protoBuildFile, converterBuildFile)
+
+	if err := s.writeOutputs(ctx, req, outputs); err != nil {
+		return nil, err
 	}
-	// TODO(reddaly): Update BUILD rule.
 
 	return &spb.GenerateCodeResponse{
-		ProtoFile:       outputProtoFile,
-		ConverterGoFile: outputGoFile,
+		ProtoFile:          outputProtoFile,
+		ProtoBuildFile:     protoBuildFile.responseFile(),
+		ConverterGoFile:    outputGoFile,
+		ConverterBuildFile: converterBuildFile.responseFile(),
 	}, nil
 }
 
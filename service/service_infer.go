@@ -15,11 +15,15 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/xtoproto/csvinfer"
 	"github.com/google/xtoproto/recordinfer"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -30,7 +34,56 @@ import (
 // Infer infers a proto definition from a record-oriented data source. See the
 // definition of InferRequest in service.proto for more details.
 func (s *service) Infer(ctx context.Context, req *spb.InferRequest) (*spb.InferResponse, error) {
+	opts, err := inferOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.GetExampleInputs()) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "must provide at least one entry in example_inputs")
+	}
+	b := recordinfer.NewRecordBasedInferrer(opts)
+	for i, input := range req.GetExampleInputs() {
+		exampleBytes, err := s.inputContents(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		name := input.GetInputPath()
+		if name == "" {
+			name = fmt.Sprintf("example_inputs[%d]", i)
+		}
+		if err := addCSVInput(b, csv.NewReader(bytes.NewReader(exampleBytes)), name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	ip, err := b.Build()
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unknown, "failed to infer proto definition: %v", err)
+	}
 
+	return inferResponse(ip), nil
+}
+
+// inputContents returns the contents of an input file, reading it if
+// necessary.
+func (s *service) inputContents(ctx context.Context, input *spb.InputFile) ([]byte, error) {
+	if len(input.GetInputContent()) != 0 {
+		return input.GetInputContent(), nil
+	}
+	if input.GetInputPath() != "" {
+		contents, err := s.readFile(ctx, input.GetInputPath())
+		if err != nil {
+			return nil, fileErrToStatusErr(input.GetInputPath(), err)
+		}
+		return contents, nil
+	}
+	return nil, grpc.Errorf(codes.InvalidArgument, "missing supported input content spec")
+}
+
+// inferOptions returns the inference options for a request. The
+// example_inputs of the request are ignored.
+func inferOptions(req *spb.InferRequest) (*recordinfer.Options, error) {
 	tz := time.UTC
 	if req.GetTimestampLocation() != "" {
 		loc, err := time.LoadLocation(req.GetTimestampLocation())
@@ -39,41 +92,83 @@ func (s *service) Infer(ctx context.Context, req *spb.InferRequest) (*spb.InferR
 		}
 		tz = loc
 	}
-	opts := &recordinfer.Options{
+
+	switch req.GetInputFormat() {
+	case spb.Format_UNSPECIFIED_FORMAT, spb.Format_CSV:
+	default:
+		return nil, grpc.Errorf(codes.Unimplemented, "input_format %s is not supported yet", req.GetInputFormat())
+	}
+
+	var headerMerge recordinfer.HeaderMergePolicy
+	switch req.GetHeaderMerge() {
+	case spb.InferRequest_REQUIRE_MATCHING_HEADERS:
+		headerMerge = recordinfer.RequireMatchingHeaders
+	case spb.InferRequest_UNION_HEADERS:
+		headerMerge = recordinfer.UnionHeaders
+	default:
+		return nil, grpc.Errorf(codes.InvalidArgument, "unknown header_merge value %v", req.GetHeaderMerge())
+	}
+
+	return &recordinfer.Options{
 		MessageName:       req.GetMessageName(),
 		PackageName:       req.GetPackageName(),
 		GoPackageName:     req.GetGoPackageName(),
 		GoProtoImport:     req.GetGoProtoImport(),
 		TimestampLocation: tz,
-	}
+		HeaderMerge:       headerMerge,
+	}, nil
+}
 
-	if got := len(req.GetExampleInputs()); got != 1 {
-		return nil, grpc.Errorf(codes.InvalidArgument, "must provide exactly one entry in example_inputs, got %d", got)
-	}
-	input := req.GetExampleInputs()[0]
-	var exampleBytes []byte
-	if len(input.GetInputContent()) != 0 {
-		exampleBytes = input.GetInputContent()
-	} else if input.GetInputPath() != "" {
-		contents, err := s.readFile(ctx, input.GetInputPath())
+// addCSVInput adds the header row and records of a CSV input to an inferrer.
+// If records is not nil, it is incremented atomically for every row read.
+func addCSVInput(b *recordinfer.RecordBasedInferrer, r *csv.Reader, name string, records *int64) error {
+	for rowIndex := 0; ; rowIndex++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			if rowIndex == 0 {
+				return grpc.Errorf(codes.InvalidArgument, "input file %q is empty", name)
+			}
+			return nil
+		}
 		if err != nil {
-			return nil, fileErrToStatusErr(input.GetInputPath(), err)
+			return grpc.Errorf(codes.InvalidArgument, "failed to parse input file %q: %v", name, err)
+		}
+		if records != nil {
+			atomic.AddInt64(records, 1)
+		}
+		if rowIndex == 0 {
+			if err := b.AddInput(name, row); err != nil {
+				return grpc.Errorf(codes.InvalidArgument, "%v", err)
+			}
+			continue
+		}
+		if err := b.AddRow(row); err != nil {
+			return grpc.Errorf(codes.InvalidArgument, "input file %q, row %d: %v", name, rowIndex+1, err)
 		}
-		exampleBytes = contents
-	} else {
-		return nil, grpc.Errorf(codes.InvalidArgument, "missing supported input content spec")
-	}
-
-	ip, err := csvinfer.InferProto(string(exampleBytes), opts)
-	if err != nil {
-		return nil, grpc.Errorf(codes.Unknown, "failed to infer proto definition: %v", err)
 	}
+}
 
-	return &spb.InferResponse{
+func inferResponse(ip *recordinfer.InferredProto) *spb.InferResponse {
+	resp := &spb.InferResponse{
 		BestMappingCandidate: &spb.MappingSet{
 			TopLevelMapping: ip.Mapping(),
 		},
-	}, nil
+	}
+	for _, c := range ip.Conflicts() {
+		resp.Conflicts = append(resp.Conflicts, &spb.InputConflict{
+			InputIndex:  int32(c.InputIndex),
+			InputName:   c.InputName,
+			ColumnName:  c.ColumnName,
+			Kind:        conflictKinds[c.Kind],
+			Description: c.Description,
+		})
+	}
+	return resp
+}
+
+var conflictKinds = map[recordinfer.ConflictKind]spb.InputConflict_Kind{
+	recordinfer.MissingColumn: spb.InputConflict_MISSING_COLUMN,
+	recordinfer.TypeMismatch:  spb.InputConflict_TYPE_MISMATCH,
 }
 
 func fileErrToStatusErr(path string, err error) error {
@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/csv"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/xtoproto/recordinfer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+// InferStream infers a proto definition from example inputs uploaded in
+// chunks. See the definition of InferStream in service.proto for more details.
+func (s *service) InferStream(stream spb.XToProtoService_InferStreamServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Errorf(codes.InvalidArgument, "stream ended before options were received")
+	}
+	if err != nil {
+		return err
+	}
+	if first.GetOptions() == nil {
+		return status.Errorf(codes.InvalidArgument, "the first request must contain options")
+	}
+	if len(first.GetOptions().GetExampleInputs()) != 0 {
+		return status.Errorf(codes.InvalidArgument, "options.example_inputs must be empty; send input files as chunks")
+	}
+	opts, err := inferOptions(first.GetOptions())
+	if err != nil {
+		return err
+	}
+
+	in := &streamingCSVInput{b: recordinfer.NewRecordBasedInferrer(opts)}
+	progress := &spb.InferProgress{}
+	lastProgress := time.Now()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			in.abort(err)
+			return err
+		}
+		chunk := req.GetChunk()
+		if chunk == nil {
+			in.abort(io.ErrUnexpectedEOF)
+			return status.Errorf(codes.InvalidArgument, "only the first request may contain options")
+		}
+		if err := in.write(chunk); err != nil {
+			return err
+		}
+		progress.FilesReceived = int32(in.files)
+		progress.BytesReceived += int64(len(chunk.GetContent()))
+		if time.Since(lastProgress) >= s.progressInterval {
+			progress.RecordsProcessed = in.recordsProcessed()
+			if err := stream.Send(&spb.InferStreamResponse{
+				Response: &spb.InferStreamResponse_Progress{Progress: progress},
+			}); err != nil {
+				in.abort(err)
+				return err
+			}
+			lastProgress = time.Now()
+		}
+	}
+	if err := in.finishFile(); err != nil {
+		return err
+	}
+	if in.files == 0 {
+		return status.Errorf(codes.InvalidArgument, "no input chunks were received")
+	}
+
+	ip, err := in.b.Build()
+	if err != nil {
+		return status.Errorf(codes.Unknown, "failed to infer proto definition: %v", err)
+	}
+	return stream.Send(&spb.InferStreamResponse{
+		Response: &spb.InferStreamResponse_Result{Result: inferResponse(ip)},
+	})
+}
+
+// streamingCSVInput parses CSV files that arrive in chunks and adds their
+// records to an inferrer as they are parsed.
+//
+// Every file is added to the same inferrer as a separate input, so headers are
+// merged according to the header_merge option.
+type streamingCSVInput struct {
+	b *recordinfer.RecordBasedInferrer
+	// files is the number of files started so far.
+	files    int
+	fileName string
+	// records is accessed atomically because it is updated by the parsing
+	// goroutine.
+	records int64
+
+	// w is the writer for the current file, or nil if no file is being
+	// parsed; the chunks written to it are parsed by a goroutine that sends
+	// its result on done.
+	w    *io.PipeWriter
+	done chan error
+}
+
+// write passes the content of a chunk to the parser of its file, starting a
+// new file if needed.
+func (in *streamingCSVInput) write(chunk *spb.InputChunk) error {
+	if in.w == nil || chunk.GetFileName() != in.fileName {
+		if err := in.finishFile(); err != nil {
+			return err
+		}
+		in.startFile(chunk.GetFileName())
+	}
+	if _, writeErr := in.w.Write(chunk.GetContent()); writeErr != nil {
+		// The parser stopped early; its error is more useful than the pipe's.
+		if err := in.finishFile(); err != nil {
+			return err
+		}
+		return writeErr
+	}
+	return nil
+}
+
+func (in *streamingCSVInput) startFile(fileName string) {
+	r, w := io.Pipe()
+	in.files++
+	in.fileName = fileName
+	in.w = w
+	in.done = make(chan error, 1)
+	go func() {
+		err := in.parse(csv.NewReader(r), fileName)
+		// Unblock pending writes if parsing stopped early.
+		r.CloseWithError(err)
+		in.done <- err
+	}()
+}
+
+// finishFile waits for the parser of the current file, if any, to finish and
+// returns its error.
+func (in *streamingCSVInput) finishFile() error {
+	if in.w == nil {
+		return nil
+	}
+	in.w.Close()
+	err := <-in.done
+	in.w, in.done = nil, nil
+	return err
+}
+
+// abort stops the parser of the current file, if any.
+func (in *streamingCSVInput) abort(err error) {
+	if in.w == nil {
+		return
+	}
+	in.w.CloseWithError(err)
+	<-in.done
+	in.w, in.done = nil, nil
+}
+
+func (in *streamingCSVInput) recordsProcessed() int64 {
+	return atomic.LoadInt64(&in.records)
+}
+
+// parse adds the records read from r to the inferrer.
+func (in *streamingCSVInput) parse(r *csv.Reader, fileName string) error {
+	return addCSVInput(in.b, r, fileName, &in.records)
+}
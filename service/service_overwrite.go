@@ -0,0 +1,148 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/google/xtoproto/internal/unifieddiff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	spb "github.com/google/xtoproto/proto/service"
+)
+
+// generatedHeaderFormat is the first line of generated .proto and .go files.
+// The checksum covers the rest of the file and is used to detect edits for
+// OVERWRITE_IF_UNCHANGED. The line follows the Go convention for marking
+// generated files.
+const generatedHeaderFormat = "// Code generated by xtoproto (checksum sha256:%x). DO NOT EDIT.\n"
+
+var generatedHeaderRegexp = regexp.MustCompile(`^// Code generated by xtoproto \(checksum sha256:([0-9a-f]{64})\)\. DO NOT EDIT\.\n`)
+
+// withGeneratedHeader returns the contents of a generated file with a header
+// line that includes the checksum of the code.
+func withGeneratedHeader(code string) []byte {
+	body := "\n" + code
+	return []byte(fmt.Sprintf(generatedHeaderFormat, sha256.Sum256([]byte(body))) + body)
+}
+
+// editedSinceGeneration reports whether the contents of a file differ from
+// what was generated, based on the checksum in its header line. Files without
+// a header are reported as edited.
+func editedSinceGeneration(contents []byte) bool {
+	m := generatedHeaderRegexp.FindSubmatch(contents)
+	if m == nil {
+		return true
+	}
+	body := contents[len(m[0]):]
+	return fmt.Sprintf("%x", sha256.Sum256(body)) != string(m[1])
+}
+
+// outputFile is a file produced by GenerateCode.
+type outputFile struct {
+	// Path to the file including the workspace directory.
+	fullPath string
+	// generated is true for files that are replaced entirely when regenerated
+	// and have a checksum header, as opposed to BUILD files, which are
+	// updated in place.
+	generated bool
+	file      *spb.GenerateCodeResponse_File
+}
+
+// responseFile returns the file to use in the GenerateCodeResponse; nil if f
+// is nil.
+func (f *outputFile) responseFile() *spb.GenerateCodeResponse_File {
+	if f == nil {
+		return nil
+	}
+	return f.file
+}
+
+// writeOutputs checks the output files against the overwrite policy of the
+// request and writes them. Nothing is written if any file violates the policy.
+// For dry runs, the unified diff of each file is set instead of writing it.
+//
+// Nil entries and repeated entries in outputs are ignored.
+func (s *service) writeOutputs(ctx context.Context, req *spb.GenerateCodeRequest, outputs []*outputFile) error {
+	var files []*outputFile
+	seen := make(map[*outputFile]bool)
+	for _, out := range outputs {
+		if out != nil && !seen[out] {
+			seen[out] = true
+			files = append(files, out)
+		}
+	}
+
+	policy := req.GetOverwritePolicy()
+	if req.GetDryRun() || policy != spb.GenerateCodeRequest_OVERWRITE_ALWAYS {
+		for _, out := range files {
+			existing, err := s.readFile(ctx, out.fullPath)
+			exists := true
+			if os.IsNotExist(err) {
+				exists = false
+			} else if err != nil {
+				return fileErrToStatusErr(out.fullPath, err)
+			}
+			if err := checkOverwrite(policy, out, exists, existing); err != nil {
+				return err
+			}
+			if req.GetDryRun() {
+				oldName := out.file.GetWorkspaceRelativePath()
+				if !exists {
+					oldName = "/dev/null"
+				}
+				out.file.UnifiedDiff = unifieddiff.Diff(oldName, out.file.GetWorkspaceRelativePath(), string(existing), string(out.file.GetNewContents()))
+			}
+		}
+	}
+	if req.GetDryRun() {
+		return nil
+	}
+
+	for _, out := range files {
+		if err := s.writeFile(ctx, out.fullPath, out.file.GetNewContents()); err != nil {
+			return fileErrToStatusErr(out.fullPath, err)
+		}
+	}
+	return nil
+}
+
+// checkOverwrite returns an error if the policy does not allow replacing the
+// existing contents of a file with the new contents.
+func checkOverwrite(policy spb.GenerateCodeRequest_OverwritePolicy, out *outputFile, exists bool, existing []byte) error {
+	if !exists || bytes.Equal(existing, out.file.GetNewContents()) {
+		return nil
+	}
+	path := out.file.GetWorkspaceRelativePath()
+	switch policy {
+	case spb.GenerateCodeRequest_OVERWRITE_ALWAYS:
+		return nil
+	case spb.GenerateCodeRequest_OVERWRITE_NEVER:
+		return status.Errorf(codes.AlreadyExists, "file %q already exists and overwrite_policy is %s", path, policy)
+	case spb.GenerateCodeRequest_OVERWRITE_IF_UNCHANGED:
+		if out.generated && editedSinceGeneration(existing) {
+			return status.Errorf(codes.FailedPrecondition, "file %q was edited since it was generated and overwrite_policy is %s", path, policy)
+		}
+		return nil
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown overwrite_policy %v", policy)
+	}
+}
@@ -16,12 +16,25 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	pb "github.com/google/xtoproto/proto/recordtoproto"
 	rpb "github.com/google/xtoproto/proto/recordtoproto"
@@ -98,6 +111,82 @@ func Test_service_Infer(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "two inputs with the same header",
+			s:    unimplementedFileSysService,
+			req: &spb.InferRequest{
+				ExampleInputs: []*spb.InputFile{
+					makeInputFile([]byte("a,b\n1,thing\n")),
+					makeInputFile([]byte("a,b\n2,other\n")),
+				},
+				InputFormat:   spb.Format_CSV,
+				MessageName:   "MyMessage",
+				GoPackageName: "my_message_converter",
+				GoProtoImport: "path/to/my_message_go_proto",
+				PackageName:   "my_package",
+			},
+			want: &spb.InferResponse{
+				BestMappingCandidate: &spb.MappingSet{
+					TopLevelMapping: abMapping,
+				},
+			},
+		},
+		{
+			name: "two inputs with different headers",
+			s:    unimplementedFileSysService,
+			req: &spb.InferRequest{
+				ExampleInputs: []*spb.InputFile{
+					makeInputFile([]byte("a,b\n1,thing\n")),
+					makeInputFile([]byte("b\nother\n")),
+				},
+				InputFormat: spb.Format_CSV,
+				MessageName: "MyMessage",
+			},
+			wantErr: true,
+		},
+		{
+			name: "union of headers reports missing columns",
+			s:    unimplementedFileSysService,
+			req: &spb.InferRequest{
+				ExampleInputs: []*spb.InputFile{
+					makeInputFile([]byte("a,b\n1,thing\n")),
+					makeInputFile([]byte("b\nother\n")),
+				},
+				InputFormat:   spb.Format_CSV,
+				MessageName:   "MyMessage",
+				GoPackageName: "my_message_converter",
+				GoProtoImport: "path/to/my_message_go_proto",
+				PackageName:   "my_package",
+				HeaderMerge:   spb.InferRequest_UNION_HEADERS,
+			},
+			want: &spb.InferResponse{
+				BestMappingCandidate: &spb.MappingSet{
+					TopLevelMapping: abMapping,
+				},
+				Conflicts: []*spb.InputConflict{
+					{
+						InputIndex:  1,
+						InputName:   "example_inputs[1]",
+						ColumnName:  "a",
+						Kind:        spb.InputConflict_MISSING_COLUMN,
+						Description: `input does not have column "a"`,
+					},
+				},
+			},
+		},
+		{
+			name: "xml input is not supported yet",
+			s:    unimplementedFileSysService,
+			req: &spb.InferRequest{
+				ExampleInputs: []*spb.InputFile{
+					makeInputFile([]byte("<a><b>1</b></a>")),
+				},
+				InputFormat: spb.Format_XML,
+				MessageName: "MyMessage",
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -162,9 +251,15 @@ func Test_service_GenerateCode(t *testing.T) {
 					WorkspaceRelativePath: "code-path/proto/hello-world.proto",
 					NewContents:           []byte(""),
 				},
+				ProtoBuildFile: &spb.GenerateCodeResponse_File{
+					WorkspaceRelativePath: "code-path/proto/BUILD.bazel",
+				},
 				ConverterGoFile: &spb.GenerateCodeResponse_File{
 					WorkspaceRelativePath: "converters/my_message.go",
 				},
+				ConverterBuildFile: &spb.GenerateCodeResponse_File{
+					WorkspaceRelativePath: "converters/BUILD.bazel",
+				},
 			},
 			false,
 		},
@@ -190,3 +285,708 @@ func makeInputFile(content []byte) *spb.InputFile {
 	}
 	return f
 }
+
+func Test_service_GenerateCode_updateBuildRules(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		files     map[string]string
+		req       *spb.GenerateCodeRequest
+		wantFiles map[string]string
+	}{
+		{
+			name:  "new BUILD files",
+			files: map[string]string{},
+			req: &spb.GenerateCodeRequest{
+				Mapping: abMapping,
+				ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{
+					Directory:        "protos",
+					UpdateBuildRules: true,
+				},
+				Converter: &spb.GenerateCodeRequest_Converter{
+					Directory:        "conv",
+					GoImportPath:     "example.com/conv",
+					UpdateBuildRules: true,
+				},
+			},
+			wantFiles: map[string]string{
+				"/ws/protos/BUILD.bazel": `load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "my_message_proto",
+    srcs = ["my_message.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "my_message_go_proto",
+    importpath = "path/to/my_message_go_proto",
+    proto = ":my_message_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+				"/ws/conv/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "my_message_converter",
+    srcs = ["my_message.go"],
+    importpath = "example.com/conv",
+    deps = [
+        "//protos:my_message_go_proto",
+        "@org_golang_google_protobuf//proto:go_default_library",
+        "@xtoproto//csvcoder",
+        "@xtoproto//csvtoprotoparse",
+        "@xtoproto//protocp",
+        "@xtoproto//textcoder",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+			},
+		},
+		{
+			name: "existing BUILD file shared by proto and converter",
+			files: map[string]string{
+				"/ws/gen/BUILD": `# Top-level comment.
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# The converter.
+go_library(
+    name = "conv",
+    srcs = ["my_message.go"],
+    importpath = "example.com/old",
+)
+
+go_test(
+    name = "conv_test",
+    srcs = ["conv_test.go"],  # keep
+    embed = [":conv"],
+)
+`,
+			},
+			req: &spb.GenerateCodeRequest{
+				Mapping: abMapping,
+				ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{
+					Directory:        "gen",
+					UpdateBuildRules: true,
+				},
+				Converter: &spb.GenerateCodeRequest_Converter{
+					Directory:        "gen",
+					UpdateBuildRules: true,
+				},
+			},
+			wantFiles: map[string]string{
+				"/ws/gen/BUILD": `# Top-level comment.
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+# The converter.
+go_library(
+    name = "conv",
+    srcs = ["my_message.go"],
+    importpath = "example.com/old",
+    deps = [
+        ":my_message_go_proto",
+        "@org_golang_google_protobuf//proto:go_default_library",
+        "@xtoproto//csvcoder",
+        "@xtoproto//csvtoprotoparse",
+        "@xtoproto//protocp",
+        "@xtoproto//textcoder",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "conv_test",
+    srcs = ["conv_test.go"],  # keep
+    embed = [":conv"],
+)
+
+proto_library(
+    name = "my_message_proto",
+    srcs = ["my_message.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "my_message_go_proto",
+    importpath = "path/to/my_message_go_proto",
+    proto = ":my_message_proto",
+    visibility = ["//visibility:public"],
+)
+`,
+			},
+		},
+		{
+			name:  "custom xtoproto repository name",
+			files: map[string]string{},
+			req: &spb.GenerateCodeRequest{
+				Mapping: abMapping,
+				Converter: &spb.GenerateCodeRequest_Converter{
+					Directory:          "conv",
+					UpdateBuildRules:   true,
+					XtoprotoRepository: "com_github_google_xtoproto",
+				},
+			},
+			wantFiles: map[string]string{
+				"/ws/conv/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "my_message_converter",
+    srcs = ["my_message.go"],
+    deps = [
+        "@com_github_google_xtoproto//csvcoder",
+        "@com_github_google_xtoproto//csvtoprotoparse",
+        "@com_github_google_xtoproto//protocp",
+        "@com_github_google_xtoproto//textcoder",
+        "@org_golang_google_protobuf//proto:go_default_library",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string]string)
+			for k, v := range tt.files {
+				files[k] = v
+			}
+			s := &service{
+				defaultWorkspaceDir: "/ws",
+				readFile: func(ctx context.Context, path string) ([]byte, error) {
+					data, ok := files[path]
+					if !ok {
+						return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+					}
+					return []byte(data), nil
+				},
+				writeFile: func(ctx context.Context, path string, data []byte) error {
+					files[path] = string(data)
+					return nil
+				},
+			}
+			if _, err := s.GenerateCode(ctx, tt.req); err != nil {
+				t.Fatalf("GenerateCode() failed: %v", err)
+			}
+			for path, want := range tt.wantFiles {
+				if diff := cmp.Diff(want, files[path]); diff != "" {
+					t.Errorf("unexpected diff in %s (-want,+got): %s", path, diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_service_GenerateCode_overwritePolicy(t *testing.T) {
+	ctx := context.Background()
+	changedMapping := proto.Clone(abMapping).(*rpb.RecordProtoMapping)
+	changedMapping.ColumnToFieldMappings[1].ProtoName = "bee"
+	const goPath = "/ws/conv/my_message.go"
+
+	tests := []struct {
+		name    string
+		mapping *rpb.RecordProtoMapping
+		policy  spb.GenerateCodeRequest_OverwritePolicy
+		// editFiles modifies the files produced by the initial GenerateCode
+		// call before the call under test.
+		editFiles func(files map[string]string)
+		wantCode  codes.Code
+		// wantWritten is true if the call under test should write its output.
+		wantWritten bool
+	}{
+		{
+			name:        "always overwrites edited files",
+			mapping:     changedMapping,
+			policy:      spb.GenerateCodeRequest_OVERWRITE_ALWAYS,
+			editFiles:   func(files map[string]string) { files[goPath] += "// edited\n" },
+			wantWritten: true,
+		},
+		{
+			name:    "never allows unchanged output",
+			mapping: abMapping,
+			policy:  spb.GenerateCodeRequest_OVERWRITE_NEVER,
+		},
+		{
+			name:     "never refuses to change existing files",
+			mapping:  changedMapping,
+			policy:   spb.GenerateCodeRequest_OVERWRITE_NEVER,
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:        "if unchanged overwrites generated files",
+			mapping:     changedMapping,
+			policy:      spb.GenerateCodeRequest_OVERWRITE_IF_UNCHANGED,
+			wantWritten: true,
+		},
+		{
+			name:      "if unchanged refuses to overwrite edited files",
+			mapping:   changedMapping,
+			policy:    spb.GenerateCodeRequest_OVERWRITE_IF_UNCHANGED,
+			editFiles: func(files map[string]string) { files[goPath] += "// edited\n" },
+			wantCode:  codes.FailedPrecondition,
+		},
+		{
+			name:    "if unchanged refuses to overwrite files without a checksum",
+			mapping: changedMapping,
+			policy:  spb.GenerateCodeRequest_OVERWRITE_IF_UNCHANGED,
+			editFiles: func(files map[string]string) {
+				files[goPath] = "package my_message_converter\n"
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string]string)
+			s := &service{
+				defaultWorkspaceDir: "/ws",
+				readFile: func(ctx context.Context, path string) ([]byte, error) {
+					data, ok := files[path]
+					if !ok {
+						return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+					}
+					return []byte(data), nil
+				},
+				writeFile: func(ctx context.Context, path string, data []byte) error {
+					files[path] = string(data)
+					return nil
+				},
+			}
+			newRequest := func(mapping *rpb.RecordProtoMapping) *spb.GenerateCodeRequest {
+				return &spb.GenerateCodeRequest{
+					Mapping:         mapping,
+					ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{Directory: "protos"},
+					Converter:       &spb.GenerateCodeRequest_Converter{Directory: "conv"},
+				}
+			}
+			if _, err := s.GenerateCode(ctx, newRequest(abMapping)); err != nil {
+				t.Fatalf("initial GenerateCode() failed: %v", err)
+			}
+			if tt.editFiles != nil {
+				tt.editFiles(files)
+			}
+			before := make(map[string]string)
+			for k, v := range files {
+				before[k] = v
+			}
+
+			req := newRequest(tt.mapping)
+			req.OverwritePolicy = tt.policy
+			resp, err := s.GenerateCode(ctx, req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("GenerateCode() got error %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				if diff := cmp.Diff(before, files); diff != "" {
+					t.Errorf("GenerateCode() changed files despite failing (-before,+after): %s", diff)
+				}
+				return
+			}
+			if got := files[goPath] != before[goPath]; got != tt.wantWritten {
+				t.Errorf("GenerateCode() changed %s = %v, want %v", goPath, got, tt.wantWritten)
+			}
+			if got, want := files[goPath], string(resp.GetConverterGoFile().GetNewContents()); got != want {
+				t.Errorf("%s does not match the response contents", goPath)
+			}
+		})
+	}
+}
+
+func Test_service_GenerateCode_dryRun(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"/ws/protos/my_message.proto": "syntax = \"proto3\";\n",
+	}
+	s := &service{
+		defaultWorkspaceDir: "/ws",
+		readFile: func(ctx context.Context, path string) ([]byte, error) {
+			data, ok := files[path]
+			if !ok {
+				return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+			}
+			return []byte(data), nil
+		},
+		writeFile: func(ctx context.Context, path string, data []byte) error {
+			t.Errorf("dry run wrote %s", path)
+			return nil
+		},
+	}
+	resp, err := s.GenerateCode(ctx, &spb.GenerateCodeRequest{
+		Mapping:         abMapping,
+		ProtoDefinition: &spb.GenerateCodeRequest_ProtoDefinition{Directory: "protos", UpdateBuildRules: true},
+		Converter:       &spb.GenerateCodeRequest_Converter{Directory: "conv"},
+		DryRun:          true,
+	})
+	if err != nil {
+		t.Fatalf("GenerateCode() failed: %v", err)
+	}
+	for _, tt := range []struct {
+		file       *spb.GenerateCodeResponse_File
+		wantPrefix string
+	}{
+		{
+			resp.GetProtoFile(),
+			"--- protos/my_message.proto\n+++ protos/my_message.proto\n@@ -1 +1,",
+		},
+		{
+			resp.GetProtoBuildFile(),
+			"--- /dev/null\n+++ protos/BUILD.bazel\n@@ -0,0 +1,",
+		},
+		{
+			resp.GetConverterGoFile(),
+			"--- /dev/null\n+++ conv/my_message.go\n@@ -0,0 +1,",
+		},
+	} {
+		if got := tt.file.GetUnifiedDiff(); !strings.HasPrefix(got, tt.wantPrefix) {
+			t.Errorf("unified_diff of %s = %q, want prefix %q", tt.file.GetWorkspaceRelativePath(), got, tt.wantPrefix)
+		}
+	}
+}
+
+// fakeInferStream is an in-memory XToProtoService_InferStreamServer.
+type fakeInferStream struct {
+	grpc.ServerStream
+	requests  []*spb.InferStreamRequest
+	responses []*spb.InferStreamResponse
+}
+
+func (s *fakeInferStream) Recv() (*spb.InferStreamRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *fakeInferStream) Send(resp *spb.InferStreamResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func Test_service_InferStream(t *testing.T) {
+	options := &spb.InferStreamRequest{
+		Request: &spb.InferStreamRequest_Options{Options: &spb.InferRequest{
+			InputFormat:   spb.Format_CSV,
+			MessageName:   "MyMessage",
+			GoPackageName: "my_message_converter",
+			GoProtoImport: "path/to/my_message_go_proto",
+			PackageName:   "my_package",
+		}},
+	}
+	chunk := func(fileName, content string) *spb.InferStreamRequest {
+		return &spb.InferStreamRequest{
+			Request: &spb.InferStreamRequest_Chunk{Chunk: &spb.InputChunk{FileName: fileName, Content: []byte(content)}},
+		}
+	}
+	progress := func(files int32, bytes int64) *spb.InferStreamResponse {
+		return &spb.InferStreamResponse{Response: &spb.InferStreamResponse_Progress{Progress: &spb.InferProgress{
+			FilesReceived: files,
+			BytesReceived: bytes,
+		}}}
+	}
+	abResult := &spb.InferStreamResponse{Response: &spb.InferStreamResponse_Result{Result: &spb.InferResponse{
+		BestMappingCandidate: &spb.MappingSet{TopLevelMapping: abMapping},
+	}}}
+
+	tests := []struct {
+		name     string
+		requests []*spb.InferStreamRequest
+		// wantProgress is the number of progress responses expected. Their
+		// contents are only checked for the last one, lastProgress. The
+		// number of records processed is not checked because records are
+		// parsed concurrently.
+		wantProgress int
+		lastProgress *spb.InferStreamResponse
+		wantResult   *spb.InferStreamResponse
+		wantCode     codes.Code
+	}{
+		{
+			name: "one file split within rows",
+			requests: []*spb.InferStreamRequest{
+				options,
+				chunk("a.csv", "a,"),
+				chunk("a.csv", "b\n1,th"),
+				chunk("a.csv", "ing\n"),
+			},
+			wantProgress: 3,
+			lastProgress: progress(1, 12),
+			wantResult:   abResult,
+		},
+		{
+			name: "two files with the same header",
+			requests: []*spb.InferStreamRequest{
+				options,
+				chunk("a.csv", "a,b\n1,thing\n"),
+				chunk("b.csv", "a,b\n2,other\n"),
+			},
+			wantProgress: 2,
+			wantResult:   abResult,
+		},
+		{
+			name: "two files with different headers",
+			requests: []*spb.InferStreamRequest{
+				options,
+				chunk("a.csv", "a,b\n1,thing\n"),
+				chunk("b.csv", "a,c\n2,other\n"),
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "two files with different headers merged",
+			requests: []*spb.InferStreamRequest{
+				{Request: &spb.InferStreamRequest_Options{Options: &spb.InferRequest{
+					InputFormat:   spb.Format_CSV,
+					MessageName:   "MyMessage",
+					GoPackageName: "my_message_converter",
+					GoProtoImport: "path/to/my_message_go_proto",
+					PackageName:   "my_package",
+					HeaderMerge:   spb.InferRequest_UNION_HEADERS,
+				}}},
+				chunk("a.csv", "a\n1\n"),
+				chunk("b.csv", "b,a\nthing,2\n"),
+			},
+			wantProgress: 2,
+			wantResult: &spb.InferStreamResponse{Response: &spb.InferStreamResponse_Result{Result: &spb.InferResponse{
+				BestMappingCandidate: &spb.MappingSet{TopLevelMapping: abMapping},
+				Conflicts: []*spb.InputConflict{
+					{
+						InputIndex:  0,
+						InputName:   "a.csv",
+						ColumnName:  "b",
+						Kind:        spb.InputConflict_MISSING_COLUMN,
+						Description: `input does not have column "b"`,
+					},
+				},
+			}}},
+		},
+		{
+			name:     "missing options",
+			requests: []*spb.InferStreamRequest{chunk("a.csv", "a,b\n1,thing\n")},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "no chunks",
+			requests: []*spb.InferStreamRequest{options},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "options sent twice",
+			requests: []*spb.InferStreamRequest{
+				options,
+				chunk("a.csv", "a,b\n"),
+				options,
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "invalid csv",
+			requests: []*spb.InferStreamRequest{
+				options,
+				chunk("a.csv", "a,b\n1,\"thing\n"),
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{}
+			stream := &fakeInferStream{requests: tt.requests}
+			err := s.InferStream(stream)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("InferStream() got error %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if len(stream.responses) != tt.wantProgress+1 {
+				t.Fatalf("InferStream() sent %d responses, want %d progress responses and a result: %v", len(stream.responses), tt.wantProgress, stream.responses)
+			}
+			ignoreComments := protocmp.IgnoreFields(proto.MessageV2(&pb.ColumnToFieldMapping{}), "comment")
+			if tt.lastProgress != nil {
+				ignoreRecords := protocmp.IgnoreFields(&spb.InferProgress{}, "records_processed")
+				if diff := cmp.Diff(tt.lastProgress, stream.responses[tt.wantProgress-1], protocmp.Transform(), ignoreRecords); diff != "" {
+					t.Errorf("unexpected diff in last progress response (-want,+got): %s", diff)
+				}
+			}
+			if diff := cmp.Diff(tt.wantResult, stream.responses[tt.wantProgress], protocmp.Transform(), ignoreComments); diff != "" {
+				t.Errorf("unexpected diff in result (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func Test_service_Convert(t *testing.T) {
+	ctx := context.Background()
+	s := &service{}
+	input := &spb.InputFile{Spec: &spb.InputFile_InputContent{InputContent: []byte("a,b\n1,x\nnope,y\n3,z\n")}}
+	tests := []struct {
+		name       string
+		req        *spb.ConvertRequest
+		wantRows   []int64
+		wantValues []string
+		wantErrors []*spb.RecordError
+		wantCode   codes.Code
+	}{
+		{
+			name:       "any",
+			req:        &spb.ConvertRequest{Mapping: abMapping, Inputs: []*spb.InputFile{input}},
+			wantRows:   []int64{2, 4},
+			wantValues: []string{`a:1 b:"x"`, `a:3 b:"z"`},
+			wantErrors: []*spb.RecordError{{
+				Position:  &spb.RecordPosition{RowNumber: 3},
+				Problems:  []string{`column "a": strconv.ParseInt: parsing "nope": invalid syntax`},
+				RawRecord: "nope,y",
+			}},
+		},
+		{
+			name: "serialized with max records",
+			req: &spb.ConvertRequest{
+				Mapping:        abMapping,
+				Inputs:         []*spb.InputFile{input, input},
+				OutputEncoding: spb.ConvertRequest_SERIALIZED,
+				MaxRecords:     4,
+			},
+			wantRows:   []int64{2, 4, 2},
+			wantValues: []string{`a:1 b:"x"`, `a:3 b:"z"`, `a:1 b:"x"`},
+			wantErrors: []*spb.RecordError{
+				{Position: &spb.RecordPosition{RowNumber: 3}, Problems: []string{`column "a": strconv.ParseInt: parsing "nope": invalid syntax`}, RawRecord: "nope,y"},
+			},
+		},
+		{
+			name: "missing column",
+			req: &spb.ConvertRequest{
+				Mapping: abMapping,
+				Inputs:  []*spb.InputFile{{Spec: &spb.InputFile_InputContent{InputContent: []byte("a\n1\n")}}},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "missing mapping",
+			req:      &spb.ConvertRequest{Inputs: []*spb.InputFile{input}},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Convert(ctx, tt.req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("Convert() got error %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if got, want := resp.GetMessageType(), "my_package.MyMessage"; got != want {
+				t.Errorf("Convert() got message_type %q, want %q", got, want)
+			}
+			gotRows, gotValues := decodeRecords(t, resp)
+			if diff := cmp.Diff(tt.wantRows, gotRows); diff != "" {
+				t.Errorf("unexpected diff in record rows (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tt.wantValues, gotValues); diff != "" {
+				t.Errorf("unexpected diff in records (-want,+got): %s", diff)
+			}
+			if diff := cmp.Diff(tt.wantErrors, resp.GetErrors(), protocmp.Transform()); diff != "" {
+				t.Errorf("unexpected diff in errors (-want,+got): %s", diff)
+			}
+		})
+	}
+}
+
+func Test_service_Convert_contextErrors(t *testing.T) {
+	req := &spb.ConvertRequest{
+		Mapping: abMapping,
+		Inputs:  []*spb.InputFile{{Spec: &spb.InputFile_InputContent{InputContent: []byte("a,b\n1,x\n")}}},
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Unix(0, 0))
+	defer cancel()
+	for _, tt := range []struct {
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{canceled, codes.Canceled},
+		{expired, codes.DeadlineExceeded},
+	} {
+		_, err := (&service{}).Convert(tt.ctx, req)
+		if got := status.Code(err); got != tt.wantCode {
+			t.Errorf("Convert() got error %v, want code %v", err, tt.wantCode)
+		}
+	}
+}
+
+// decodeRecords returns the row numbers and field values of the records in a
+// ConvertResponse. The records are decoded using the proto_file of
+// the response.
+func decodeRecords(t *testing.T, resp *spb.ConvertResponse) ([]int64, []string) {
+	t.Helper()
+	fd, err := protodesc.NewFile(resp.GetProtoFile(), protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("invalid proto_file in response: %v", err)
+	}
+	md := fd.Messages().ByName(protoreflect.Name("MyMessage"))
+	var rows []int64
+	var values []string
+	for _, rec := range resp.GetRecords() {
+		data := rec.GetSerialized()
+		if a := rec.GetAny(); a != nil {
+			if got, want := a.GetTypeUrl(), "type.googleapis.com/"+resp.GetMessageType(); got != want {
+				t.Errorf("record has type_url %q, want %q", got, want)
+			}
+			data = a.GetValue()
+		}
+		msg := dynamicpb.NewMessage(md)
+		if err := protov2.Unmarshal(data, msg); err != nil {
+			t.Fatalf("failed to decode record: %v", err)
+		}
+		rows = append(rows, rec.GetPosition().GetRowNumber())
+		values = append(values, fmt.Sprintf("a:%d b:%q", msg.Get(md.Fields().ByName("a")).Int(), msg.Get(md.Fields().ByName("b")).String()))
+	}
+	return rows, values
+}
+
+// fakeConvertStream is an in-memory XToProtoService_ConvertStreamServer.
+type fakeConvertStream struct {
+	grpc.ServerStream
+	responses []*spb.ConvertResponse
+}
+
+func (s *fakeConvertStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeConvertStream) Send(resp *spb.ConvertResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func Test_service_ConvertStream(t *testing.T) {
+	csv := &strings.Builder{}
+	csv.WriteString("a,b\n")
+	for i := 0; i < convertStreamBatchSize*2+10; i++ {
+		fmt.Fprintf(csv, "%d,x\n", i)
+	}
+	stream := &fakeConvertStream{}
+	err := (&service{}).ConvertStream(&spb.ConvertRequest{
+		Mapping: abMapping,
+		Inputs:  []*spb.InputFile{{Spec: &spb.InputFile_InputContent{InputContent: []byte(csv.String())}}},
+	}, stream)
+	if err != nil {
+		t.Fatalf("ConvertStream() failed: %v", err)
+	}
+	var gotSizes []int
+	for _, resp := range stream.responses {
+		gotSizes = append(gotSizes, len(resp.GetRecords()))
+	}
+	if diff := cmp.Diff([]int{convertStreamBatchSize, convertStreamBatchSize, 10}, gotSizes); diff != "" {
+		t.Errorf("unexpected diff in batch sizes (-want,+got): %s", diff)
+	}
+	if stream.responses[0].GetMessageType() == "" || stream.responses[1].GetMessageType() != "" {
+		t.Errorf("message_type should only be set in the first response")
+	}
+}
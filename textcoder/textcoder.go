@@ -39,20 +39,54 @@
 // underlying type of a named type, this functionality is limited to types with
 // an underlying basic type (see https://github.com/golang/go/issues/39574).
 //
-// Currently the registry does not attempt to find registered coders based on
-// registered interface types. While coders may be registered for an interface
-// type, that coder only be returned then the reflect.Type of the interface is
-// used to obtain a Coder, Encoder, or Decoder; the coder will not be returned
-// if a coder is requested of a type that implements the registered interface.
-// This behavior is likely to change in the future.
+// Coders may also be registered for interface types. If a type T has no coder
+// of its own, the registry falls back to the coder of a registered interface
+// that T or *T implements. When a type implements several registered
+// interfaces, the most specific one (the one that embeds the methods of the
+// others) is used; if there is no single most specific interface, the lookup
+// fails with an *AmbiguousInterfaceError. Registered interfaces take precedence
+// over encoding.TextMarshaler, encoding.TextUnmarshaler and the underlying type.
 //
 // The types string, int, uint, float64, float32, uint8, int8, uint16, int16,
 // uint32, int32, uint64, and int64 have coders registered in the default
 // registry. This means these basic types can be encoded and decoded from
-// strings. Most of these types use the functions in strconv to parse and
-// fmt.Sprintf("%d" or "%f") to format. The bool coder is case insensitive and
-// accepts values like "true", "YeS", "on", and "1". These coders may be added
-// to other registries using RegisterBasicTypes().
+// strings. These types use the functions in strconv to parse and format; by
+// default, integers are written in base 10 and floats like fmt's "%f". The bool
+// coder is case insensitive and accepts values like "true", "YeS", "on", and
+// "1". These coders may be added to other registries using
+// RegisterBasicTypes().
+//
+// Pointer, slice and map types do not need to be registered: their coders are
+// derived from the coders of their element and key types, so a
+// []time.Duration or a map[string]*big.Int can be encoded and decoded without
+// further registration. The Context passed to these coders determines the
+// separators of slice elements and map entries.
+//
+// The default registry also has coders for time.Time, time.Duration, big.Int,
+// big.Float, net.IP and url.URL, which may be added to other registries using
+// RegisterStandardTypes(). The layout and location of time.Time values are
+// taken from the Context; see Context.WithTimeLayout.
+//
+// The default registry also has coders for generated protocol buffer enums and
+// for the Timestamp, Duration and wrapper messages, which may be added to other
+// registries using RegisterProtoTypes(). UnmarshalProtoField and
+// MarshalProtoField decode and encode the fields of any message, including
+// dynamic messages, using the registry of a Context.
+//
+// The builtin coders honor the formatting options of the Context they are
+// passed, which allows the same registry to read and write different dialects
+// of text. The options are set with the With* methods of Context: the format
+// and precision of floats (WithFloatFormat), the base of integers
+// (WithIntBase), grouping of digits (WithDigitGrouping), the words for true and
+// false (WithBoolValues), and the decimal and group separators of numbers
+// (WithLocale). For example, the following context writes 1234.5 as "1.234,50"
+// and true as "ja":
+//
+//	ctx := textcoder.NewContext().
+//		WithFloatFormat('f', 2).
+//		WithDigitGrouping(true).
+//		WithLocale(textcoder.Locale{DecimalSeparator: ",", GroupSeparator: "."}).
+//		WithBoolValues("ja", "nein")
 //
 // See the examples for usage.
 package textcoder
@@ -78,6 +112,8 @@ var (
 	defaultRegistry = func() *Registry {
 		r := NewRegistry()
 		must(RegisterBasicTypes(r))
+		must(RegisterStandardTypes(r))
+		must(RegisterProtoTypes(r))
 		return r
 	}()
 )
@@ -120,11 +156,14 @@ type coderMap = map[reflect.Type]*registryEntry
 // Registry object is fully supported.
 type Registry struct {
 	coders coderMap
+	// interfaces holds the registered interface types in the order they were
+	// first registered.
+	interfaces []reflect.Type
 }
 
 // NewRegistry returns a new object for registring text coders.
 func NewRegistry() *Registry {
-	return &Registry{make(coderMap)}
+	return &Registry{make(coderMap), nil}
 }
 
 // NewContext returns a new context that uses this registry for textual encoding
@@ -163,24 +202,60 @@ func (r *Registry) Register(t reflect.Type, encoder, decoder interface{}) error
 // 1. If the type is explicitly registered because of a previous call to
 // r.Register(t), r.GetDecoder(t) will return that decoder.
 //
-// 2. If the type implements encoding.TextUnmarshaler interface, GetDecoder(t)
+// 2. If the type is not an interface type and it or its pointer type
+// implements an interface type registered with r.Register, GetDecoder(t)
+// returns a decoder that dispatches to the decoder of that interface. If
+// several registered interfaces are implemented, the one that implements all
+// of the others is used. If there is no such interface, the lookup is
+// ambiguous and GetDecoder returns nil; use LookupDecoder to obtain the error.
+//
+// 3. If the type implements encoding.TextUnmarshaler interface, GetDecoder(t)
 // returns an decoder that dispatches to UnmarshalText.
 //
-// 3. If the type has an underlying type that is a basic type (bool, int,
+// 4. If the type has an underlying type that is a basic type (bool, int,
 // string, uint, uint8, float32, etc.), GetDecoder(t) will return a decoder for
 // t based on the underlying type.
+//
+// 5. If the type is a pointer, slice or map type, GetDecoder(t) returns a
+// decoder derived from the decoders of its element and key types. Empty text
+// is decoded as a nil pointer, slice or map. Slice elements are separated by
+// the ListSeparator of the Context, and map entries are written like
+// "k1=v1,k2=v2" using the MapSeparators of the Context.
 func (r *Registry) GetDecoder(t reflect.Type) Decoder {
+	dec, _ := r.LookupDecoder(t)
+	return dec
+}
+
+// LookupDecoder is like GetDecoder, but it returns an error if no decoder is
+// found. If the type implements more than one registered interface and none of
+// them is more specific than the others, the error is an
+// *AmbiguousInterfaceError.
+func (r *Registry) LookupDecoder(t reflect.Type) (Decoder, error) {
 	explicit := r.getExplicit(t)
 	if explicit != nil {
-		return explicit
+		return explicit, nil
+	}
+	ifaceCoder, err := r.getEntryForInterface(t)
+	if err != nil {
+		return nil, err
+	}
+	if ifaceCoder != nil {
+		return ifaceCoder, nil
 	}
 	if reflect.PtrTo(t).Implements(textUnmarshalerInterface) {
-		return &textEncodingCoder{}
+		return &textEncodingCoder{}, nil
 	}
-	if basicCoder, _ := r.getEntryForUnderlying(t); basicCoder != nil {
-		return r.GetCoder(t)
+	if basicCoder, underlyingType := r.getEntryForUnderlying(t); basicCoder != nil {
+		return underlyingCoder(t, basicCoder, underlyingType), nil
 	}
-	return nil
+	composite, err := r.getCompositeCoder(t, false, true)
+	if err != nil {
+		return nil, fmt.Errorf("no text decoder for type %v: %w", t, err)
+	}
+	if composite != nil {
+		return composite, nil
+	}
+	return nil, fmt.Errorf("no text decoder registered for type %v", t)
 }
 
 // GetEncoder returns the encoder for the given type or nil.
@@ -191,24 +266,57 @@ func (r *Registry) GetDecoder(t reflect.Type) Decoder {
 // 1. If the type is explicitly registered because of a previous call to
 // r.Register(t), r.GetEncoder(t) will return that encoder.
 //
-// 2. If the type implements encoding.TextMarshaler interface, GetEncoder(t)
+// 2. If the type is not an interface type and it or its pointer type
+// implements an interface type registered with r.Register, GetEncoder(t)
+// returns an encoder that dispatches to the encoder of that interface. If only
+// the pointer type implements the interface, the encoder is passed a pointer
+// to a copy of the value. Ambiguous lookups are handled as in GetDecoder.
+//
+// 3. If the type implements encoding.TextMarshaler interface, GetEncoder(t)
 // returns an encoder that dispatches to MarshalText.
 //
-// 3. If the type has an underlying type that is a basic type (bool, int,
+// 4. If the type has an underlying type that is a basic type (bool, int,
 // string, uint, uint8, float32, etc.), GetEncoder(t) will return a encoder for
 // t based on the underlying type.
+//
+// 5. If the type is a pointer, slice or map type, GetEncoder(t) returns an
+// encoder derived from the encoders of its element and key types, as
+// described by GetDecoder. Map entries are sorted by the text of their keys.
 func (r *Registry) GetEncoder(t reflect.Type) Encoder {
+	enc, _ := r.LookupEncoder(t)
+	return enc
+}
+
+// LookupEncoder is like GetEncoder, but it returns an error if no encoder is
+// found. If the type implements more than one registered interface and none of
+// them is more specific than the others, the error is an
+// *AmbiguousInterfaceError.
+func (r *Registry) LookupEncoder(t reflect.Type) (Encoder, error) {
 	explicit := r.getExplicit(t)
 	if explicit != nil {
-		return explicit
+		return explicit, nil
+	}
+	ifaceCoder, err := r.getEntryForInterface(t)
+	if err != nil {
+		return nil, err
+	}
+	if ifaceCoder != nil {
+		return ifaceCoder, nil
 	}
 	if reflect.PtrTo(t).Implements(textMarshalerInterface) {
-		return &textEncodingCoder{}
+		return &textEncodingCoder{}, nil
 	}
-	if basicCoder, _ := r.getEntryForUnderlying(t); basicCoder != nil {
-		return r.GetCoder(t)
+	if basicCoder, underlyingType := r.getEntryForUnderlying(t); basicCoder != nil {
+		return underlyingCoder(t, basicCoder, underlyingType), nil
 	}
-	return nil
+	composite, err := r.getCompositeCoder(t, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("no text encoder for type %v: %w", t, err)
+	}
+	if composite != nil {
+		return composite, nil
+	}
+	return nil, fmt.Errorf("no text encoder registered for type %v", t)
 }
 
 // GetCoder returns the Coder for the given type or nil if no coder can be
@@ -221,10 +329,14 @@ func (r *Registry) GetEncoder(t reflect.Type) Encoder {
 // 1. If the type is explicitly registered because of a previous call to
 // r.Register(t), r.GetCoder(t) will return that coder.
 //
-// 2. If the type implements encoding.TextUnmarshaler and encoding.TextMarshaler
+// 2. If T or *T implements a registered interface type, GetCoder(t) returns a
+// coder that dispatches to the coder of that interface, as described by
+// GetDecoder and GetEncoder.
+//
+// 3. If the type implements encoding.TextUnmarshaler and encoding.TextMarshaler
 // interface, GetCoder(t) returns a Decoder that dispatches to those methods.
 //
-// 3. If the type has an underlying type that is a basic type (bool, int,
+// 4. If the type has an underlying type that is a basic type (bool, int,
 // string, uint, uint8, float32, etc.), GetCoder(t) will return a coder for T
 // based on the underlying type. This allows types like `type distance float64`
 // to use float64's Coder. Due to limitations of Go's reflect package, which
@@ -232,45 +344,74 @@ func (r *Registry) GetEncoder(t reflect.Type) Encoder {
 // functionality is limited to types with an underlying basic type (see
 // https://github.com/golang/go/issues/39574).
 //
-// Currently the registry does not attempt to find registered coders based on
-// registered interface types. While coders may be registered for an interface
-// type, that coder only be returned then the reflect.Type of the interface is
-// used to obtain a Coder, Encoder, or Decoder; the coder will not be returned
-// if a coder is requested of a type that implements the registered interface.
-// This behavior is likely to change in the future.
+// 5. If the type is a pointer, slice or map type, GetCoder(t) returns a coder
+// derived from the coders of its element and key types, as described by
+// GetDecoder and GetEncoder.
 //
 // The types string, int, uint, float64, float32, uint8, int8, uint16, int16,
 // uint32, int32, uint64, and int64 have coders registered in the default
 // registry. This means these basic types can be encoded and decoded from
-// strings. Most of these types use the functions in strconv to parse and
-// fmt.Sprintf("%d" or "%f") to format. The bool coder is case insensitive and
-// accepts values like "true", "YeS", "on", and "1". These coders may be added
-// to other registries using RegisterBasicTypes().
+// strings. These types use the functions in strconv to parse and format; by
+// default, integers are written in base 10 and floats like fmt's "%f". The bool
+// coder is case insensitive and accepts values like "true", "YeS", "on", and
+// "1". These coders may be added to other registries using
+// RegisterBasicTypes().
+// The default registry also has coders for time.Time, time.Duration, big.Int,
+// big.Float, net.IP and url.URL, and for protocol buffer enums and well-known
+// types; see RegisterStandardTypes and RegisterProtoTypes.
 func (r *Registry) GetCoder(t reflect.Type) Coder {
+	c, _ := r.LookupCoder(t)
+	return c
+}
+
+// LookupCoder is like GetCoder, but it returns an error if no coder is found.
+// If the type implements more than one registered interface and none of them
+// is more specific than the others, the error is an *AmbiguousInterfaceError.
+func (r *Registry) LookupCoder(t reflect.Type) (Coder, error) {
 	explicit := r.getExplicit(t)
 	if explicit != nil {
-		return explicit
+		return explicit, nil
+	}
+	ifaceCoder, err := r.getEntryForInterface(t)
+	if err != nil {
+		return nil, err
+	}
+	if ifaceCoder != nil {
+		return ifaceCoder, nil
 	}
 	tPtr := reflect.PtrTo(t)
 	if tPtr.Implements(textUnmarshalerInterface) && tPtr.Implements(textMarshalerInterface) {
-		return &textEncodingCoder{}
+		return &textEncodingCoder{}, nil
 	}
 	if basicCoder, underlyingType := r.getEntryForUnderlying(t); basicCoder != nil {
-		return &registryEntry{
-			encode: func(ctx *Context, value T) (string, error) {
-				return basicCoder.EncodeText(ctx, reflect.ValueOf(value).Convert(underlyingType).Interface())
-			},
-			decode: func(ctx *Context, text string, dst T) error {
-				dstBasicReflect := reflect.New(underlyingType)
-				err := basicCoder.DecodeText(ctx, text, dstBasicReflect.Interface())
-				// roughly equivalent to *dst = T(*dstBasic) where dst is of
-				// type T and dstBasic is of type U, and T is convertible to U.
-				reflect.ValueOf(dst).Elem().Set(dstBasicReflect.Elem().Convert(t))
-				return err
-			},
-		}
+		return underlyingCoder(t, basicCoder, underlyingType), nil
+	}
+	composite, err := r.getCompositeCoder(t, true, true)
+	if err != nil {
+		return nil, fmt.Errorf("no text coder for type %v: %w", t, err)
+	}
+	if composite != nil {
+		return composite, nil
+	}
+	return nil, fmt.Errorf("no text coder registered for type %v", t)
+}
+
+// underlyingCoder returns a coder for t that converts values to and from
+// underlyingType and uses basicCoder to encode and decode them.
+func underlyingCoder(t reflect.Type, basicCoder *registryEntry, underlyingType reflect.Type) *registryEntry {
+	return &registryEntry{
+		encode: func(ctx *Context, value T) (string, error) {
+			return basicCoder.EncodeText(ctx, reflect.ValueOf(value).Convert(underlyingType).Interface())
+		},
+		decode: func(ctx *Context, text string, dst T) error {
+			dstBasicReflect := reflect.New(underlyingType)
+			err := basicCoder.DecodeText(ctx, text, dstBasicReflect.Interface())
+			// roughly equivalent to *dst = T(*dstBasic) where dst is of
+			// type T and dstBasic is of type U, and T is convertible to U.
+			reflect.ValueOf(dst).Elem().Set(dstBasicReflect.Elem().Convert(t))
+			return err
+		},
 	}
-	return nil
 }
 
 func (r *Registry) getExplicit(t reflect.Type) *registryEntry {
@@ -356,7 +497,7 @@ func (c *Context) Value(key interface{}) (interface{}, bool) {
 //
 // WithValue does not modify the receiver.
 func (c *Context) WithValue(key, value interface{}) *Context {
-	n := NewContext()
+	n := &Context{make(map[interface{}]interface{}, len(c.values)+1)}
 	for k, v := range c.values {
 		n.values[k] = v
 	}
@@ -377,11 +518,11 @@ func UnmarshalContext(ctx *Context, value string, dst T) error {
 	if t.Kind() != reflect.Ptr {
 		return fmt.Errorf("Unmarshal requires a pointer argument, got type %v", t)
 	}
-	dec := DefaultRegistry().GetDecoder(t.Elem())
-	if dec == nil {
-		return fmt.Errorf("no registered decoder for type %v", t)
+	dec, err := DefaultRegistry().LookupDecoder(t.Elem())
+	if err != nil {
+		return err
 	}
-	return dec.DecodeText(NewContext(), value, dst)
+	return dec.DecodeText(ctx, value, dst)
 }
 
 // MarshalContext attempts to encode the value into a string using one of the
@@ -390,9 +531,9 @@ func UnmarshalContext(ctx *Context, value string, dst T) error {
 // To use a registered coder of type T, value should be of type T.
 func MarshalContext(ctx *Context, value T) (string, error) {
 	t := reflect.TypeOf(value)
-	e := DefaultRegistry().GetEncoder(t)
-	if e == nil {
-		return "", fmt.Errorf("no encoder registered of type %v", t)
+	e, err := DefaultRegistry().LookupEncoder(t)
+	if err != nil {
+		return "", err
 	}
 	return e.EncodeText(ctx, value)
 }
@@ -454,16 +595,24 @@ func register(r *Registry, t reflect.Type, encoder, decoder interface{}) error {
 		return err
 	}
 
+	r.setFuncs(t, encFn, decFn)
+	return nil
+}
+
+// setFuncs sets the encoding and decoding functions registered for t.
+func (r *Registry) setFuncs(t reflect.Type, encode func(ctx *Context, value T) (string, error), decode func(ctx *Context, text string, dst T) error) {
 	// Rather than completely overwrite the entry, keep it so that existing
 	// references to it are not invalidated.
 	existing := r.getExplicit(t)
 	if existing == nil {
 		existing = &registryEntry{nil, nil}
 		r.setExplicit(t, existing)
+		if t.Kind() == reflect.Interface {
+			r.interfaces = append(r.interfaces, t)
+		}
 	}
-	existing.decode = decFn
-	existing.encode = encFn
-	return nil
+	existing.decode = decode
+	existing.encode = encode
 }
 
 func createEncoderFn(t reflect.Type, encoder reflect.Value) (func(_ *Context, value interface{}) (string, error), error) {
@@ -584,6 +733,11 @@ func (c *textEncodingCoder) DecodeText(_ *Context, text string, dst T) error {
 
 func (c *textEncodingCoder) EncodeText(_ *Context, value T) (string, error) {
 	m, ok := value.(encoding.TextMarshaler)
+	if !ok {
+		// MarshalText may be declared with a pointer receiver, in which case
+		// only a pointer to the value implements encoding.TextMarshaler.
+		m, ok = addressableCopy(value).(encoding.TextMarshaler)
+	}
 	if !ok {
 		return "", fmt.Errorf("value does not implement encoding.TextMarshaler: %v of type %v", value, reflect.TypeOf(value))
 	}
@@ -16,9 +16,11 @@ package textcoder
 
 import (
 	"fmt"
+	"math/big"
+	"net"
+	"net/url"
 	"reflect"
-	"strconv"
-	"strings"
+	"time"
 )
 
 var (
@@ -37,6 +39,10 @@ var (
 // RegisterBasicTypes attempts to register coders for the following types:
 // int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
 // string, float32, float64.
+//
+// The coders honor the formatting options of the Context: integers use its
+// IntBase, floats its FloatFormat, bools its BoolValues, and numbers are
+// written with the separators of its Locale and DigitGrouping.
 func RegisterBasicTypes(r *Registry) error {
 	var errors []error
 	putErr := func(err error) {
@@ -46,33 +52,31 @@ func RegisterBasicTypes(r *Registry) error {
 	// int, int8, int16, int32, int64
 	putErr(r.Register(
 		reflect.TypeOf(int(0)),
-		func(v int) (string, error) { return strconv.Itoa(v), nil },
-		func(s string, dst *int) error {
-			i, err := strconv.Atoi(s)
-			*dst = i
-			return err
+		func(ctx *Context, v int) (string, error) { return formatInt(ctx, int64(v)), nil },
+		func(ctx *Context, value string, dst *int) error {
+			i, err := parseInt(ctx, value, 0)
+			if err != nil {
+				return err
+			}
+			*dst = int(i)
+			return nil
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(bool(true)),
-		func(v bool) (string, error) {
-			if v {
-				return "true", nil
-			}
-			return "false", nil
-		},
-		func(s string, dst *bool) error {
-			got, ok := boolValues[strings.ToLower(s)]
-			if !ok {
-				return fmt.Errorf("unsupported bool value %q", s)
+		func(ctx *Context, v bool) (string, error) { return formatBool(ctx, v), nil },
+		func(ctx *Context, s string, dst *bool) error {
+			got, err := parseBool(ctx, s)
+			if err != nil {
+				return err
 			}
 			*dst = got
 			return nil
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(int8(0)),
-		func(v int8) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *int8) error {
-			i, err := strconv.ParseInt(value, 10, 8)
+		func(ctx *Context, v int8) (string, error) { return formatInt(ctx, int64(v)), nil },
+		func(ctx *Context, value string, dst *int8) error {
+			i, err := parseInt(ctx, value, 8)
 			if err != nil {
 				return err
 			}
@@ -81,9 +85,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(int32(0)),
-		func(v int32) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *int32) error {
-			i, err := strconv.ParseInt(value, 10, 32)
+		func(ctx *Context, v int32) (string, error) { return formatInt(ctx, int64(v)), nil },
+		func(ctx *Context, value string, dst *int32) error {
+			i, err := parseInt(ctx, value, 32)
 			if err != nil {
 				return err
 			}
@@ -92,9 +96,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(int16(0)),
-		func(v int16) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *int16) error {
-			i, err := strconv.ParseInt(value, 10, 16)
+		func(ctx *Context, v int16) (string, error) { return formatInt(ctx, int64(v)), nil },
+		func(ctx *Context, value string, dst *int16) error {
+			i, err := parseInt(ctx, value, 16)
 			if err != nil {
 				return err
 			}
@@ -103,9 +107,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(int64(0)),
-		func(v int64) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *int64) error {
-			i, err := strconv.ParseInt(value, 10, 64)
+		func(ctx *Context, v int64) (string, error) { return formatInt(ctx, int64(v)), nil },
+		func(ctx *Context, value string, dst *int64) error {
+			i, err := parseInt(ctx, value, 64)
 			if err != nil {
 				return err
 			}
@@ -116,9 +120,9 @@ func RegisterBasicTypes(r *Registry) error {
 	// uint, uint8, uint16, uint32, uint64
 	putErr(r.Register(
 		reflect.TypeOf(uint(0)),
-		func(v uint) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *uint) error {
-			i, err := strconv.ParseUint(value, 10, 64)
+		func(ctx *Context, v uint) (string, error) { return formatUint(ctx, uint64(v)), nil },
+		func(ctx *Context, value string, dst *uint) error {
+			i, err := parseUint(ctx, value, 0)
 			if err != nil {
 				return err
 			}
@@ -127,9 +131,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(uint8(0)),
-		func(v uint8) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *uint8) error {
-			i, err := strconv.ParseUint(value, 10, 8)
+		func(ctx *Context, v uint8) (string, error) { return formatUint(ctx, uint64(v)), nil },
+		func(ctx *Context, value string, dst *uint8) error {
+			i, err := parseUint(ctx, value, 8)
 			if err != nil {
 				return err
 			}
@@ -138,9 +142,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(uint32(0)),
-		func(v uint32) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *uint32) error {
-			i, err := strconv.ParseUint(value, 10, 32)
+		func(ctx *Context, v uint32) (string, error) { return formatUint(ctx, uint64(v)), nil },
+		func(ctx *Context, value string, dst *uint32) error {
+			i, err := parseUint(ctx, value, 32)
 			if err != nil {
 				return err
 			}
@@ -149,9 +153,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(uint16(0)),
-		func(v uint16) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *uint16) error {
-			i, err := strconv.ParseUint(value, 10, 16)
+		func(ctx *Context, v uint16) (string, error) { return formatUint(ctx, uint64(v)), nil },
+		func(ctx *Context, value string, dst *uint16) error {
+			i, err := parseUint(ctx, value, 16)
 			if err != nil {
 				return err
 			}
@@ -160,9 +164,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(uint64(0)),
-		func(v uint64) (string, error) { return fmt.Sprintf("%d", v), nil },
-		func(value string, dst *uint64) error {
-			i, err := strconv.ParseUint(value, 10, 64)
+		func(ctx *Context, v uint64) (string, error) { return formatUint(ctx, uint64(v)), nil },
+		func(ctx *Context, value string, dst *uint64) error {
+			i, err := parseUint(ctx, value, 64)
 			if err != nil {
 				return err
 			}
@@ -173,9 +177,9 @@ func RegisterBasicTypes(r *Registry) error {
 	// float32, float64
 	putErr(r.Register(
 		reflect.TypeOf(float64(0)),
-		func(v float64) (string, error) { return fmt.Sprintf("%f", v), nil },
-		func(value string, dst *float64) error {
-			f, err := strconv.ParseFloat(value, 64)
+		func(ctx *Context, v float64) (string, error) { return formatFloat(ctx, v, 64), nil },
+		func(ctx *Context, value string, dst *float64) error {
+			f, err := parseFloat(ctx, value, 64)
 			if err != nil {
 				return err
 			}
@@ -184,9 +188,9 @@ func RegisterBasicTypes(r *Registry) error {
 		}))
 	putErr(r.Register(
 		reflect.TypeOf(float32(0)),
-		func(v float32) (string, error) { return fmt.Sprintf("%f", v), nil },
-		func(value string, dst *float32) error {
-			f, err := strconv.ParseFloat(value, 32)
+		func(ctx *Context, v float32) (string, error) { return formatFloat(ctx, float64(v), 32), nil },
+		func(ctx *Context, value string, dst *float32) error {
+			f, err := parseFloat(ctx, value, 32)
 			if err != nil {
 				return err
 			}
@@ -209,3 +213,128 @@ func RegisterBasicTypes(r *Registry) error {
 	}
 	return nil
 }
+
+// RegisterStandardTypes attempts to register coders for the following types
+// of the standard library: time.Time, time.Duration, big.Int, big.Float,
+// net.IP and url.URL.
+//
+// time.Time values are formatted and parsed with the TimeLayout and
+// TimeLocation of the Context. time.Duration values use the format of
+// time.Duration.String and time.ParseDuration, like "1h2m0.5s". big.Int values
+// are integers in the IntBase of the Context, and big.Float values are
+// formatted with the 'g' format and the smallest number of digits that
+// represent the value exactly unless the Context has a FloatFormat. Both honor
+// the Locale and DigitGrouping of the Context. The empty string is decoded as a
+// nil net.IP.
+func RegisterStandardTypes(r *Registry) error {
+	var errors []error
+	putErr := func(err error) {
+		errors = append(errors, err)
+	}
+
+	putErr(r.Register(
+		reflect.TypeOf(time.Time{}),
+		func(ctx *Context, v time.Time) (string, error) { return formatTime(ctx, v), nil },
+		func(ctx *Context, value string, dst *time.Time) error {
+			t, err := parseTime(ctx, value)
+			if err != nil {
+				return err
+			}
+			*dst = t
+			return nil
+		}))
+	putErr(r.Register(
+		reflect.TypeOf(time.Duration(0)),
+		func(v time.Duration) (string, error) { return v.String(), nil },
+		func(value string, dst *time.Duration) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*dst = d
+			return nil
+		}))
+
+	// big.Int, big.Float
+	putErr(r.Register(
+		reflect.TypeOf(big.Int{}),
+		func(ctx *Context, v big.Int) (string, error) { return localizeInt(ctx, v.Text(ctx.IntBase())), nil },
+		func(ctx *Context, value string, dst *big.Int) error {
+			if _, ok := dst.SetString(delocalize(ctx, value, false), ctx.IntBase()); !ok {
+				return fmt.Errorf("invalid big.Int value %q", value)
+			}
+			return nil
+		}))
+	putErr(r.Register(
+		reflect.TypeOf(big.Float{}),
+		func(ctx *Context, v big.Float) (string, error) {
+			verb, precision := byte('g'), -1
+			if _, ok := ctx.lookup(floatFormatKey); ok {
+				verb, precision = ctx.FloatFormat()
+			}
+			return localizeFloat(ctx, v.Text(verb, precision)), nil
+		},
+		func(ctx *Context, value string, dst *big.Float) error {
+			if _, ok := dst.SetString(delocalize(ctx, value, true)); !ok {
+				return fmt.Errorf("invalid big.Float value %q", value)
+			}
+			return nil
+		}))
+
+	// net.IP, url.URL
+	putErr(r.Register(
+		reflect.TypeOf(net.IP{}),
+		func(v net.IP) (string, error) {
+			if len(v) == 0 {
+				return "", nil
+			}
+			return v.String(), nil
+		},
+		func(value string, dst *net.IP) error {
+			if value == "" {
+				*dst = nil
+				return nil
+			}
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q", value)
+			}
+			*dst = ip
+			return nil
+		}))
+	putErr(r.Register(
+		reflect.TypeOf(url.URL{}),
+		func(v url.URL) (string, error) { return v.String(), nil },
+		func(value string, dst *url.URL) error {
+			u, err := url.Parse(value)
+			if err != nil {
+				return err
+			}
+			*dst = *u
+			return nil
+		}))
+
+	for _, err := range errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatTime formats t with the TimeLayout and TimeLocation of ctx.
+func formatTime(ctx *Context, t time.Time) string {
+	if loc := ctx.TimeLocation(); loc != nil {
+		t = t.In(loc)
+	}
+	return t.Format(ctx.TimeLayout())
+}
+
+// parseTime parses text with the TimeLayout and TimeLocation of ctx.
+func parseTime(ctx *Context, text string) (time.Time, error) {
+	loc := ctx.TimeLocation()
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.ParseInLocation(ctx.TimeLayout(), text, loc)
+}
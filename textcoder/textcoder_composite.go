@@ -0,0 +1,207 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// elementCoder holds the encoder and decoder of the elements of a composite
+// type. Only the half that was requested is set.
+type elementCoder struct {
+	enc Encoder
+	dec Decoder
+}
+
+func (r *Registry) lookupElement(t reflect.Type, encode, decode bool) (elementCoder, error) {
+	var c elementCoder
+	var err error
+	if encode {
+		if c.enc, err = r.LookupEncoder(t); err != nil {
+			return c, err
+		}
+	}
+	if decode {
+		if c.dec, err = r.LookupDecoder(t); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// getCompositeCoder returns a coder for a pointer, slice or map type that is
+// derived from the coders of its element and key types, or nil if t is not
+// such a type. If encode is false, the returned coder cannot encode values,
+// and if decode is false, it cannot decode values.
+func (r *Registry) getCompositeCoder(t reflect.Type, encode, decode bool) (Coder, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := r.lookupElement(t.Elem(), encode, decode)
+		if err != nil {
+			return nil, err
+		}
+		return &pointerCoder{t, elem}, nil
+	case reflect.Slice:
+		elem, err := r.lookupElement(t.Elem(), encode, decode)
+		if err != nil {
+			return nil, err
+		}
+		return &sliceCoder{t, elem}, nil
+	case reflect.Map:
+		key, err := r.lookupElement(t.Key(), encode, decode)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := r.lookupElement(t.Elem(), encode, decode)
+		if err != nil {
+			return nil, err
+		}
+		return &mapCoder{t, key, elem}, nil
+	}
+	return nil, nil
+}
+
+// pointerCoder encodes a nil pointer as the empty string and other pointers as
+// the text of the value they point to.
+type pointerCoder struct {
+	t    reflect.Type
+	elem elementCoder
+}
+
+func (c *pointerCoder) EncodeText(ctx *Context, value T) (string, error) {
+	v := reflect.ValueOf(value)
+	if v.IsNil() {
+		return "", nil
+	}
+	return c.elem.enc.EncodeText(ctx, v.Elem().Interface())
+}
+
+func (c *pointerCoder) DecodeText(ctx *Context, text string, dst T) error {
+	dstValue := reflect.ValueOf(dst).Elem()
+	if text == "" {
+		dstValue.Set(reflect.Zero(c.t))
+		return nil
+	}
+	elem := reflect.New(c.t.Elem())
+	if err := c.elem.dec.DecodeText(ctx, text, elem.Interface()); err != nil {
+		return err
+	}
+	dstValue.Set(elem)
+	return nil
+}
+
+// sliceCoder encodes slices as the text of their elements separated by the
+// list separator of the context. The empty string is decoded as a nil slice.
+type sliceCoder struct {
+	t    reflect.Type
+	elem elementCoder
+}
+
+func (c *sliceCoder) EncodeText(ctx *Context, value T) (string, error) {
+	v := reflect.ValueOf(value)
+	var elems []string
+	for i := 0; i < v.Len(); i++ {
+		text, err := c.elem.enc.EncodeText(ctx, v.Index(i).Interface())
+		if err != nil {
+			return "", fmt.Errorf("error encoding element %d of %v: %w", i, c.t, err)
+		}
+		elems = append(elems, text)
+	}
+	return strings.Join(elems, ctx.ListSeparator()), nil
+}
+
+func (c *sliceCoder) DecodeText(ctx *Context, text string, dst T) error {
+	dstValue := reflect.ValueOf(dst).Elem()
+	if text == "" {
+		dstValue.Set(reflect.Zero(c.t))
+		return nil
+	}
+	elems := strings.Split(text, ctx.ListSeparator())
+	slice := reflect.MakeSlice(c.t, len(elems), len(elems))
+	for i, elem := range elems {
+		if err := c.elem.dec.DecodeText(ctx, elem, slice.Index(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("error decoding element %d of %v: %w", i, c.t, err)
+		}
+	}
+	dstValue.Set(slice)
+	return nil
+}
+
+// mapCoder encodes maps as a list of key-value pairs that are sorted by the
+// text of their keys, like "a=1,b=2". The separators are those of the context.
+// The empty string is decoded as a nil map.
+type mapCoder struct {
+	t         reflect.Type
+	key, elem elementCoder
+}
+
+func (c *mapCoder) EncodeText(ctx *Context, value T) (string, error) {
+	v := reflect.ValueOf(value)
+	pairSep, keyValueSep := ctx.MapSeparators()
+	type pair struct{ key, elem string }
+	var pairs []pair
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := c.key.enc.EncodeText(ctx, iter.Key().Interface())
+		if err != nil {
+			return "", fmt.Errorf("error encoding key of %v: %w", c.t, err)
+		}
+		elem, err := c.elem.enc.EncodeText(ctx, iter.Value().Interface())
+		if err != nil {
+			return "", fmt.Errorf("error encoding value of key %q of %v: %w", key, c.t, err)
+		}
+		pairs = append(pairs, pair{key, elem})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+	var texts []string
+	for _, p := range pairs {
+		texts = append(texts, p.key+keyValueSep+p.elem)
+	}
+	return strings.Join(texts, pairSep), nil
+}
+
+func (c *mapCoder) DecodeText(ctx *Context, text string, dst T) error {
+	dstValue := reflect.ValueOf(dst).Elem()
+	if text == "" {
+		dstValue.Set(reflect.Zero(c.t))
+		return nil
+	}
+	pairSep, keyValueSep := ctx.MapSeparators()
+	pairs := strings.Split(text, pairSep)
+	m := reflect.MakeMapWithSize(c.t, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, keyValueSep, 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("entry %q of %v has no %q separating its key and value", pair, c.t, keyValueSep)
+		}
+		key := reflect.New(c.t.Key())
+		if err := c.key.dec.DecodeText(ctx, parts[0], key.Interface()); err != nil {
+			return fmt.Errorf("error decoding key %q of %v: %w", parts[0], c.t, err)
+		}
+		if m.MapIndex(key.Elem()).IsValid() {
+			return fmt.Errorf("duplicate key %q in %v", parts[0], c.t)
+		}
+		elem := reflect.New(c.t.Elem())
+		if err := c.elem.dec.DecodeText(ctx, parts[1], elem.Interface()); err != nil {
+			return fmt.Errorf("error decoding value of key %q of %v: %w", parts[0], c.t, err)
+		}
+		m.SetMapIndex(key.Elem(), elem.Elem())
+	}
+	dstValue.Set(m)
+	return nil
+}
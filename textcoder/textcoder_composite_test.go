@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newStandardRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	if err := RegisterBasicTypes(r); err != nil {
+		t.Fatalf("RegisterBasicTypes failed: %v", err)
+	}
+	if err := RegisterStandardTypes(r); err != nil {
+		t.Fatalf("RegisterStandardTypes failed: %v", err)
+	}
+	return r
+}
+
+func TestCompositeCoders(t *testing.T) {
+	r := newStandardRegistry(t)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("error loading location: %v", err)
+	}
+	ctx := r.NewContext()
+	for _, tt := range []struct {
+		name string
+		ctx  *Context
+		// value is encoded, and the text is decoded into a new value of the
+		// same type.
+		value interface{}
+		text  string
+	}{
+		{"durations", ctx, []time.Duration{time.Second, 90 * time.Minute}, "1s,1h30m0s"},
+		{"empty slice", ctx, []int(nil), ""},
+		{"list separator", ctx.WithListSeparator(";"), []string{"a,b", "c"}, "a,b;c"},
+		{"nested slices", ctx.WithListSeparator(" "), [][]int{{1}, {2}}, "1 2"},
+		{"pointer", ctx, intPtr(3), "3"},
+		{"nil pointer", ctx, (*int)(nil), ""},
+		{"slice of pointers", ctx, []*int{intPtr(1), nil, intPtr(2)}, "1,,2"},
+		{"map", ctx, map[string]int{"b": 2, "a": 1}, "a=1,b=2"},
+		{"map separators", ctx.WithMapSeparators(";", ":"), map[int]bool{3: true, 1: false}, "1:false;3:true"},
+		{"nil map", ctx, map[string]int(nil), ""},
+		{"time", ctx, time.Date(2020, 7, 1, 12, 30, 0, 5, time.UTC), "2020-07-01T12:30:00.000000005Z"},
+		{"time layout", ctx.WithTimeLayout("2006-01-02 15:04"), time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC), "2020-07-01 12:30"},
+		{"time location", ctx.WithTimeLayout("2006-01-02 15:04").WithTimeLocation(newYork), time.Date(2020, 7, 1, 12, 30, 0, 0, newYork), "2020-07-01 12:30"},
+		{"big.Int", ctx, *bigInt("123456789012345678901234567890"), "123456789012345678901234567890"},
+		{"*big.Int", ctx, bigInt("-42"), "-42"},
+		{"big.Float", ctx, *big.NewFloat(1.5), "1.5"},
+		{"net.IP", ctx, net.ParseIP("192.168.0.1"), "192.168.0.1"},
+		{"empty net.IP", ctx, net.IP(nil), ""},
+		{"url.URL", ctx, mustParseURL(t, "https://example.com/a?b=c"), "https://example.com/a?b=c"},
+		{"map of IPs", ctx.WithMapSeparators(" ", "="), map[string]net.IP{"v6": net.ParseIP("::1")}, "v6=::1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := reflect.TypeOf(tt.value)
+			c, err := r.LookupCoder(typ)
+			if err != nil {
+				t.Fatalf("LookupCoder(%v) failed: %v", typ, err)
+			}
+			got, err := c.EncodeText(tt.ctx, tt.value)
+			if err != nil {
+				t.Fatalf("EncodeText(%v) failed: %v", tt.value, err)
+			}
+			if got != tt.text {
+				t.Errorf("EncodeText(%v) = %q, want %q", tt.value, got, tt.text)
+			}
+			dst := reflect.New(typ)
+			if err := c.DecodeText(tt.ctx, tt.text, dst.Interface()); err != nil {
+				t.Fatalf("DecodeText(%q) failed: %v", tt.text, err)
+			}
+			if diff := cmp.Diff(tt.value, dst.Elem().Interface(), cmp.Comparer(timeEqual), cmp.Comparer(bigIntEqual), cmp.Comparer(bigFloatEqual)); diff != "" {
+				t.Errorf("unexpected diff from DecodeText(%q) (-want, +got):\n%s", tt.text, diff)
+			}
+		})
+	}
+}
+
+func TestCompositeCoders_errors(t *testing.T) {
+	r := newStandardRegistry(t)
+	type noCoder struct{}
+	for _, tt := range []struct {
+		name    string
+		text    string
+		dst     interface{}
+		wantErr *regexp.Regexp
+	}{
+		{"no element decoder", "x", new([]noCoder), regexp.MustCompile(`no text decoder for type \[\]textcoder.noCoder: no text decoder registered for type textcoder.noCoder`)},
+		{"no key decoder", "x=1", new(map[noCoder]int), regexp.MustCompile(`no text decoder registered for type textcoder.noCoder`)},
+		{"bad element", "1,x", new([]int), regexp.MustCompile(`error decoding element 1 of \[\]int`)},
+		{"missing separator", "a=1,b", new(map[string]int), regexp.MustCompile(`entry "b" of map\[string\]int has no "=" separating its key and value`)},
+		{"duplicate key", "a=1,a=2", new(map[string]int), regexp.MustCompile(`duplicate key "a"`)},
+		{"bad value", "a=x", new(map[string]int), regexp.MustCompile(`error decoding value of key "a"`)},
+		{"bad duration", "7", new(time.Duration), regexp.MustCompile(`missing unit`)},
+		{"bad big.Int", "1.5", new(big.Int), regexp.MustCompile(`invalid big.Int value "1.5"`)},
+		{"bad IP", "300.0.0.1", new(net.IP), regexp.MustCompile(`invalid IP address`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := r.LookupDecoder(reflect.TypeOf(tt.dst).Elem())
+			if err == nil {
+				err = dec.DecodeText(r.NewContext(), tt.text, tt.dst)
+			}
+			checkErr(t, err, tt.wantErr, "DecodeText")
+		})
+	}
+}
+
+func bigInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 10)
+	return i
+}
+
+func mustParseURL(t *testing.T, s string) url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("error parsing URL: %v", err)
+	}
+	return *u
+}
+
+func timeEqual(a, b time.Time) bool     { return a.Equal(b) }
+func bigIntEqual(a, b big.Int) bool     { return a.Cmp(&b) == 0 }
+func bigFloatEqual(a, b big.Float) bool { return a.Cmp(&b) == 0 }
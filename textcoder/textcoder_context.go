@@ -0,0 +1,233 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"time"
+)
+
+// contextKey is the type of the keys of the formatting options stored in a
+// Context by the With* methods.
+type contextKey int
+
+const (
+	listSeparatorKey contextKey = iota
+	mapSeparatorsKey
+	timeLayoutKey
+	timeLocationKey
+	floatFormatKey
+	intBaseKey
+	digitGroupingKey
+	boolValuesKey
+	localeKey
+)
+
+const (
+	// DefaultListSeparator separates the elements of slices.
+	DefaultListSeparator = ","
+	// DefaultMapPairSeparator separates the entries of maps.
+	DefaultMapPairSeparator = ","
+	// DefaultMapKeyValueSeparator separates the key and value of a map entry.
+	DefaultMapKeyValueSeparator = "="
+	// DefaultTimeLayout is the layout used to encode and decode time.Time
+	// values.
+	DefaultTimeLayout = time.RFC3339Nano
+	// DefaultFloatVerb and DefaultFloatPrecision are the format of float32
+	// and float64 values, which is equivalent to fmt's "%f".
+	DefaultFloatVerb      = 'f'
+	DefaultFloatPrecision = 6
+	// DefaultIntBase is the base of integers.
+	DefaultIntBase = 10
+)
+
+// DefaultLocale is the locale used when no locale is set in a Context.
+var DefaultLocale = Locale{DecimalSeparator: ".", GroupSeparator: ","}
+
+// Locale holds the conventions for writing numbers in a language or region.
+type Locale struct {
+	// DecimalSeparator separates the integer and fractional parts of a
+	// number, such as "." in English and "," in German.
+	DecimalSeparator string
+	// GroupSeparator separates groups of three digits of the integer part of
+	// a number when digit grouping is enabled, such as "," in English and "."
+	// in German.
+	GroupSeparator string
+}
+
+type mapSeparators struct{ pair, keyValue string }
+
+type floatFormat struct {
+	verb      byte
+	precision int
+}
+
+type boolVocabulary struct{ trueText, falseText string }
+
+// value returns the value of an option set in the context, or def if the
+// option is not set. It may be called on a nil Context.
+func (c *Context) value(key contextKey, def interface{}) interface{} {
+	if v, ok := c.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// lookup returns the value of an option and whether it is set in the context.
+// It may be called on a nil Context.
+func (c *Context) lookup(key contextKey) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// WithListSeparator returns a new context in which the elements of slices are
+// separated by sep. The default separator is DefaultListSeparator.
+//
+// Elements are not quoted or escaped, so the separator should not appear in
+// the text of the elements.
+func (c *Context) WithListSeparator(sep string) *Context {
+	return c.WithValue(listSeparatorKey, sep)
+}
+
+// ListSeparator returns the separator of slice elements.
+func (c *Context) ListSeparator() string {
+	return c.value(listSeparatorKey, DefaultListSeparator).(string)
+}
+
+// WithMapSeparators returns a new context in which map entries are separated
+// by pairSep and the key and value of each entry are separated by keyValueSep.
+// The defaults are DefaultMapPairSeparator and DefaultMapKeyValueSeparator,
+// which produce text like "a=1,b=2".
+func (c *Context) WithMapSeparators(pairSep, keyValueSep string) *Context {
+	return c.WithValue(mapSeparatorsKey, mapSeparators{pairSep, keyValueSep})
+}
+
+// MapSeparators returns the separators of map entries and of the key and value
+// of each entry.
+func (c *Context) MapSeparators() (pairSep, keyValueSep string) {
+	seps := c.value(mapSeparatorsKey, mapSeparators{DefaultMapPairSeparator, DefaultMapKeyValueSeparator}).(mapSeparators)
+	return seps.pair, seps.keyValue
+}
+
+// WithTimeLayout returns a new context in which time.Time values are encoded
+// and decoded using the given layout. See the time package for the format of
+// layouts. The default layout is DefaultTimeLayout.
+func (c *Context) WithTimeLayout(layout string) *Context {
+	return c.WithValue(timeLayoutKey, layout)
+}
+
+// TimeLayout returns the layout of time.Time values.
+func (c *Context) TimeLayout() string {
+	return c.value(timeLayoutKey, DefaultTimeLayout).(string)
+}
+
+// WithTimeLocation returns a new context in which time.Time values are
+// converted to loc before they are encoded, and text without a time zone is
+// interpreted as a time in loc when it is decoded.
+//
+// If no location is set, times are encoded in their own location and decoded
+// as UTC times unless the text has a time zone.
+func (c *Context) WithTimeLocation(loc *time.Location) *Context {
+	return c.WithValue(timeLocationKey, loc)
+}
+
+// TimeLocation returns the location of time.Time values, or nil if none is
+// set.
+func (c *Context) TimeLocation() *time.Location {
+	return c.value(timeLocationKey, (*time.Location)(nil)).(*time.Location)
+}
+
+// WithFloatFormat returns a new context in which float32 and float64 values
+// are formatted like strconv.FormatFloat with the given verb ('f', 'e', 'g',
+// etc.) and precision. A precision of -1 uses the smallest number of digits
+// that represent the value exactly. The defaults are DefaultFloatVerb and
+// DefaultFloatPrecision. The format also applies to big.Float values, which
+// otherwise use the 'g' verb with a precision of -1.
+//
+// The format only affects encoding; any format accepted by strconv.ParseFloat
+// may be decoded.
+func (c *Context) WithFloatFormat(verb byte, precision int) *Context {
+	return c.WithValue(floatFormatKey, floatFormat{verb, precision})
+}
+
+// FloatFormat returns the verb and precision of floating point values.
+func (c *Context) FloatFormat() (verb byte, precision int) {
+	f := c.value(floatFormatKey, floatFormat{DefaultFloatVerb, DefaultFloatPrecision}).(floatFormat)
+	return f.verb, f.precision
+}
+
+// HasFloatFormat reports whether a float format was set with WithFloatFormat,
+// so that callers with a different default format can tell whether to apply
+// it.
+func (c *Context) HasFloatFormat() bool {
+	_, ok := c.lookup(floatFormatKey)
+	return ok
+}
+
+// WithIntBase returns a new context in which integers, including big.Int
+// values, are encoded and decoded in the given base, which must be between 2
+// and 36. Digits greater than 9 are written as lowercase letters and may be
+// decoded in either case. The default base is DefaultIntBase.
+func (c *Context) WithIntBase(base int) *Context {
+	return c.WithValue(intBaseKey, base)
+}
+
+// IntBase returns the base of integers.
+func (c *Context) IntBase() int {
+	return c.value(intBaseKey, DefaultIntBase).(int)
+}
+
+// WithDigitGrouping returns a new context in which the digits of the integer
+// part of numbers are written in groups of three separated by the
+// GroupSeparator of the Locale, like "1,234,567.5". When digit grouping is
+// enabled, group separators are removed from text before it is decoded, so
+// both "1,234" and "1234" decode to 1234. Digit grouping is disabled by
+// default.
+func (c *Context) WithDigitGrouping(enabled bool) *Context {
+	return c.WithValue(digitGroupingKey, enabled)
+}
+
+// DigitGrouping reports whether digit grouping is enabled.
+func (c *Context) DigitGrouping() bool {
+	return c.value(digitGroupingKey, false).(bool)
+}
+
+// WithBoolValues returns a new context in which true and false are encoded as
+// trueText and falseText, such as "yes" and "no". When decoding, these words
+// are matched case insensitively and take precedence over the words accepted
+// by default ("true", "false", "yes", "no", "on", "off", "1" and "0"). By
+// default, bools are encoded as "true" and "false".
+func (c *Context) WithBoolValues(trueText, falseText string) *Context {
+	return c.WithValue(boolValuesKey, boolVocabulary{trueText, falseText})
+}
+
+// BoolValues returns the text of true and false.
+func (c *Context) BoolValues() (trueText, falseText string) {
+	v := c.value(boolValuesKey, boolVocabulary{"true", "false"}).(boolVocabulary)
+	return v.trueText, v.falseText
+}
+
+// WithLocale returns a new context in which numbers are written with the
+// separators of the given locale. The default locale is DefaultLocale.
+func (c *Context) WithLocale(locale Locale) *Context {
+	return c.WithValue(localeKey, locale)
+}
+
+// Locale returns the locale of numbers.
+func (c *Context) Locale() Locale {
+	return c.value(localeKey, DefaultLocale).(Locale)
+}
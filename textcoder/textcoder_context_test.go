@@ -0,0 +1,136 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func ExampleContext_WithLocale() {
+	ctx := NewContext().
+		WithFloatFormat('f', 2).
+		WithDigitGrouping(true).
+		WithLocale(Locale{DecimalSeparator: ",", GroupSeparator: "."}).
+		WithBoolValues("ja", "nein")
+
+	f, _ := MarshalContext(ctx, 1234.5)
+	b, _ := MarshalContext(ctx, true)
+	fmt.Println(f, b)
+
+	var parsed float64
+	if err := UnmarshalContext(ctx, "9.876,5", &parsed); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	fmt.Println(parsed)
+	// Output:
+	// 1.234,50 ja
+	// 9876.5
+}
+
+func TestContextFormattingOptions(t *testing.T) {
+	r := newStandardRegistry(t)
+	ctx := r.NewContext()
+	german := Locale{DecimalSeparator: ",", GroupSeparator: "."}
+	for _, tt := range []struct {
+		name string
+		ctx  *Context
+		// value is encoded, and the text is decoded into a new value of the
+		// same type.
+		value interface{}
+		text  string
+	}{
+		{"default float", ctx, 1.5, "1.500000"},
+		{"float precision", ctx.WithFloatFormat('f', 1), 2.0, "2.0"},
+		{"float exponent", ctx.WithFloatFormat('e', 2), float32(12300), "1.23e+04"},
+		{"shortest float", ctx.WithFloatFormat('g', -1), 0.1, "0.1"},
+		{"float locale", ctx.WithLocale(german), -2.5, "-2,500000"},
+		{"grouped float", ctx.WithDigitGrouping(true).WithFloatFormat('f', 2), -1234567.5, "-1,234,567.50"},
+		{"grouped float locale", ctx.WithDigitGrouping(true).WithLocale(german).WithFloatFormat('f', -1), 1234.25, "1.234,25"},
+		{"hex int", ctx.WithIntBase(16), 255, "ff"},
+		{"binary int8", ctx.WithIntBase(2), int8(-5), "-101"},
+		{"grouped int", ctx.WithDigitGrouping(true), int64(-1234567), "-1,234,567"},
+		{"grouped uint locale", ctx.WithDigitGrouping(true).WithLocale(german), uint32(1000), "1.000"},
+		{"short grouped int", ctx.WithDigitGrouping(true), 123, "123"},
+		{"bool values", ctx.WithBoolValues("yes", "no"), false, "no"},
+		{"distance", ctx.WithFloatFormat('f', 0), distance(42), "42"},
+		{"slice of floats", ctx.WithLocale(german).WithListSeparator(";").WithFloatFormat('f', 1), []float64{1.5, 2}, "1,5;2,0"},
+		{"big.Int base", ctx.WithIntBase(36), *big.NewInt(36 * 36), "100"},
+		{"big.Int grouping", ctx.WithDigitGrouping(true), *big.NewInt(1234567), "1,234,567"},
+		{"big.Float default", ctx.WithLocale(german), *big.NewFloat(0.25), "0,25"},
+		{"big.Float format", ctx.WithFloatFormat('f', 3), *big.NewFloat(0.25), "0.250"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := reflect.TypeOf(tt.value)
+			c, err := r.LookupCoder(typ)
+			if err != nil {
+				t.Fatalf("LookupCoder(%v) failed: %v", typ, err)
+			}
+			got, err := c.EncodeText(tt.ctx, tt.value)
+			if err != nil {
+				t.Fatalf("EncodeText(%v) failed: %v", tt.value, err)
+			}
+			if got != tt.text {
+				t.Errorf("EncodeText(%v) = %q, want %q", tt.value, got, tt.text)
+			}
+			dst := reflect.New(typ)
+			if err := c.DecodeText(tt.ctx, tt.text, dst.Interface()); err != nil {
+				t.Fatalf("DecodeText(%q) failed: %v", tt.text, err)
+			}
+			if diff := cmp.Diff(tt.value, dst.Elem().Interface(), cmp.Comparer(bigIntEqual), cmp.Comparer(bigFloatEqual)); diff != "" {
+				t.Errorf("unexpected diff from DecodeText(%q) (-want, +got):\n%s", tt.text, diff)
+			}
+		})
+	}
+}
+
+func TestContextFormattingOptions_decode(t *testing.T) {
+	r := newStandardRegistry(t)
+	ctx := r.NewContext()
+	for _, tt := range []struct {
+		name      string
+		ctx       *Context
+		input     string
+		dst, want interface{}
+		wantErr   *regexp.Regexp
+	}{
+		{"ungrouped text with grouping", ctx.WithDigitGrouping(true), "1234", intPtr(0), intPtr(1234), nil},
+		{"grouped text without grouping", ctx, "1,234", intPtr(0), nil, regexp.MustCompile("invalid syntax")},
+		{"uppercase hex", ctx.WithIntBase(16), "FF", uint8Ptr(0), uint8Ptr(255), nil},
+		{"out of range in base", ctx.WithIntBase(16), "100", uint8Ptr(0), nil, regexp.MustCompile("value out of range")},
+		{"bool values are case insensitive", ctx.WithBoolValues("Y", "N"), "y", boolPtr(false), boolPtr(true), nil},
+		{"default bool values are still accepted", ctx.WithBoolValues("Y", "N"), "false", boolPtr(true), boolPtr(false), nil},
+		{"bool values take precedence", ctx.WithBoolValues("0", "1"), "1", boolPtr(true), boolPtr(false), nil},
+		{"unknown bool value", ctx.WithBoolValues("Y", "N"), "maybe", boolPtr(false), nil, regexp.MustCompile(`unsupported bool value "maybe"`)},
+		{"any float format", ctx.WithFloatFormat('f', 2), "1e3", float64Ptr(0), float64Ptr(1000), nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := r.LookupDecoder(reflect.TypeOf(tt.dst).Elem())
+			if err == nil {
+				err = dec.DecodeText(tt.ctx, tt.input, tt.dst)
+			}
+			checkErr(t, err, tt.wantErr, "DecodeText")
+			if diff := cmp.Diff(tt.want, tt.dst); diff != "" {
+				t.Errorf("unexpected diff from DecodeText (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AmbiguousInterfaceError is returned by the Lookup methods of Registry when a
+// type has no coder of its own and implements more than one registered
+// interface, none of which is more specific than the others.
+//
+// The ambiguity may be resolved by registering a coder for the type itself or
+// for an interface that embeds the conflicting interfaces.
+type AmbiguousInterfaceError struct {
+	// Type is the type whose coder was requested.
+	Type reflect.Type
+	// Interfaces are the conflicting registered interfaces in the order they
+	// were registered.
+	Interfaces []reflect.Type
+}
+
+func (e *AmbiguousInterfaceError) Error() string {
+	var names []string
+	for _, it := range e.Interfaces {
+		names = append(names, it.String())
+	}
+	return fmt.Sprintf("type %v implements multiple registered interfaces with no most specific interface: %s", e.Type, strings.Join(names, ", "))
+}
+
+// getEntryForInterface returns a coder for t that dispatches to the coder of
+// the most specific registered interface implemented by t or *t. It returns
+// nil if t does not implement any registered interface.
+func (r *Registry) getEntryForInterface(t reflect.Type) (*registryEntry, error) {
+	if t.Kind() == reflect.Interface {
+		return nil, nil
+	}
+	var candidates []reflect.Type
+	for _, it := range r.interfaces {
+		if t.Implements(it) || reflect.PtrTo(t).Implements(it) {
+			candidates = append(candidates, it)
+		}
+	}
+	var mostSpecific []reflect.Type
+	for _, it := range candidates {
+		if !hasMoreSpecificInterface(it, candidates) {
+			mostSpecific = append(mostSpecific, it)
+		}
+	}
+	switch len(mostSpecific) {
+	case 0:
+		return nil, nil
+	case 1:
+		return interfaceCoder(t, mostSpecific[0], r.getExplicit(mostSpecific[0])), nil
+	default:
+		return nil, &AmbiguousInterfaceError{t, mostSpecific}
+	}
+}
+
+// hasMoreSpecificInterface reports whether one of the candidates has a
+// strictly larger method set than it.
+func hasMoreSpecificInterface(it reflect.Type, candidates []reflect.Type) bool {
+	for _, other := range candidates {
+		if other != it && other.Implements(it) && !it.Implements(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceCoder returns a coder for t that uses the coder registered for the
+// interface type it.
+//
+// Values of t are encoded by passing them to the interface encoder, or a
+// pointer to a copy of them if only *t implements the interface.
+//
+// Decoding passes the interface decoder a pointer to an interface value that
+// holds the destination pointer, so decoders that modify the value in place
+// work as expected. If the decoder replaces the interface value with a value
+// of type t or *t, that value is stored in the destination.
+func interfaceCoder(t, it reflect.Type, entry *registryEntry) *registryEntry {
+	ptrImplements := reflect.PtrTo(t).Implements(it)
+	return &registryEntry{
+		encode: func(ctx *Context, value T) (string, error) {
+			if !t.Implements(it) {
+				value = addressableCopy(value)
+			}
+			return entry.encode(ctx, value)
+		},
+		decode: func(ctx *Context, text string, dst T) error {
+			dstValue := reflect.ValueOf(dst)
+			iface := reflect.New(it)
+			if ptrImplements {
+				iface.Elem().Set(dstValue)
+			} else {
+				// Only t implements the interface, which means t is a pointer
+				// type with methods.
+				if dstValue.Elem().IsNil() {
+					dstValue.Elem().Set(reflect.New(t.Elem()))
+				}
+				iface.Elem().Set(dstValue.Elem())
+			}
+			if err := entry.decode(ctx, text, iface.Interface()); err != nil {
+				return err
+			}
+			if iface.Elem().IsNil() {
+				return fmt.Errorf("decoder for %v set a nil value when decoding %v", it, t)
+			}
+			got := iface.Elem().Elem()
+			switch {
+			case got.Type() == t:
+				dstValue.Elem().Set(got)
+			case got.Type() == reflect.PtrTo(t) && !got.IsNil():
+				dstValue.Elem().Set(got.Elem())
+			default:
+				return fmt.Errorf("decoder for %v produced a value of type %v, want %v", it, got.Type(), t)
+			}
+			return nil
+		},
+	}
+}
+
+// addressableCopy returns a pointer to a copy of value, which allows methods
+// with pointer receivers to be called on it.
+func addressableCopy(value T) T {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface()
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type namer interface {
+	Name() string
+}
+
+type nameSetter interface {
+	namer
+	SetName(name string) error
+}
+
+type coded interface {
+	Code() string
+}
+
+type shape interface {
+	Sides() int
+}
+
+// color implements namer and *color implements nameSetter.
+type color struct{ name string }
+
+func (c color) Name() string { return c.name }
+
+func (c *color) SetName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty color name")
+	}
+	c.name = name
+	return nil
+}
+
+// label implements only namer.
+type label string
+
+func (l label) Name() string { return string(l) }
+
+// product implements namer and coded, neither of which embeds the other.
+type product struct{ name, code string }
+
+func (p product) Name() string { return p.name }
+func (p product) Code() string { return p.code }
+
+type square struct{ size int }
+
+func (square) Sides() int { return 4 }
+
+type triangle struct{}
+
+func (triangle) Sides() int { return 3 }
+
+// point has a MarshalText method with a pointer receiver.
+type point struct{ x, y int }
+
+func (p *point) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.x, p.y)), nil
+}
+
+func newInterfaceRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	mustRegisterIn := func(t2 reflect.Type, enc, dec interface{}) {
+		if err := r.Register(t2, enc, dec); err != nil {
+			t.Fatalf("Register(%v) failed: %v", t2, err)
+		}
+	}
+	mustRegisterIn(
+		reflect.TypeOf((*namer)(nil)).Elem(),
+		func(n namer) (string, error) { return "namer:" + n.Name(), nil },
+		func(string, *namer) error { return fmt.Errorf("namer values cannot be decoded") })
+	mustRegisterIn(
+		reflect.TypeOf((*nameSetter)(nil)).Elem(),
+		func(n nameSetter) (string, error) { return "nameSetter:" + n.Name(), nil },
+		func(s string, dst *nameSetter) error { return (*dst).SetName(strings.TrimPrefix(s, "nameSetter:")) })
+	mustRegisterIn(
+		reflect.TypeOf((*coded)(nil)).Elem(),
+		func(c coded) (string, error) { return c.Code(), nil },
+		func(string, *coded) error { return fmt.Errorf("coded values cannot be decoded") })
+	mustRegisterIn(
+		reflect.TypeOf((*shape)(nil)).Elem(),
+		func(s shape) (string, error) { return strconv.Itoa(s.Sides()), nil },
+		func(s string, dst *shape) error {
+			size, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			*dst = square{size}
+			return nil
+		})
+	return r
+}
+
+func TestRegistry_interfaceEncoders(t *testing.T) {
+	r := newInterfaceRegistry(t)
+	if err := r.Register(
+		reflect.TypeOf(triangle{}),
+		func(triangle) (string, error) { return "triangle", nil },
+		func(string, *triangle) error { return nil }); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	for _, tt := range []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{"pointer method set is more specific", color{"red"}, "nameSetter:red", nil},
+		{"pointer type", &color{"blue"}, "nameSetter:blue", nil},
+		{"value method set", label("x"), "namer:x", nil},
+		{"explicit registration wins", triangle{}, "triangle", nil},
+		{"single interface", square{2}, "4", nil},
+		{"pointer receiver MarshalText", point{1, 2}, "1,2", nil},
+		{"ambiguous", product{"p", "c"}, "", regexp.MustCompile(`textcoder.product implements multiple registered interfaces .*: textcoder.namer, textcoder.coded$`)},
+		{"no coder", struct{}{}, "", regexp.MustCompile(`no text encoder registered for type struct {}`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := r.LookupEncoder(reflect.TypeOf(tt.value))
+			checkErr(t, err, tt.wantErr, "LookupEncoder")
+			got, err := enc.EncodeText(r.NewContext(), tt.value)
+			if err != nil {
+				t.Fatalf("EncodeText(%v) failed: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("EncodeText(%v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_interfaceDecoders(t *testing.T) {
+	r := newInterfaceRegistry(t)
+	for _, tt := range []struct {
+		name      string
+		input     string
+		dst, want interface{}
+		wantErr   *regexp.Regexp
+	}{
+		{"modified in place", "nameSetter:green", &color{"red"}, &color{"green"}, nil},
+		{"nil pointer is allocated", "green", new(*color), colorPtrPtr(&color{"green"}), nil},
+		{"decoder error", "", &color{"red"}, nil, regexp.MustCompile(`empty color name`)},
+		{"value replaced", "3", &square{}, &square{3}, nil},
+		{"value of another type", "3", &triangle{}, nil, regexp.MustCompile(`decoder for textcoder.shape produced a value of type textcoder.square, want textcoder.triangle`)},
+		{"ambiguous", "p", &product{}, nil, regexp.MustCompile(`implements multiple registered interfaces`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := r.LookupDecoder(reflect.TypeOf(tt.dst).Elem())
+			if err == nil {
+				err = dec.DecodeText(r.NewContext(), tt.input, tt.dst)
+			}
+			checkErr(t, err, tt.wantErr, "DecodeText")
+			if diff := cmp.Diff(tt.want, tt.dst, cmp.AllowUnexported(color{}, square{})); diff != "" {
+				t.Errorf("unexpected diff from DecodeText (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegistry_ambiguousInterface(t *testing.T) {
+	r := newInterfaceRegistry(t)
+	productType := reflect.TypeOf(product{})
+	if got := r.GetCoder(productType); got != nil {
+		t.Errorf("GetCoder(%v) = %v, want nil", productType, got)
+	}
+	_, err := r.LookupCoder(productType)
+	var ambiguous *AmbiguousInterfaceError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("LookupCoder(%v) returned error %v, want an *AmbiguousInterfaceError", productType, err)
+	}
+	if got, want := ambiguous.Interfaces, []reflect.Type{reflect.TypeOf((*namer)(nil)).Elem(), reflect.TypeOf((*coded)(nil)).Elem()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got conflicting interfaces %v, want %v", got, want)
+	}
+
+	// Registering an interface that embeds both resolves the ambiguity.
+	type namedCode interface {
+		namer
+		coded
+	}
+	if err := r.Register(
+		reflect.TypeOf((*namedCode)(nil)).Elem(),
+		func(v namedCode) (string, error) { return v.Name() + "/" + v.Code(), nil },
+		func(string, *namedCode) error { return nil }); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	got, err := r.GetEncoder(productType).EncodeText(r.NewContext(), product{"p", "c"})
+	if err != nil || got != "p/c" {
+		t.Errorf("EncodeText() = (%q, %v), want (%q, nil)", got, err, "p/c")
+	}
+}
+
+func colorPtrPtr(c *color) **color { return &c }
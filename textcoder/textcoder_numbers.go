@@ -0,0 +1,141 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file contains the helpers the builtin coders use to format and parse
+// numbers and bools according to the options of a Context.
+
+func formatInt(ctx *Context, i int64) string {
+	return localizeInt(ctx, strconv.FormatInt(i, ctx.IntBase()))
+}
+
+func formatUint(ctx *Context, u uint64) string {
+	return localizeInt(ctx, strconv.FormatUint(u, ctx.IntBase()))
+}
+
+func parseInt(ctx *Context, text string, bitSize int) (int64, error) {
+	return strconv.ParseInt(delocalize(ctx, text, false), ctx.IntBase(), bitSize)
+}
+
+func parseUint(ctx *Context, text string, bitSize int) (uint64, error) {
+	return strconv.ParseUint(delocalize(ctx, text, false), ctx.IntBase(), bitSize)
+}
+
+func formatFloat(ctx *Context, f float64, bitSize int) string {
+	verb, precision := ctx.FloatFormat()
+	return localizeFloat(ctx, strconv.FormatFloat(f, verb, precision, bitSize))
+}
+
+func parseFloat(ctx *Context, text string, bitSize int) (float64, error) {
+	return strconv.ParseFloat(delocalize(ctx, text, true), bitSize)
+}
+
+func formatBool(ctx *Context, v bool) string {
+	trueText, falseText := ctx.BoolValues()
+	if v {
+		return trueText
+	}
+	return falseText
+}
+
+func parseBool(ctx *Context, text string) (bool, error) {
+	trueText, falseText := ctx.BoolValues()
+	switch {
+	case strings.EqualFold(text, trueText):
+		return true, nil
+	case strings.EqualFold(text, falseText):
+		return false, nil
+	}
+	got, ok := boolValues[strings.ToLower(text)]
+	if !ok {
+		return false, fmt.Errorf("unsupported bool value %q", text)
+	}
+	return got, nil
+}
+
+// localizeInt groups the digits of an integer formatted by strconv if digit
+// grouping is enabled.
+func localizeInt(ctx *Context, s string) string {
+	if !ctx.DigitGrouping() {
+		return s
+	}
+	sign, digits := splitSign(s)
+	return sign + groupDigits(digits, ctx.Locale().GroupSeparator)
+}
+
+// localizeFloat replaces the decimal separator of a number formatted by
+// strconv with that of the locale and groups the digits of its integer part if
+// digit grouping is enabled.
+func localizeFloat(ctx *Context, s string) string {
+	locale := ctx.Locale()
+	sign, rest := splitSign(s)
+	end := 0
+	for end < len(rest) && '0' <= rest[end] && rest[end] <= '9' {
+		end++
+	}
+	intPart, fracPart := rest[:end], rest[end:]
+	if ctx.DigitGrouping() {
+		intPart = groupDigits(intPart, locale.GroupSeparator)
+	}
+	if strings.HasPrefix(fracPart, ".") {
+		fracPart = locale.DecimalSeparator + fracPart[1:]
+	}
+	return sign + intPart + fracPart
+}
+
+// delocalize undoes localizeInt or localizeFloat so that text may be parsed by
+// strconv.
+func delocalize(ctx *Context, text string, decimal bool) string {
+	locale := ctx.Locale()
+	if ctx.DigitGrouping() && locale.GroupSeparator != "" {
+		text = strings.ReplaceAll(text, locale.GroupSeparator, "")
+	}
+	if decimal && locale.DecimalSeparator != "" && locale.DecimalSeparator != "." {
+		text = strings.Replace(text, locale.DecimalSeparator, ".", 1)
+	}
+	return text
+}
+
+func splitSign(s string) (sign, rest string) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		return s[:1], s[1:]
+	}
+	return "", s
+}
+
+// groupDigits inserts sep between groups of three digits, starting from the
+// right.
+func groupDigits(digits, sep string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	first := len(digits) % 3
+	if first == 0 {
+		first = 3
+	}
+	var b strings.Builder
+	b.WriteString(digits[:first])
+	for i := first; i < len(digits); i += 3 {
+		b.WriteString(sep)
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,494 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	timestampName protoreflect.FullName = "google.protobuf.Timestamp"
+	durationName  protoreflect.FullName = "google.protobuf.Duration"
+)
+
+// wrapperNames are the full names of the wrapper messages in
+// google/protobuf/wrappers.proto, which hold a single field named "value".
+var wrapperNames = map[protoreflect.FullName]bool{
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+var protoEnumInterface = func() reflect.Type {
+	var i protoreflect.Enum
+	ptr := &i
+	return reflect.TypeOf(ptr).Elem()
+}()
+
+// RegisterProtoTypes attempts to register coders for protocol buffer types:
+//
+// 1. Generated enum types, which implement protoreflect.Enum, are encoded as
+// the name of the enum value, or as its number if the value has no name. They
+// may be decoded from a name or a number.
+//
+// 2. *timestamppb.Timestamp values are formatted and parsed like time.Time
+// values, so by default they are written in RFC 3339 format.
+//
+// 3. *durationpb.Duration values are written like time.Duration values, such as
+// "1h2m0.5s".
+//
+// 4. The wrapper messages of wrapperspb are written as the value they wrap.
+// Bytes are written in standard base64 encoding.
+//
+// A nil message is encoded as the empty string, and the empty string is
+// decoded as a nil message.
+//
+// Fields of any message may be encoded and decoded with MarshalProtoField and
+// UnmarshalProtoField.
+func RegisterProtoTypes(r *Registry) error {
+	if err := r.Register(
+		protoEnumInterface,
+		func(ctx *Context, v protoreflect.Enum) (string, error) {
+			if v == nil {
+				return "", fmt.Errorf("cannot encode a nil protoreflect.Enum")
+			}
+			return encodeEnum(ctx, v.Descriptor(), v.Number())
+		},
+		func(ctx *Context, value string, dst *protoreflect.Enum) error {
+			// The enum type is only known from the value in dst.
+			if *dst == nil {
+				return fmt.Errorf("cannot decode %q into a nil protoreflect.Enum of unknown type", value)
+			}
+			n, err := decodeEnum(ctx, (*dst).Descriptor(), value)
+			if err != nil {
+				return err
+			}
+			*dst = (*dst).Type().New(n)
+			return nil
+		}); err != nil {
+		return err
+	}
+	for _, msg := range []proto.Message{
+		&timestamppb.Timestamp{},
+		&durationpb.Duration{},
+		&wrapperspb.DoubleValue{},
+		&wrapperspb.FloatValue{},
+		&wrapperspb.Int64Value{},
+		&wrapperspb.UInt64Value{},
+		&wrapperspb.Int32Value{},
+		&wrapperspb.UInt32Value{},
+		&wrapperspb.BoolValue{},
+		&wrapperspb.StringValue{},
+		&wrapperspb.BytesValue{},
+	} {
+		registerWellKnownMessage(r, reflect.TypeOf(msg))
+	}
+	return nil
+}
+
+// registerWellKnownMessage registers a coder for t, which is a pointer to a
+// generated well-known message type.
+func registerWellKnownMessage(r *Registry, t reflect.Type) {
+	r.setFuncs(
+		t,
+		func(ctx *Context, value T) (string, error) {
+			m := value.(proto.Message).ProtoReflect()
+			if !m.IsValid() {
+				return "", nil
+			}
+			text, _, err := encodeWellKnown(ctx, m)
+			return text, err
+		},
+		func(ctx *Context, text string, dst T) error {
+			dstValue := reflect.ValueOf(dst).Elem()
+			if text == "" {
+				dstValue.Set(reflect.Zero(t))
+				return nil
+			}
+			m := reflect.New(t.Elem())
+			if _, err := decodeWellKnown(ctx, text, m.Interface().(proto.Message).ProtoReflect()); err != nil {
+				return err
+			}
+			dstValue.Set(m)
+			return nil
+		})
+}
+
+// UnmarshalProtoField decodes text and sets field fd of msg to the result.
+//
+// Scalar fields are decoded with the coders of ctx's registry for the
+// corresponding Go types (bool, int32, float64, etc.), so they honor the
+// formatting options of ctx. Enum fields may be given as the name or the number
+// of an enum value, and bytes fields in standard base64 encoding. Message fields
+// may be Timestamp, Duration and wrapper messages, as described by
+// RegisterProtoTypes, or messages whose generated Go type has a coder in ctx's
+// registry. The empty string clears a message field.
+//
+// Repeated fields are decoded as a list separated by the ListSeparator of ctx
+// and map fields like Go maps (see Registry.GetDecoder). Their previous
+// contents are replaced, and the empty string clears them.
+//
+// If ctx is nil, a new context is used. msg is not modified if an error is
+// returned.
+func UnmarshalProtoField(ctx *Context, msg protoreflect.Message, fd protoreflect.FieldDescriptor, text string) error {
+	if ctx == nil {
+		ctx = NewContext()
+	}
+	switch {
+	case fd.IsMap():
+		if text == "" {
+			msg.Clear(fd)
+			return nil
+		}
+		m := msg.NewField(fd).Map()
+		pairSep, keyValueSep := ctx.MapSeparators()
+		for _, pair := range strings.Split(text, pairSep) {
+			parts := strings.SplitN(pair, keyValueSep, 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("entry %q of field %s has no %q separating its key and value", pair, fd.FullName(), keyValueSep)
+			}
+			key, err := decodeProtoValue(ctx, fd.MapKey(), parts[0], nil)
+			if err != nil {
+				return fmt.Errorf("error decoding key %q of field %s: %w", parts[0], fd.FullName(), err)
+			}
+			if m.Has(key.MapKey()) {
+				return fmt.Errorf("duplicate key %q in field %s", parts[0], fd.FullName())
+			}
+			value, err := decodeProtoValue(ctx, fd.MapValue(), parts[1], func() protoreflect.Message { return m.NewValue().Message() })
+			if err != nil {
+				return fmt.Errorf("error decoding value of key %q of field %s: %w", parts[0], fd.FullName(), err)
+			}
+			m.Set(key.MapKey(), value)
+		}
+		msg.Set(fd, protoreflect.ValueOfMap(m))
+	case fd.IsList():
+		if text == "" {
+			msg.Clear(fd)
+			return nil
+		}
+		list := msg.NewField(fd).List()
+		for i, elem := range strings.Split(text, ctx.ListSeparator()) {
+			value, err := decodeProtoValue(ctx, fd, elem, func() protoreflect.Message { return list.NewElement().Message() })
+			if err != nil {
+				return fmt.Errorf("error decoding element %d of field %s: %w", i, fd.FullName(), err)
+			}
+			list.Append(value)
+		}
+		msg.Set(fd, protoreflect.ValueOfList(list))
+	default:
+		if text == "" && fd.Message() != nil {
+			msg.Clear(fd)
+			return nil
+		}
+		value, err := decodeProtoValue(ctx, fd, text, func() protoreflect.Message { return msg.NewField(fd).Message() })
+		if err != nil {
+			return fmt.Errorf("error decoding field %s: %w", fd.FullName(), err)
+		}
+		msg.Set(fd, value)
+	}
+	return nil
+}
+
+// MarshalProtoField returns the text of field fd of msg in the format decoded
+// by UnmarshalProtoField. Unset message fields and empty repeated and map
+// fields are encoded as the empty string. Map entries are sorted by the text of
+// their keys. Unless ctx sets a float format, float and double values are
+// written with the fewest digits that decode to the same value.
+//
+// If ctx is nil, a new context is used.
+func MarshalProtoField(ctx *Context, msg protoreflect.Message, fd protoreflect.FieldDescriptor) (string, error) {
+	if ctx == nil {
+		ctx = NewContext()
+	}
+	switch {
+	case fd.IsMap():
+		type pair struct{ key, value string }
+		var pairs []pair
+		var err error
+		msg.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			var p pair
+			if p.key, err = encodeProtoValue(ctx, fd.MapKey(), k.Value()); err != nil {
+				err = fmt.Errorf("error encoding key of field %s: %w", fd.FullName(), err)
+				return false
+			}
+			if p.value, err = encodeProtoValue(ctx, fd.MapValue(), v); err != nil {
+				err = fmt.Errorf("error encoding value of key %q of field %s: %w", p.key, fd.FullName(), err)
+				return false
+			}
+			pairs = append(pairs, p)
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+		pairSep, keyValueSep := ctx.MapSeparators()
+		var texts []string
+		for _, p := range pairs {
+			texts = append(texts, p.key+keyValueSep+p.value)
+		}
+		return strings.Join(texts, pairSep), nil
+	case fd.IsList():
+		list := msg.Get(fd).List()
+		var elems []string
+		for i := 0; i < list.Len(); i++ {
+			text, err := encodeProtoValue(ctx, fd, list.Get(i))
+			if err != nil {
+				return "", fmt.Errorf("error encoding element %d of field %s: %w", i, fd.FullName(), err)
+			}
+			elems = append(elems, text)
+		}
+		return strings.Join(elems, ctx.ListSeparator()), nil
+	default:
+		if fd.Message() != nil && !msg.Has(fd) {
+			return "", nil
+		}
+		text, err := encodeProtoValue(ctx, fd, msg.Get(fd))
+		if err != nil {
+			return "", fmt.Errorf("error encoding field %s: %w", fd.FullName(), err)
+		}
+		return text, nil
+	}
+}
+
+// decodeProtoValue decodes a singular value of the kind of fd. newMessage
+// returns an empty message to decode into if fd is a message field.
+func decodeProtoValue(ctx *Context, fd protoreflect.FieldDescriptor, text string, newMessage func() protoreflect.Message) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var v bool
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var v int32
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfInt32(v), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var v int64
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var v uint32
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfUint32(v), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var v uint64
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		var v float32
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfFloat32(v), err
+	case protoreflect.DoubleKind:
+		var v float64
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		var v string
+		err := decodeGoValue(ctx, text, &v)
+		return protoreflect.ValueOfString(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(text)
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		n, err := decodeEnum(ctx, fd.Enum(), text)
+		return protoreflect.ValueOfEnum(n), err
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m, err := decodeMessage(ctx, text, newMessage())
+		return protoreflect.ValueOfMessage(m), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %v", fd.Kind())
+}
+
+// encodeProtoValue encodes a singular value of the kind of fd.
+func encodeProtoValue(ctx *Context, fd protoreflect.FieldDescriptor, v protoreflect.Value) (string, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return encodeGoValue(ctx, v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return encodeGoValue(ctx, int32(v.Int()))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return encodeGoValue(ctx, v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return encodeGoValue(ctx, uint32(v.Uint()))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return encodeGoValue(ctx, v.Uint())
+	case protoreflect.FloatKind:
+		return encodeGoValue(protoFloatContext(ctx), float32(v.Float()))
+	case protoreflect.DoubleKind:
+		return encodeGoValue(protoFloatContext(ctx), v.Float())
+	case protoreflect.StringKind:
+		return encodeGoValue(ctx, v.String())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+	case protoreflect.EnumKind:
+		return encodeEnum(ctx, fd.Enum(), v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return encodeMessage(ctx, v.Message())
+	}
+	return "", fmt.Errorf("unsupported kind %v", fd.Kind())
+}
+
+// protoFloatContext returns ctx, with the float format set to the shortest
+// text that decodes to the same value unless ctx sets a format, so that float
+// and double fields round trip by default.
+func protoFloatContext(ctx *Context) *Context {
+	if ctx.HasFloatFormat() {
+		return ctx
+	}
+	return ctx.WithFloatFormat('g', -1)
+}
+
+func decodeGoValue(ctx *Context, text string, dst T) error {
+	dec, err := ctx.Registry().LookupDecoder(reflect.TypeOf(dst).Elem())
+	if err != nil {
+		return err
+	}
+	return dec.DecodeText(ctx, text, dst)
+}
+
+func encodeGoValue(ctx *Context, value T) (string, error) {
+	enc, err := ctx.Registry().LookupEncoder(reflect.TypeOf(value))
+	if err != nil {
+		return "", err
+	}
+	return enc.EncodeText(ctx, value)
+}
+
+func encodeEnum(ctx *Context, ed protoreflect.EnumDescriptor, n protoreflect.EnumNumber) (string, error) {
+	if ev := ed.Values().ByNumber(n); ev != nil {
+		return string(ev.Name()), nil
+	}
+	return encodeGoValue(ctx, int32(n))
+}
+
+// decodeEnum returns the number of the enum value with the given name or
+// number. Numbers without a value are only accepted for proto3 enums, which
+// are open.
+func decodeEnum(ctx *Context, ed protoreflect.EnumDescriptor, text string) (protoreflect.EnumNumber, error) {
+	if ev := ed.Values().ByName(protoreflect.Name(text)); ev != nil {
+		return ev.Number(), nil
+	}
+	var n int32
+	if err := decodeGoValue(ctx, text, &n); err != nil {
+		return 0, fmt.Errorf("%q is neither the name nor the number of a value of enum %s", text, ed.FullName())
+	}
+	if ed.Values().ByNumber(protoreflect.EnumNumber(n)) == nil && ed.ParentFile().Syntax() != protoreflect.Proto3 {
+		return 0, fmt.Errorf("enum %s has no value with number %d", ed.FullName(), n)
+	}
+	return protoreflect.EnumNumber(n), nil
+}
+
+// encodeMessage encodes a well-known message or a message whose Go type has an
+// encoder in ctx's registry.
+func encodeMessage(ctx *Context, m protoreflect.Message) (string, error) {
+	if text, ok, err := encodeWellKnown(ctx, m); ok {
+		return text, err
+	}
+	return encodeGoValue(ctx, m.Interface())
+}
+
+// decodeMessage decodes text into m if it is a well-known message. Otherwise,
+// it returns a message decoded by the decoder of m's Go type in ctx's registry.
+func decodeMessage(ctx *Context, text string, m protoreflect.Message) (protoreflect.Message, error) {
+	if ok, err := decodeWellKnown(ctx, text, m); ok {
+		return m, err
+	}
+	dst := reflect.New(reflect.TypeOf(m.Interface()))
+	if err := decodeGoValue(ctx, text, dst.Interface()); err != nil {
+		return nil, err
+	}
+	decoded, ok := dst.Elem().Interface().(proto.Message)
+	if !ok || !decoded.ProtoReflect().IsValid() {
+		return nil, fmt.Errorf("decoder for %v did not produce a message", dst.Type().Elem())
+	}
+	return decoded.ProtoReflect(), nil
+}
+
+// encodeWellKnown encodes m if it is a Timestamp, Duration or wrapper message
+// and reports whether it is one of those types.
+func encodeWellKnown(ctx *Context, m protoreflect.Message) (string, bool, error) {
+	name := m.Descriptor().FullName()
+	fields := m.Descriptor().Fields()
+	switch {
+	case name == timestampName:
+		seconds, nanos := m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+		return formatTime(ctx, time.Unix(seconds, nanos).UTC()), true, nil
+	case name == durationName:
+		seconds, nanos := m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+		const maxSeconds = math.MaxInt64 / int64(time.Second)
+		if seconds > maxSeconds || seconds < -maxSeconds {
+			return "", true, fmt.Errorf("duration of %d seconds is out of the range of time.Duration", seconds)
+		}
+		return (time.Duration(seconds)*time.Second + time.Duration(nanos)).String(), true, nil
+	case wrapperNames[name]:
+		fd := fields.ByName("value")
+		text, err := encodeProtoValue(ctx, fd, m.Get(fd))
+		return text, true, err
+	}
+	return "", false, nil
+}
+
+// decodeWellKnown decodes text into m if it is a Timestamp, Duration or
+// wrapper message and reports whether it is one of those types.
+func decodeWellKnown(ctx *Context, text string, m protoreflect.Message) (bool, error) {
+	name := m.Descriptor().FullName()
+	fields := m.Descriptor().Fields()
+	switch {
+	case name == timestampName:
+		t, err := parseTime(ctx, text)
+		if err != nil {
+			return true, err
+		}
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return true, nil
+	case name == durationName:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return true, err
+		}
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+		return true, nil
+	case wrapperNames[name]:
+		fd := fields.ByName("value")
+		v, err := decodeProtoValue(ctx, fd, text, nil)
+		if err != nil {
+			return true, err
+		}
+		m.Set(fd, v)
+		return true, nil
+	}
+	return false, nil
+}
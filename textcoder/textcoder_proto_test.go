@@ -0,0 +1,238 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textcoder
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const recordProto = `
+syntax = "proto3";
+
+package textcoder.test;
+
+import "google/protobuf/duration.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+enum Color {
+  COLOR_UNSPECIFIED = 0;
+  RED = 1;
+  GREEN = 2;
+}
+
+message Inner {
+  string name = 1;
+}
+
+message Record {
+  bool flag = 1;
+  int32 i32 = 2;
+  sint64 s64 = 3;
+  fixed32 f32 = 4;
+  uint64 u64 = 5;
+  float f = 6;
+  double d = 7;
+  string s = 8;
+  bytes b = 9;
+  Color color = 10;
+  google.protobuf.Timestamp time = 11;
+  google.protobuf.Duration duration = 12;
+  google.protobuf.Int32Value maybe_int = 13;
+  google.protobuf.BytesValue maybe_bytes = 14;
+  repeated int32 numbers = 15;
+  repeated Color colors = 16;
+  repeated google.protobuf.Duration durations = 17;
+  map<string, int32> counts = 18;
+  map<int32, google.protobuf.Timestamp> times = 19;
+  Inner inner = 20;
+}
+`
+
+func recordDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"record.proto": recordProto}),
+	}
+	fdProtos, err := parser.ParseFilesButDoNotLink("record.proto")
+	if err != nil {
+		t.Fatalf("error parsing .proto file: %v", err)
+	}
+	fd, err := protodesc.NewFile(fdProtos[0], protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("error building file descriptor: %v", err)
+	}
+	return fd.Messages().ByName("Record")
+}
+
+func TestProtoFields(t *testing.T) {
+	md := recordDescriptor(t)
+	ctx := NewContext()
+	for _, tt := range []struct {
+		field string
+		ctx   *Context
+		// text is decoded into the field of an empty message, which should then
+		// equal want, and encoding the field should produce text again.
+		text string
+		want string
+	}{
+		{"flag", ctx, "true", `flag: true`},
+		{"flag", ctx.WithBoolValues("yes", "no"), "yes", `flag: true`},
+		{"i32", ctx, "-7", `i32: -7`},
+		{"s64", ctx.WithDigitGrouping(true), "-1,234,567", `s64: -1234567`},
+		{"f32", ctx.WithIntBase(16), "ff", `f32: 255`},
+		{"u64", ctx, "18446744073709551615", `u64: 18446744073709551615`},
+		{"f", ctx.WithFloatFormat('g', -1), "1.5", `f: 1.5`},
+		{"f", ctx, "0.1", `f: 0.1`},
+		{"d", ctx, "2.25", `d: 2.25`},
+		{"d", ctx, "1e-07", `d: 1e-7`},
+		{"d", ctx, "0.30000000000000004", `d: 0.30000000000000004`},
+		{"d", ctx.WithFloatFormat('f', 3), "2.250", `d: 2.25`},
+		{"s", ctx, "a, b", `s: "a, b"`},
+		{"b", ctx, "aGk=", `b: "hi"`},
+		{"color", ctx, "GREEN", `color: GREEN`},
+		{"color", ctx, "7", `color: 7`},
+		{"time", ctx, "2020-07-01T12:30:00.5Z", `time: {seconds: 1593606600 nanos: 500000000}`},
+		{"time", ctx.WithTimeLayout("2006-01-02"), "2020-07-01", `time: {seconds: 1593561600}`},
+		{"duration", ctx, "1m30.5s", `duration: {seconds: 90 nanos: 500000000}`},
+		{"duration", ctx, "-1.5s", `duration: {seconds: -1 nanos: -500000000}`},
+		{"maybe_int", ctx, "0", `maybe_int: {}`},
+		{"maybe_int", ctx, "", ``},
+		{"maybe_bytes", ctx, "aGk=", `maybe_bytes: {value: "hi"}`},
+		{"numbers", ctx, "1,2,3", `numbers: [1, 2, 3]`},
+		{"numbers", ctx.WithListSeparator(" "), "1 2", `numbers: [1, 2]`},
+		{"numbers", ctx, "", ``},
+		{"colors", ctx, "RED,3", `colors: [RED, 3]`},
+		{"durations", ctx, "1s,2m0s", `durations: [{seconds: 1}, {seconds: 120}]`},
+		{"counts", ctx, "a=1,b=2", `counts: [{key: "a" value: 1}, {key: "b" value: 2}]`},
+		{"times", ctx.WithMapSeparators(";", ": ").WithTimeLayout("2006"), "1: 2020;2: 2021", `times: [{key: 1 value: {seconds: 1577836800}}, {key: 2 value: {seconds: 1609459200}}]`},
+	} {
+		t.Run(tt.field+"="+tt.text, func(t *testing.T) {
+			fd := md.Fields().ByName(protoreflect.Name(tt.field))
+			msg := dynamicpb.NewMessage(md)
+			if err := UnmarshalProtoField(tt.ctx, msg, fd, tt.text); err != nil {
+				t.Fatalf("UnmarshalProtoField(%q) failed: %v", tt.text, err)
+			}
+			want := dynamicpb.NewMessage(md)
+			if err := prototext.Unmarshal([]byte(tt.want), want); err != nil {
+				t.Fatalf("error parsing wanted message: %v", err)
+			}
+			if diff := cmp.Diff(want, msg, protocmp.Transform()); diff != "" {
+				t.Errorf("unexpected diff from UnmarshalProtoField(%q) (-want, +got):\n%s", tt.text, diff)
+			}
+			got, err := MarshalProtoField(tt.ctx, msg, fd)
+			if err != nil {
+				t.Fatalf("MarshalProtoField failed: %v", err)
+			}
+			if got != tt.text {
+				t.Errorf("MarshalProtoField() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestUnmarshalProtoField_errors(t *testing.T) {
+	md := recordDescriptor(t)
+	for _, tt := range []struct {
+		field, text string
+		wantErr     *regexp.Regexp
+	}{
+		{"i32", "x", regexp.MustCompile(`error decoding field textcoder.test.Record.i32: .*invalid syntax`)},
+		{"u64", "-1", regexp.MustCompile(`invalid syntax`)},
+		{"b", "!", regexp.MustCompile(`illegal base64 data`)},
+		{"color", "BLUE", regexp.MustCompile(`"BLUE" is neither the name nor the number of a value of enum textcoder.test.Color`)},
+		{"time", "yesterday", regexp.MustCompile(`cannot parse "yesterday"`)},
+		{"numbers", "1,,3", regexp.MustCompile(`error decoding element 1 of field textcoder.test.Record.numbers`)},
+		{"counts", "a=1,a=2", regexp.MustCompile(`duplicate key "a" in field textcoder.test.Record.counts`)},
+		{"counts", "a", regexp.MustCompile(`entry "a" of field textcoder.test.Record.counts has no "=" separating its key and value`)},
+		{"inner", "x", regexp.MustCompile(`no text decoder for type \*dynamicpb.Message`)},
+	} {
+		t.Run(tt.field+"="+tt.text, func(t *testing.T) {
+			msg := dynamicpb.NewMessage(md)
+			msg.Set(md.Fields().ByName("s"), protoreflect.ValueOfString("unchanged"))
+			before := proto.Clone(msg)
+			err := UnmarshalProtoField(nil, msg, md.Fields().ByName(protoreflect.Name(tt.field)), tt.text)
+			if diff := cmp.Diff(before, msg, protocmp.Transform()); diff != "" {
+				t.Errorf("UnmarshalProtoField modified the message after an error (-before, +after):\n%s", diff)
+			}
+			checkErr(t, err, tt.wantErr, "UnmarshalProtoField")
+		})
+	}
+}
+
+func TestProtoTypes(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value interface{}
+		text  string
+	}{
+		{"enum", descriptorpb.FieldDescriptorProto_TYPE_STRING, "TYPE_STRING"},
+		{"timestamp", timestamppb.New(time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC)), "2020-07-01T12:30:00Z"},
+		{"nil timestamp", (*timestamppb.Timestamp)(nil), ""},
+		{"duration", durationpb.New(90 * time.Second), "1m30s"},
+		{"wrapper", wrapperspb.Double(0.5), "0.5"},
+		{"string wrapper", wrapperspb.String("x"), "x"},
+		{"enum slice", []descriptorpb.FieldDescriptorProto_Label{descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED}, "LABEL_OPTIONAL,LABEL_REPEATED"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("Marshal(%v) failed: %v", tt.value, err)
+			}
+			if got != tt.text {
+				t.Errorf("Marshal(%v) = %q, want %q", tt.value, got, tt.text)
+			}
+		})
+	}
+
+	var typ descriptorpb.FieldDescriptorProto_Type
+	if err := Unmarshal("9", &typ); err != nil || typ != descriptorpb.FieldDescriptorProto_TYPE_STRING {
+		t.Errorf("Unmarshal(%q) = (%v, %v), want TYPE_STRING", "9", typ, err)
+	}
+	if err := Unmarshal("99", &typ); err == nil {
+		t.Errorf("Unmarshal(%q) succeeded for a proto2 enum with no such value", "99")
+	}
+	var e protoreflect.Enum
+	if err := Unmarshal("9", &e); err == nil {
+		t.Errorf("Unmarshal(%q) succeeded for a nil protoreflect.Enum", "9")
+	}
+	var ts *timestamppb.Timestamp
+	if err := Unmarshal("2020-07-01T12:30:00Z", &ts); err != nil || ts.GetSeconds() != 1593606600 {
+		t.Errorf("Unmarshal() = (%v, %v), want 1593606600 seconds", ts, err)
+	}
+	if err := Unmarshal("", &ts); err != nil || ts != nil {
+		t.Errorf("Unmarshal(%q) = (%v, %v), want nil", "", ts, err)
+	}
+	var wrapper *wrapperspb.BoolValue
+	if err := UnmarshalContext(NewContext().WithBoolValues("Y", "N"), "N", &wrapper); err != nil || wrapper == nil || wrapper.GetValue() {
+		t.Errorf("UnmarshalContext(%q) = (%v, %v), want false", "N", wrapper, err)
+	}
+}